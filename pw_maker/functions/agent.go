@@ -0,0 +1,73 @@
+package functions
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+type AgentOptions struct {
+	Timeout time.Duration
+}
+
+// RunAgent caches the vault keys for the commands of this user until it is
+// stopped with "fortpass lock", interrupted, or unused for opts.Timeout.
+func RunAgent(opts AgentOptions) {
+	if opts.Timeout <= 0 {
+		fmt.Println(utils.StyleError.Render("❌ The timeout must be positive"))
+		return
+	}
+	agent, err := utils.NewAgent(opts.Timeout)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error starting agent: " + err.Error()))
+		return
+	}
+	// Commands get the keys from the agent from now on
+	utils.DB.Close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		select {
+		case <-signals:
+			agent.Lock()
+		case <-agent.Locked():
+		}
+	}()
+
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("🔑 The agent caches the vault keys on %s, it stops after %s without use", utils.AgentSocketPath(), opts.Timeout)))
+	err = agent.Serve(func(err error) {
+		fmt.Fprintln(os.Stderr, utils.StyleError.Render("❌ "+err.Error()))
+	})
+	if err != nil {
+		agent.Lock()
+		fmt.Println(utils.StyleError.Render("❌ Agent stopped: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleInfo.Render("🔒 The agent wiped the cached keys and stopped"))
+}
+
+// AgentStatus prints whether an agent caches the vault keys and until when.
+func AgentStatus() {
+	status, err := utils.CallAgent(utils.AgentOpStatus)
+	if err != nil {
+		fmt.Println(utils.StylePrompt.Render("No agent is running, the vault key is read from the system keyring"))
+		return
+	}
+	fmt.Println(utils.StyleInfo.Render(fmt.Sprintf("ℹ️ The agent caches the keys of %s until %s unless it is used again",
+		status.DBPath, status.LocksAt.Format("15:04:05"))))
+}
+
+// LockAgent makes the agent wipe the cached keys and stop. The vault stays
+// readable with the keys in the system keyring.
+func LockAgent() {
+	if _, err := utils.CallAgent(utils.AgentOpLock); err != nil {
+		fmt.Println(utils.StylePrompt.Render("No agent is running, commands read the keys from the system keyring"))
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render("🔒 The agent wiped the cached keys and stopped, commands read them from the system keyring again"))
+}
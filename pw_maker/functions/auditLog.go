@@ -0,0 +1,102 @@
+package functions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+type AuditLogOptions struct {
+	Action string
+	Entry  string
+	User   string
+	Since  string
+	Limit  int
+}
+
+// audit records an event in the audit log. A failure to log does not undo
+// the operation, but it is reported.
+func audit(action string, entryID int64, entry, details string) {
+	if err := utils.Audit(utils.DB, action, entryID, entry, details); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error writing audit log: " + err.Error()))
+	}
+}
+
+// ShowAuditLog prints the events of the audit log matching opts.
+func ShowAuditLog(opts AuditLogOptions) {
+	filter := utils.AuditFilter{Action: opts.Action, Entry: opts.Entry, User: opts.User, Limit: opts.Limit}
+	if opts.Since != "" {
+		since, err := parseSince(opts.Since, time.Now())
+		if err != nil {
+			fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+			return
+		}
+		filter.Since = since
+	}
+
+	events, err := utils.ReadAuditLog(filter)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading audit log: " + err.Error()))
+		return
+	}
+	if len(events) == 0 {
+		fmt.Println(utils.StylePrompt.Render("No matching events in the audit log."))
+		return
+	}
+
+	for _, e := range events {
+		line := fmt.Sprintf("%5d  %s  %-14s %s@%s", e.ID, e.CreatedAt, e.Action, e.User, e.Host)
+		if e.Entry != "" {
+			line += "  " + e.Entry
+			if e.EntryID != 0 {
+				line += fmt.Sprintf(" (#%d)", e.EntryID)
+			}
+		}
+		if e.Details != "" {
+			line += "  " + utils.StyleInfo.Render(e.Details)
+		}
+		fmt.Println(line)
+	}
+}
+
+// VerifyAuditLog checks the hash chain of the audit log.
+func VerifyAuditLog() {
+	result, err := utils.VerifyAuditLog()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading audit log: " + err.Error()))
+		return
+	}
+	if len(result.Problems) > 0 {
+		fmt.Println(utils.StyleError.Render(fmt.Sprintf("❌ The audit log was tampered with, %d problems found:", len(result.Problems))))
+		for _, p := range result.Problems {
+			fmt.Println(utils.StyleError.Render("  • " + p))
+		}
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Audit log intact, %d events verified", result.Events)))
+	if result.Head != "" {
+		fmt.Println(utils.StyleInfo.Render("ℹ️ Latest event hash: " + result.Head))
+	}
+}
+
+// parseSince accepts a date, a date and time, or a duration before now such
+// as 12h or 7d.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q, use a date like 2024-01-31 or a duration like 12h or 7d", s)
+}
@@ -2,57 +2,67 @@ package functions
 
 import (
 	"fmt"
-	"io"
 	"os"
-	"time"
 
 	"github.com/tadeasf/pw_maker/pw_maker/utils"
-
-	"github.com/spf13/cobra"
 )
 
-var BackupCmd = &cobra.Command{
-	Use:   "backup [destination]",
-	Short: "Backup the password database",
-	Args:  cobra.MaximumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		var destination string
-		if len(args) > 0 {
-			destination = args[0]
-		} else {
-			destination = fmt.Sprintf("fortpass_backup_%s.db", time.Now().Format("20060102_150405"))
+// BackupDatabase takes a hot backup of the vault. Without a destination, or
+// when the destination is a directory, a timestamped backup is written into
+// it and older backups there are pruned according to retention.
+func BackupDatabase(destination string, retention utils.Retention) {
+	dir := ""
+	switch {
+	case destination == "":
+		dir = utils.BackupDir()
+	default:
+		if info, err := os.Stat(destination); err == nil && info.IsDir() {
+			dir = destination
 		}
-		BackupDatabase(destination)
-	},
-}
-
-func BackupDatabase(destination string) {
-	// Close the current database connection
-	utils.DB.Close()
+	}
+	if dir != "" {
+		destination = utils.NewBackupPath(dir, "")
+	}
 
-	// Copy the database file
-	src, err := os.Open(utils.DBPath)
-	if err != nil {
-		fmt.Println(utils.StyleError.Render("❌ Error opening source database: " + err.Error()))
+	if _, err := os.Stat(destination); err == nil {
+		fmt.Println(utils.StyleError.Render("❌ Backup destination already exists: " + destination))
 		return
 	}
-	defer src.Close()
 
-	dst, err := os.Create(destination)
+	err := utils.CreateBackup(destination)
 	if err != nil {
-		fmt.Println(utils.StyleError.Render("❌ Error creating backup file: " + err.Error()))
+		fmt.Println(utils.StyleError.Render("❌ Error backing up database: " + err.Error()))
 		return
 	}
-	defer dst.Close()
 
-	_, err = io.Copy(dst, src)
+	audit("backup", 0, "", "written to "+destination)
+	fmt.Println(utils.StyleSuccess.Render("✅ Database backed up and verified successfully to: " + destination))
+
+	if dir == "" {
+		return
+	}
+	removed, err := utils.PruneBackups(dir, retention)
 	if err != nil {
-		fmt.Println(utils.StyleError.Render("❌ Error copying database: " + err.Error()))
+		fmt.Println(utils.StyleError.Render("❌ Error pruning old backups: " + err.Error()))
 		return
 	}
+	for _, b := range removed {
+		fmt.Println(utils.StyleInfo.Render("🗑️  Removed old backup " + b.Path))
+	}
+}
 
-	fmt.Println(utils.StyleSuccess.Render("✅ Database backed up successfully to: " + destination))
-
-	// Reopen the database connection
-	utils.InitDB()
+// autoBackup takes a backup before a destructive operation. It returns false
+// when the backup failed and the operation should not continue.
+func autoBackup(reason string) bool {
+	path, err := utils.AutoBackup(reason)
+	if err != nil && path == "" {
+		fmt.Println(utils.StyleError.Render("❌ Error creating automatic backup, aborting: " + err.Error()))
+		return false
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error pruning old backups: " + err.Error()))
+	}
+	audit("backup", 0, "", "automatic backup before "+reason+" written to "+path)
+	fmt.Println(utils.StyleInfo.Render("💾 Automatic backup written to " + path))
+	return true
 }
@@ -0,0 +1,319 @@
+package functions
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+// Completion functions for the arguments of commands. They only read the
+// columns that are stored in plain text, such as sources, usernames,
+// folders and tags, and never print errors, which would end up among the
+// completions: a vault that cannot be read has nothing to complete.
+
+// completeOnce returns complete for the first argument only. Commands
+// completed with it take a single vault argument.
+func completeOnce(complete func(toComplete string) []string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return complete(toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// CompleteEntries completes entries as source/username. The entries of
+// team vaults are encrypted as a whole, so nothing is completed with
+// --team.
+func CompleteEntries(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 || teamOptionFlag(cmd) != "" {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return entryCompletions(toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// CompleteEntryAndFile completes an entry, then a file such as the
+// executable of a rotation hook.
+func CompleteEntryAndFile(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return entryCompletions(toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveDefault
+}
+
+// CompleteTrashedEntries completes entries in the trash.
+var CompleteTrashedEntries = completeOnce(trashCompletions)
+
+// CompleteFolders completes folders, also for flags.
+var CompleteFolders = completeOnce(folderCompletions)
+
+// CompleteRotationTargets completes entries and folders, and / for the
+// whole vault.
+var CompleteRotationTargets = completeOnce(func(toComplete string) []string {
+	return append(append(entryCompletions(toComplete), folderCompletions(toComplete)...), "/\tthe whole vault")
+})
+
+// CompleteEntriesAndDomains completes entries and the domains of entry
+// URLs and generator policies.
+var CompleteEntriesAndDomains = completeOnce(func(toComplete string) []string {
+	return append(entryCompletions(toComplete), domainCompletions(toComplete)...)
+})
+
+// CompleteMove completes the entry or folder to move, then the folder to
+// move it into.
+func CompleteMove(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return append(entryCompletions(toComplete), folderCompletions(toComplete)...), cobra.ShellCompDirectiveNoFileComp
+	case 1:
+		return append(folderCompletions(toComplete), "/\tthe top level"), cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// CompleteTag completes the entry, then the tags in use, or with --remove
+// the tags of the entry.
+func CompleteTag(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return entryCompletions(toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+	tags := tagCompletions()
+	if remove, _ := cmd.Flags().GetBool("remove"); remove {
+		tags = nil
+		if source, username, ok := strings.Cut(args[0], "/"); ok {
+			var stored string
+			utils.DB.QueryRow("SELECT tags FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL", source, username).Scan(&stored)
+			tags = utils.ParseTags(stored)
+		}
+	}
+	var completions []string
+	for _, tag := range tags {
+		if !slices.Contains(args[1:], tag) {
+			completions = append(completions, tag)
+		}
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// CompleteSearch completes the field filters of search queries and the
+// values of folder: and tag:, with an optional leading - or ! to exclude
+// them.
+func CompleteSearch(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	negation := ""
+	if strings.HasPrefix(toComplete, "-") || strings.HasPrefix(toComplete, "!") {
+		negation = toComplete[:1]
+	}
+	var completions []string
+	field, value, ok := strings.Cut(strings.TrimPrefix(toComplete, negation), ":")
+	if !ok {
+		for _, field := range []string{"source:", "user:", "url:", "folder:", "tag:"} {
+			completions = append(completions, negation+field)
+		}
+		return completions, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+	var values []string
+	switch field {
+	case "folder":
+		values = folderCompletions(value)
+	case "tag":
+		values = tagCompletions()
+	}
+	for _, v := range values {
+		completions = append(completions, negation+field+":"+v)
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// CompleteTeams completes the names of the team vaults this user joined.
+func CompleteTeams(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	paths, err := utils.ListSettings(teamPathSetting)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// CompleteTeamMember completes a member of the selected team vault, then
+// a role for "team role".
+func CompleteTeamMember(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch {
+	case len(args) == 0:
+		return teamMemberCompletions(cmd, nil), cobra.ShellCompDirectiveNoFileComp
+	case len(args) == 1 && cmd.Name() == "role":
+		return utils.TeamRoles, cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// CompleteTeamReaders completes the members given access by "team acl"
+// after the entry or folder.
+func CompleteTeamReaders(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return teamMemberCompletions(cmd, args[1:]), cobra.ShellCompDirectiveNoFileComp
+}
+
+// teamMemberCompletions returns the members of the team selected with
+// --team or the default team, leaving out the given ones. Member names
+// and roles are not encrypted.
+func teamMemberCompletions(cmd *cobra.Command, exclude []string) []string {
+	name := teamOptionFlag(cmd)
+	if name == "" {
+		name = utils.GetSetting(teamDefaultSetting, "")
+	}
+	vault, err := utils.LoadTeamVault(utils.GetSetting(teamPathSetting+name, ""))
+	if err != nil {
+		return nil
+	}
+	var members []string
+	for _, m := range vault.Members {
+		if !slices.Contains(exclude, m.Name) {
+			members = append(members, completion(m.Name, m.Role))
+		}
+	}
+	return members
+}
+
+// teamOptionFlag returns the --team flag of cmd, which team commands
+// inherit from the team command.
+func teamOptionFlag(cmd *cobra.Command) string {
+	if flag := cmd.Flag("team"); flag != nil {
+		return flag.Value.String()
+	}
+	return ""
+}
+
+func entryCompletions(toComplete string) []string {
+	rows, err := utils.DB.Query("SELECT source, username, url FROM passwords WHERE deleted_at IS NULL ORDER BY source, username")
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var completions []string
+	for rows.Next() {
+		var source, username, url string
+		if rows.Scan(&source, &username, &url) != nil {
+			return completions
+		}
+		if name := source + "/" + username; strings.HasPrefix(name, toComplete) {
+			completions = append(completions, completion(name, url))
+		}
+	}
+	return completions
+}
+
+func trashCompletions(toComplete string) []string {
+	entries, err := utils.ListTrash()
+	if err != nil {
+		return nil
+	}
+	var completions []string
+	for _, e := range entries {
+		if name := e.Source + "/" + e.Username; strings.HasPrefix(name, toComplete) {
+			completions = append(completions, completion(name, "deleted "+e.DeletedAt.Format("2006-01-02")))
+		}
+	}
+	return completions
+}
+
+// folderCompletions returns the folders of entries and their parents.
+func folderCompletions(toComplete string) []string {
+	rows, err := utils.DB.Query("SELECT DISTINCT folder FROM passwords WHERE deleted_at IS NULL AND folder != ''")
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var folder string
+		if rows.Scan(&folder) != nil {
+			break
+		}
+		for _, f := range utils.FolderAncestors(folder) {
+			seen[f] = true
+		}
+	}
+	var folders []string
+	for folder := range seen {
+		if strings.HasPrefix(folder, toComplete) {
+			folders = append(folders, completion(folder, "folder"))
+		}
+	}
+	sort.Strings(folders)
+	return folders
+}
+
+func tagCompletions() []string {
+	rows, err := utils.DB.Query("SELECT DISTINCT tags FROM passwords WHERE deleted_at IS NULL AND tags != ''")
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var tags string
+		if rows.Scan(&tags) != nil {
+			break
+		}
+		for _, tag := range utils.ParseTags(tags) {
+			seen[tag] = true
+		}
+	}
+	tags := make([]string, 0, len(seen))
+	for tag := range seen {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// domainCompletions returns the domains of entry URLs and of generator
+// policies.
+func domainCompletions(toComplete string) []string {
+	seen := make(map[string]bool)
+	if domains, _, err := utils.DomainGeneratorPolicies(utils.DB); err == nil {
+		for _, domain := range domains {
+			seen[domain] = true
+		}
+	}
+	if rows, err := utils.DB.Query("SELECT DISTINCT url FROM passwords WHERE deleted_at IS NULL AND url != ''"); err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var url string
+			if rows.Scan(&url) != nil {
+				break
+			}
+			if domain := utils.PolicyDomain(url); domain != "" {
+				seen[domain] = true
+			}
+		}
+	}
+	var domains []string
+	for domain := range seen {
+		if strings.HasPrefix(domain, toComplete) {
+			domains = append(domains, completion(domain, "domain"))
+		}
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// completion returns value with a description shells show next to it.
+func completion(value, description string) string {
+	if description == "" {
+		return value
+	}
+	return value + "\t" + description
+}
@@ -1,6 +1,6 @@
-// Func: DeletePassword(name string)
-// DeletePassword deletes a password from the database
-// It takes a string in the format of "source/username" and deletes the password from the database
+// Func: DeletePassword(name string, opts DeleteOptions)
+// DeletePassword moves a password, with every URL it is stored for, into the trash
+// It takes a string in the format of "source/username" and asks for confirmation unless opts.Yes is set
 package functions
 
 import (
@@ -17,11 +17,15 @@ var DeleteCmd = &cobra.Command{
 	Short: "Delete a specific password",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		DeletePassword(args[0])
+		DeletePassword(args[0], DeleteOptions{})
 	},
 }
 
-func DeletePassword(name string) {
+type DeleteOptions struct {
+	Yes bool
+}
+
+func DeletePassword(name string, opts DeleteOptions) {
 	parts := strings.Split(name, "/")
 	if len(parts) != 2 {
 		fmt.Println(utils.StyleError.Render("❌ Invalid password name format. Use 'source/username'."))
@@ -30,16 +34,79 @@ func DeletePassword(name string) {
 
 	source, username := parts[0], parts[1]
 
-	result, err := utils.DB.Exec("DELETE FROM passwords WHERE source = ? AND username = ?", source, username)
+	ids, urls, err := findLiveEntries(source, username)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error checking password existence: " + err.Error()))
+		return
+	}
+	if len(ids) == 0 {
+		fmt.Println(utils.StyleError.Render(fmt.Sprintf("❌ No password found for %s/%s", source, username)))
+		return
+	}
+
+	prompt := fmt.Sprintf("Move the password for %s/%s to the trash? (y/n)", source, username)
+	if len(ids) > 1 {
+		prompt = fmt.Sprintf("Move the %d passwords for %s/%s (%s) to the trash? (y/n)", len(ids), source, username, strings.Join(urls, ", "))
+	}
+	if !opts.Yes && !confirm(prompt) {
+		fmt.Println(utils.StylePrompt.Render("👋 Nothing was deleted."))
+		return
+	}
+
+	tx, err := utils.DB.Begin()
 	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error starting transaction: " + err.Error()))
+		return
+	}
+	for _, id := range ids {
+		err = utils.TrashEntry(tx, id)
+		if err == nil {
+			err = utils.Audit(tx, "delete", id, source+"/"+username, "moved to the trash")
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
 		fmt.Println(utils.StyleError.Render("❌ Error deleting password: " + err.Error()))
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		fmt.Println(utils.StyleError.Render(fmt.Sprintf("❌ No password found for %s/%s", source, username)))
+	if len(ids) > 1 {
+		fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ %d passwords for %s/%s moved to the trash", len(ids), source, username)))
 	} else {
-		fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Password for %s/%s deleted successfully", source, username)))
+		fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Password for %s/%s moved to the trash", source, username)))
+	}
+	fmt.Println(utils.StyleInfo.Render(fmt.Sprintf("   To undo run: fortpass trash restore %s/%s", source, username)))
+}
+
+// findLiveEntries returns the ids and URLs of the entries for source and
+// username that are not in the trash. The same account can be stored for
+// several URLs.
+func findLiveEntries(source, username string) ([]int64, []string, error) {
+	rows, err := utils.DB.Query("SELECT id, url FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL ORDER BY url", source, username)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var urls []string
+	for rows.Next() {
+		var id int64
+		var url string
+		if err := rows.Scan(&id, &url); err != nil {
+			return nil, nil, err
+		}
+		if url == "" {
+			url = "no URL"
+		}
+		ids = append(ids, id)
+		urls = append(urls, url)
 	}
+	return ids, urls, rows.Err()
 }
@@ -0,0 +1,483 @@
+package functions
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+// ExportFields lists the fields that can be selected with --fields, in the
+// order they are written to CSV. The default selection matches the column
+// order expected by `import`.
+var ExportFields = []string{"source", "url", "username", "password", "folder", "tags", "notes", "created_at", "updated_at"}
+
+var DefaultExportFields = []string{"source", "url", "username", "password"}
+
+var ExportFormats = []string{"csv", "json", "bitwarden-json", "keepass-xml", "encrypted"}
+
+type ExportOptions struct {
+	Format   string
+	Fields   []string
+	Source   string
+	Username string
+	URL      string
+	Since    string
+}
+
+// ExportEntry is the JSON representation of an entry, shared by the json
+// export and the payload of encrypted archives.
+type ExportEntry struct {
+	Source    string     `json:"source,omitempty"`
+	URL       string     `json:"url,omitempty"`
+	Username  string     `json:"username,omitempty"`
+	Password  string     `json:"password,omitempty"`
+	Folder    string     `json:"folder,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	Notes     string     `json:"notes,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// ExportDocument is the plaintext payload of an encrypted export.
+type ExportDocument struct {
+	ExportedAt time.Time     `json:"exported_at"`
+	Entries    []ExportEntry `json:"entries"`
+}
+
+func ExportPasswords(destination string, opts ExportOptions) {
+	requested := opts.Fields
+	if opts.Format == "encrypted" {
+		// Encrypted archives are backups and always hold every field.
+		if len(requested) > 0 {
+			fmt.Println(utils.StyleInfo.Render("ℹ️ Encrypted exports always contain every field, --fields is ignored"))
+		}
+		requested = []string{"all"}
+	}
+	fields, err := selectExportFields(requested)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	credentials, err := utils.GetCredentials()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error fetching passwords: " + err.Error()))
+		return
+	}
+
+	credentials, err = filterCredentials(credentials, opts)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	if len(credentials) == 0 {
+		fmt.Println(utils.StylePrompt.Render("No passwords match the given filters, nothing exported."))
+		return
+	}
+
+	entries := make([]ExportEntry, len(credentials))
+	for i, c := range credentials {
+		entries[i] = toExportEntry(c, fields)
+	}
+
+	var data []byte
+	switch opts.Format {
+	case "csv":
+		data, err = exportCSV(entries, fields)
+	case "json":
+		data, err = json.MarshalIndent(entries, "", "  ")
+	case "bitwarden-json":
+		data, err = exportBitwarden(entries)
+	case "keepass-xml":
+		data, err = exportKeePass(entries)
+	case "encrypted":
+		data, err = exportEncrypted(entries)
+	default:
+		err = fmt.Errorf("unknown export format %q (available: %s)", opts.Format, strings.Join(ExportFormats, ", "))
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error exporting passwords: " + err.Error()))
+		return
+	}
+
+	err = os.WriteFile(destination, data, 0600)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error writing export file: " + err.Error()))
+		return
+	}
+
+	if err := auditExport(credentials, fmt.Sprintf("as %s to %s", opts.Format, destination)); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error writing audit log: " + err.Error()))
+	}
+
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Exported %d passwords as %s to: %s", len(entries), opts.Format, destination)))
+	if opts.Format != "encrypted" {
+		fmt.Println(utils.StylePrompt.Render("⚠️  The export contains plaintext data, store it securely and delete it when done."))
+	}
+}
+
+func selectExportFields(requested []string) (map[string]bool, error) {
+	if len(requested) == 0 {
+		requested = DefaultExportFields
+	}
+
+	fields := make(map[string]bool)
+	for _, name := range requested {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			for _, f := range ExportFields {
+				fields[f] = true
+			}
+			continue
+		}
+		known := false
+		for _, f := range ExportFields {
+			if f == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown field %q (available: %s)", name, strings.Join(ExportFields, ", "))
+		}
+		fields[name] = true
+	}
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no fields selected")
+	}
+	return fields, nil
+}
+
+func filterCredentials(credentials []utils.Credential, opts ExportOptions) ([]utils.Credential, error) {
+	var since time.Time
+	if opts.Since != "" {
+		var err error
+		since, err = time.Parse("2006-01-02", opts.Since)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --since date %q, use YYYY-MM-DD", opts.Since)
+		}
+	}
+
+	contains := func(value, pattern string) bool {
+		return pattern == "" || strings.Contains(strings.ToLower(value), strings.ToLower(pattern))
+	}
+
+	var filtered []utils.Credential
+	for _, c := range credentials {
+		if !contains(c.Source, opts.Source) || !contains(c.Username, opts.Username) || !contains(c.URL, opts.URL) {
+			continue
+		}
+		if !since.IsZero() && c.UpdatedAt.Before(since) {
+			continue
+		}
+		filtered = append(filtered, c)
+	}
+	return filtered, nil
+}
+
+func toExportEntry(c utils.Credential, fields map[string]bool) ExportEntry {
+	var e ExportEntry
+	if fields["source"] {
+		e.Source = c.Source
+	}
+	if fields["url"] {
+		e.URL = c.URL
+	}
+	if fields["username"] {
+		e.Username = c.Username
+	}
+	if fields["password"] {
+		e.Password = c.Password
+	}
+	if fields["folder"] {
+		e.Folder = c.Folder
+	}
+	if fields["tags"] {
+		e.Tags = c.Tags
+	}
+	if fields["notes"] {
+		e.Notes = c.Notes
+	}
+	if fields["created_at"] {
+		createdAt := c.CreatedAt.UTC()
+		e.CreatedAt = &createdAt
+	}
+	if fields["updated_at"] {
+		updatedAt := c.UpdatedAt.UTC()
+		e.UpdatedAt = &updatedAt
+	}
+	return e
+}
+
+func exportCSV(entries []ExportEntry, fields map[string]bool) ([]byte, error) {
+	var header []string
+	for _, f := range ExportFields {
+		if fields[f] {
+			header = append(header, f)
+		}
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		record := make([]string, 0, len(header))
+		for _, f := range header {
+			switch f {
+			case "source":
+				record = append(record, e.Source)
+			case "url":
+				record = append(record, e.URL)
+			case "username":
+				record = append(record, e.Username)
+			case "password":
+				record = append(record, e.Password)
+			case "folder":
+				record = append(record, e.Folder)
+			case "tags":
+				record = append(record, utils.FormatTags(e.Tags))
+			case "notes":
+				record = append(record, e.Notes)
+			case "created_at":
+				record = append(record, e.CreatedAt.Format(time.RFC3339))
+			case "updated_at":
+				record = append(record, e.UpdatedAt.Format(time.RFC3339))
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+type bitwardenExport struct {
+	Encrypted bool              `json:"encrypted"`
+	Folders   []bitwardenFolder `json:"folders"`
+	Items     []bitwardenItem   `json:"items"`
+}
+
+// bitwardenFolder is a folder of a Bitwarden export. Nested folders are
+// named with their full path, separated by slashes.
+type bitwardenFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bitwardenItem struct {
+	Type         int            `json:"type"`
+	Name         string         `json:"name"`
+	Notes        *string        `json:"notes"`
+	Favorite     bool           `json:"favorite"`
+	Login        bitwardenLogin `json:"login"`
+	CreationDate *time.Time     `json:"creationDate,omitempty"`
+	RevisionDate *time.Time     `json:"revisionDate,omitempty"`
+	FolderID     *string        `json:"folderId"`
+}
+
+type bitwardenLogin struct {
+	Username string         `json:"username"`
+	Password string         `json:"password"`
+	URIs     []bitwardenURI `json:"uris"`
+	Totp     *string        `json:"totp"`
+}
+
+type bitwardenURI struct {
+	Match *int   `json:"match"`
+	URI   string `json:"uri"`
+}
+
+func exportBitwarden(entries []ExportEntry) ([]byte, error) {
+	doc := bitwardenExport{Folders: []bitwardenFolder{}, Items: make([]bitwardenItem, 0, len(entries))}
+	folderIDs := make(map[string]string)
+	for _, e := range entries {
+		item := bitwardenItem{
+			Type: 1,
+			Name: e.Source,
+			Login: bitwardenLogin{
+				Username: e.Username,
+				Password: e.Password,
+				URIs:     []bitwardenURI{},
+			},
+			CreationDate: e.CreatedAt,
+			RevisionDate: e.UpdatedAt,
+		}
+		if e.URL != "" {
+			item.Login.URIs = append(item.Login.URIs, bitwardenURI{URI: e.URL})
+		}
+		if e.Notes != "" {
+			item.Notes = &e.Notes
+		}
+		if e.Folder != "" {
+			id, ok := folderIDs[e.Folder]
+			if !ok {
+				id = fmt.Sprintf("fortpass-folder-%d", len(folderIDs)+1)
+				folderIDs[e.Folder] = id
+				doc.Folders = append(doc.Folders, bitwardenFolder{ID: id, Name: e.Folder})
+			}
+			item.FolderID = &id
+		}
+		doc.Items = append(doc.Items, item)
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+type keePassFile struct {
+	XMLName xml.Name    `xml:"KeePassFile"`
+	Meta    keePassMeta `xml:"Meta"`
+	Root    keePassRoot `xml:"Root"`
+}
+
+type keePassMeta struct {
+	Generator    string `xml:"Generator"`
+	DatabaseName string `xml:"DatabaseName"`
+}
+
+type keePassRoot struct {
+	Group keePassGroup `xml:"Group"`
+}
+
+type keePassGroup struct {
+	Name    string         `xml:"Name"`
+	Entries []keePassEntry `xml:"Entry"`
+	Groups  []keePassGroup `xml:"Group"`
+}
+
+type keePassEntry struct {
+	Times   *keePassTimes   `xml:"Times,omitempty"`
+	Tags    string          `xml:"Tags,omitempty"`
+	Strings []keePassString `xml:"String"`
+}
+
+type keePassTimes struct {
+	CreationTime         string `xml:"CreationTime,omitempty"`
+	LastModificationTime string `xml:"LastModificationTime,omitempty"`
+}
+
+type keePassString struct {
+	Key   string       `xml:"Key"`
+	Value keePassValue `xml:"Value"`
+}
+
+type keePassValue struct {
+	Value           string `xml:",chardata"`
+	ProtectInMemory string `xml:"ProtectInMemory,attr,omitempty"`
+}
+
+func exportKeePass(entries []ExportEntry) ([]byte, error) {
+	doc := keePassFile{
+		Meta: keePassMeta{Generator: "fortpass", DatabaseName: "fortpass"},
+		Root: keePassRoot{Group: keePassGroup{Name: "fortpass"}},
+	}
+	for _, e := range entries {
+		entry := keePassEntry{Tags: utils.FormatTags(e.Tags), Strings: []keePassString{
+			{Key: "Title", Value: keePassValue{Value: e.Source}},
+			{Key: "UserName", Value: keePassValue{Value: e.Username}},
+			{Key: "Password", Value: keePassValue{Value: e.Password, ProtectInMemory: "True"}},
+			{Key: "URL", Value: keePassValue{Value: e.URL}},
+		}}
+		if e.Notes != "" {
+			entry.Strings = append(entry.Strings, keePassString{Key: "Notes", Value: keePassValue{Value: e.Notes}})
+		}
+		if e.CreatedAt != nil || e.UpdatedAt != nil {
+			entry.Times = &keePassTimes{}
+			if e.CreatedAt != nil {
+				entry.Times.CreationTime = e.CreatedAt.Format(time.RFC3339)
+			}
+			if e.UpdatedAt != nil {
+				entry.Times.LastModificationTime = e.UpdatedAt.Format(time.RFC3339)
+			}
+		}
+		group := keePassFolder(&doc.Root.Group, e.Folder)
+		group.Entries = append(group.Entries, entry)
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// keePassFolder returns the group of folder below root, creating the groups
+// of the path that do not exist yet.
+func keePassFolder(root *keePassGroup, folder string) *keePassGroup {
+	group := root
+	for _, name := range strings.Split(folder, "/") {
+		if name == "" {
+			continue
+		}
+		i := slices.IndexFunc(group.Groups, func(g keePassGroup) bool { return g.Name == name })
+		if i == -1 {
+			group.Groups = append(group.Groups, keePassGroup{Name: name})
+			i = len(group.Groups) - 1
+		}
+		group = &group.Groups[i]
+	}
+	return group
+}
+
+func exportEncrypted(entries []ExportEntry) ([]byte, error) {
+	passphrase, err := utils.ReadNewPassphrase("Enter a passphrase for the export: ")
+	if err != nil {
+		return nil, err
+	}
+
+	return sealExport(entries, passphrase)
+}
+
+// sealExport encrypts entries into an archive that openExport reads back.
+func sealExport(entries []ExportEntry, passphrase string) ([]byte, error) {
+	payload, err := json.Marshal(ExportDocument{ExportedAt: time.Now().UTC(), Entries: entries})
+	if err != nil {
+		return nil, err
+	}
+	return utils.SealWithPassphrase(payload, passphrase, "passwords")
+}
+
+// openExport decrypts an archive written by sealExport.
+func openExport(data []byte, passphrase string) (*ExportDocument, error) {
+	archive, payload, err := utils.OpenWithPassphrase(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if archive.Content != "passwords" {
+		return nil, fmt.Errorf("the archive contains %s, not exported passwords", archive.Content)
+	}
+
+	var doc ExportDocument
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// auditExport records the export of every exported entry in the audit log.
+func auditExport(credentials []utils.Credential, details string) error {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, c := range credentials {
+		if err := utils.Audit(tx, "export", c.ID, c.Source+"/"+c.Username, details); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,130 @@
+package functions
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+func TestEncryptedExportRoundTrip(t *testing.T) {
+	fields, err := selectExportFields([]string{"all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdAt := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	updatedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []ExportEntry{
+		toExportEntry(utils.Credential{Source: "github.com", URL: "https://github.com", Username: "alice", Password: "hunter2",
+			Folder: "work/dev", Tags: []string{"2fa", "work"}, Notes: "recovery codes in the safe", CreatedAt: createdAt, UpdatedAt: updatedAt}, fields),
+		toExportEntry(utils.Credential{Source: "mail", Username: "bob", Password: "mailpw", CreatedAt: createdAt, UpdatedAt: createdAt}, fields),
+	}
+
+	data, err := sealExport(entries, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utils.IsArchive(data) || bytes.Contains(data, []byte("hunter2")) {
+		t.Fatal("the export is not an encrypted archive")
+	}
+
+	doc, err := openExport(data, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc.Entries, entries) {
+		t.Errorf("got %+v, want %+v", doc.Entries, entries)
+	}
+
+	if _, err := openExport(data, "wrong horse"); err == nil {
+		t.Error("the archive opened with the wrong passphrase")
+	}
+	data[len(data)/2] ^= 1
+	if _, err := openExport(data, "correct horse"); err == nil {
+		t.Error("a changed archive opened")
+	}
+}
+
+func TestImportTimesKeepsExportedTimestamps(t *testing.T) {
+	now := "2026-10-19 12:00:00"
+	createdAt := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	updatedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		record  ImportRecord
+		created string
+		updated string
+	}{
+		{"no timestamps", ImportRecord{}, now, now},
+		{"updated only", ImportRecord{UpdatedAt: updatedAt}, "2026-01-02 03:04:05", "2026-01-02 03:04:05"},
+		{"both", ImportRecord{CreatedAt: createdAt, UpdatedAt: updatedAt}, "2025-03-04 05:06:07", "2026-01-02 03:04:05"},
+	}
+	for _, tt := range tests {
+		created, updated := importTimes(tt.record, now)
+		if created != tt.created || updated != tt.updated {
+			t.Errorf("%s: got %s/%s, want %s/%s", tt.name, created, updated, tt.created, tt.updated)
+		}
+	}
+}
+
+func TestExportFormatsKeepFoldersTagsAndNotes(t *testing.T) {
+	fields, err := selectExportFields([]string{"all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updatedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []ExportEntry{
+		toExportEntry(utils.Credential{Source: "github.com", URL: "https://github.com", Username: "alice", Password: "hunter2",
+			Folder: "work/dev", Tags: []string{"2fa", "work"}, Notes: "recovery codes in the safe", CreatedAt: updatedAt, UpdatedAt: updatedAt}, fields),
+		toExportEntry(utils.Credential{Source: "aws", Username: "root", Password: "awspw", Folder: "work", CreatedAt: updatedAt, UpdatedAt: updatedAt}, fields),
+		toExportEntry(utils.Credential{Source: "mail", Username: "bob", Password: "mailpw", CreatedAt: updatedAt, UpdatedAt: updatedAt}, fields),
+	}
+
+	tests := []struct {
+		format string
+		export func([]ExportEntry) ([]byte, error)
+		tags   bool
+	}{
+		{"csv", func(e []ExportEntry) ([]byte, error) { return exportCSV(e, fields) }, true},
+		{"bitwarden-json", exportBitwarden, false},
+		{"keepass-xml", exportKeePass, true},
+	}
+	for _, tt := range tests {
+		data, err := tt.export(entries)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		importer, ok := detectImporter(data)
+		if !ok {
+			t.Fatalf("%s: export not detected", tt.format)
+		}
+		records, rejected, err := importer.Parse(data)
+		if err != nil || len(rejected) > 0 {
+			t.Fatalf("%s: %v %+v", tt.format, err, rejected)
+		}
+		records, _ = validateRecords(records, nil)
+
+		got := make(map[string]ImportRecord)
+		for _, r := range records {
+			got[r.Source] = r
+		}
+		for _, e := range entries {
+			r, ok := got[e.Source]
+			if !ok {
+				t.Errorf("%s: %s is missing", tt.format, e.Source)
+				continue
+			}
+			wantTags := e.Tags
+			if !tt.tags {
+				wantTags = nil
+			}
+			if r.Folder != e.Folder || r.Notes != e.Notes || utils.FormatTags(r.Tags) != utils.FormatTags(wantTags) {
+				t.Errorf("%s: %s has folder %q, tags %v and notes %q, want %q, %v and %q",
+					tt.format, e.Source, r.Folder, r.Tags, r.Notes, e.Folder, wantTags, e.Notes)
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package functions
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+// MovePassword moves an entry given as source/username into a folder. When
+// from is not an entry but a folder, the folder is renamed to to, together
+// with its subfolders. A target of "/" is the top level.
+func MovePassword(from, to string) {
+	target, err := utils.NormalizeFolder(to)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	if source, username, ok := strings.Cut(from, "/"); ok {
+		var id int64
+		err := utils.DB.QueryRow("SELECT id FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL", source, username).Scan(&id)
+		if err == nil {
+			movePasswordToFolder(id, from, target)
+			return
+		}
+		if err != sql.ErrNoRows {
+			fmt.Println(utils.StyleError.Render("❌ Error fetching password: " + err.Error()))
+			return
+		}
+	}
+
+	folder, err := utils.NormalizeFolder(from)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	renameFolder(folder, target)
+}
+
+func movePasswordToFolder(id int64, name, folder string) {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error starting transaction: " + err.Error()))
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE passwords SET folder = ? WHERE id = ?", folder, id)
+	if err == nil {
+		err = utils.Audit(tx, "move", id, name, "to "+folderLabel(folder))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error moving password: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render("✅ Moved " + name + " to " + folderLabel(folder)))
+}
+
+func renameFolder(folder, target string) {
+	if folder == "" {
+		fmt.Println(utils.StyleError.Render("❌ Give a password as source/username or a folder to move"))
+		return
+	}
+	if utils.InFolder(target, folder) {
+		fmt.Println(utils.StyleError.Render("❌ Cannot move folder " + folder + " into itself"))
+		return
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error starting transaction: " + err.Error()))
+		return
+	}
+	defer tx.Rollback()
+
+	// The folder itself becomes target, its subfolders move along.
+	prefix := folder + "/"
+	result, err := tx.Exec(`UPDATE passwords SET folder = ltrim(? || substr(folder, ?), '/')
+		WHERE deleted_at IS NULL AND (folder = ? OR substr(folder, 1, ?) = ?)`,
+		target, len(folder)+1, folder, len(prefix), prefix)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error moving folder: " + err.Error()))
+		return
+	}
+	moved, _ := result.RowsAffected()
+	if moved == 0 {
+		fmt.Println(utils.StyleError.Render("❌ No password or folder named " + folder))
+		return
+	}
+	// Rotation policies of the folders move along, replacing those of the
+	// target.
+	_, err = tx.Exec(`UPDATE OR REPLACE rotation_policies SET folder = ltrim(? || substr(folder, ?), '/')
+		WHERE folder = ? OR substr(folder, 1, ?) = ?`,
+		target, len(folder)+1, folder, len(prefix), prefix)
+	if err == nil {
+		err = utils.Audit(tx, "move", 0, "", fmt.Sprintf("folder %s to %s, %d passwords", folder, folderLabel(target), moved))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error moving folder: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Moved folder %s to %s (%d passwords)", folder, folderLabel(target), moved)))
+}
+
+// overdueMark flags entries overdue for rotation in listings.
+func overdueMark(e utils.PasswordEntry) string {
+	if !e.Overdue(time.Now()) {
+		return ""
+	}
+	return "  " + utils.StyleError.Render("⏰ rotation overdue")
+}
+
+func folderLabel(folder string) string {
+	if folder == "" {
+		return "the top level"
+	}
+	return folder
+}
+
+// ListFolder lists the subfolders and passwords of a folder, or everything
+// below it as a tree with recursive.
+func ListFolder(folder string, recursive bool) {
+	folder, err := utils.NormalizeFolder(folder)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	var entries []utils.PasswordEntry
+	for _, e := range utils.GetPasswordEntries() {
+		if utils.InFolder(e.Folder, folder) {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		if folder == "" {
+			fmt.Println(utils.StylePrompt.Render("No passwords found in the store."))
+		} else {
+			fmt.Println(utils.StyleError.Render("❌ No passwords in folder " + folder))
+		}
+		return
+	}
+
+	fmt.Println(utils.StyleHeading.Render("/" + folder + ":"))
+	if recursive {
+		printFolderTree(entries, folder)
+		return
+	}
+
+	subfolders := make(map[string]int)
+	var direct []utils.PasswordEntry
+	for _, e := range entries {
+		if e.Folder == folder {
+			direct = append(direct, e)
+			continue
+		}
+		rest := strings.TrimPrefix(e.Folder, folder)
+		rest = strings.TrimPrefix(rest, "/")
+		name, _, _ := strings.Cut(rest, "/")
+		subfolders[name]++
+	}
+
+	names := make([]string, 0, len(subfolders))
+	for name := range subfolders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s %s/  (%d)\n", utils.StylePrompt.Render("📁"), name, subfolders[name])
+	}
+	for _, e := range utils.SortByFolder(direct) {
+		fmt.Printf("%s %s/%s  %s%s\n", utils.StylePrompt.Render("•"), e.Source, e.Username, e.URL, overdueMark(e))
+	}
+}
+
+// printFolderTree prints entries below root as an indented tree.
+func printFolderTree(entries []utils.PasswordEntry, root string) {
+	printed := make(map[string]bool)
+	depth := func(folder string) int {
+		return len(utils.FolderAncestors(folder)) - len(utils.FolderAncestors(root))
+	}
+	for _, e := range utils.SortByFolder(entries) {
+		for _, path := range utils.FolderAncestors(e.Folder) {
+			if printed[path] || !utils.InFolder(path, root) || path == root {
+				continue
+			}
+			printed[path] = true
+			fmt.Printf("%s%s %s/\n", strings.Repeat("  ", depth(path)-1), utils.StylePrompt.Render("📁"), utils.FolderName(path))
+		}
+		fmt.Printf("%s%s %s/%s%s\n", strings.Repeat("  ", depth(e.Folder)), utils.StylePrompt.Render("•"), e.Source, e.Username, overdueMark(e))
+	}
+}
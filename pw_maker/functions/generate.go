@@ -0,0 +1,72 @@
+package functions
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// GeneratePassword opens the generator, where the options can be tweaked
+// before the password is stored as a new entry or applied to an existing
+// one. When the output is not a terminal the password is only printed.
+func GeneratePassword(opts utils.GeneratorOptions) {
+	if !utils.IsTerminal(os.Stdout) || !utils.IsTerminal(os.Stdin) {
+		password, err := opts.Generate()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, utils.StyleError.Render("❌ "+err.Error()))
+			os.Exit(1)
+		}
+		fmt.Println(password)
+		return
+	}
+
+	model := utils.NewGeneratorModel(opts, utils.GetPasswordEntries())
+	model.Policy = func(source, username, url string) (utils.GeneratorOptions, string) {
+		opts, name, err := utils.GeneratorPolicy(utils.DB, source, username, url)
+		if err != nil {
+			return opts, ""
+		}
+		return opts, name
+	}
+	result, err := tea.NewProgram(model, tea.WithAltScreen()).Run()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error running program: " + err.Error()))
+		return
+	}
+	m := result.(utils.GeneratorModel)
+
+	if m.PolicyName != "" && m.Action != utils.GeneratorCancelled {
+		fmt.Println(utils.StyleInfo.Render("ℹ️ Generated with the generator policy of " + m.PolicyName))
+	}
+	switch m.Action {
+	case utils.GeneratorSave:
+		utils.StorePassword(m.Store.Credential())
+	case utils.GeneratorApply:
+		applyGeneratedPassword(m.Target, m.Password)
+	default:
+		fmt.Println(utils.StylePrompt.Render("👋 Exiting without storing password."))
+	}
+}
+
+func applyGeneratedPassword(name, password string) {
+	source, username, _ := strings.Cut(name, "/")
+	var id int64
+	err := utils.DB.QueryRow("SELECT id FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL", source, username).Scan(&id)
+	if err == sql.ErrNoRows {
+		fmt.Println(utils.StyleError.Render("❌ No password found for " + name))
+		return
+	}
+	if err == nil {
+		err = replacePassword(id, name, password, "update", "generated")
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error updating password: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render("✅ Password for " + name + " replaced with the generated one"))
+}
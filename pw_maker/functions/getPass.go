@@ -29,8 +29,9 @@ func GetPassword(name string) {
 	}
 
 	source, username := parts[0], parts[1]
+	var id int64
 	var password string
-	err := utils.DB.QueryRow("SELECT password FROM passwords WHERE source = ? AND username = ?", source, username).Scan(&password)
+	err := utils.DB.QueryRow("SELECT id, password FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL", source, username).Scan(&id, &password)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			fmt.Println(utils.StyleError.Render("❌ No password found for " + name))
@@ -39,6 +40,7 @@ func GetPassword(name string) {
 		}
 		return
 	}
+	audit("get", id, source+"/"+username, "")
 
 	err = clipboard.WriteAll(password)
 	if err != nil {
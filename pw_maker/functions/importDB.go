@@ -1,9 +1,12 @@
 package functions
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/tadeasf/pw_maker/pw_maker/utils"
 
@@ -25,6 +28,11 @@ func ImportDatabase(dbPath string) {
 		fmt.Println(utils.StyleError.Render("Error: The specified database file does not exist."))
 		return
 	}
+	audit("importdb", 0, "", "replacing the vault with "+dbPath)
+	if !autoBackup("importdb") {
+		return
+	}
+
 	// Close the current database connection
 	utils.DB.Close()
 
@@ -76,4 +84,256 @@ func ImportDatabase(dbPath string) {
 
 	// Reopen the database connection
 	utils.InitDB()
+	audit("importdb", 0, "", "imported from "+dbPath+", previous vault saved to "+backupPath)
+}
+
+type MergeOptions struct {
+	AskKey bool
+}
+
+// MergeDatabase merges the entries of another fortpass database into the
+// current vault. Entries present in both are resolved by updated_at, the
+// losing password is kept in the password history. Their tags are combined,
+// and folder and notes are taken from the merged database when they are
+// empty locally or its password wins.
+func MergeDatabase(dbPath string, opts MergeOptions) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fmt.Println(utils.StyleError.Render("Error: The specified database file does not exist."))
+		return
+	}
+
+	var key string
+	if opts.AskKey {
+		var err error
+		key, err = utils.ReadPassphrase("Enter the key or passphrase of the database to merge: ")
+		if err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error reading key: " + err.Error()))
+			return
+		}
+	}
+
+	if !autoBackup("merge") {
+		return
+	}
+
+	ctx := context.Background()
+	conn, err := utils.DB.Conn(ctx)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error opening database connection: " + err.Error()))
+		return
+	}
+	defer conn.Close()
+
+	// ATTACH is bound to a single connection, so everything below has to run
+	// on conn instead of the pool.
+	_, err = conn.ExecContext(ctx, "ATTACH DATABASE ? AS incoming KEY ?", dbPath, key)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error attaching database: " + err.Error()))
+		return
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE incoming")
+
+	incoming, err := readMergeEntries(ctx, conn, "incoming")
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading database, is it a fortpass database and is the key correct? " + err.Error()))
+		return
+	}
+
+	var hasHistory bool
+	err = conn.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM incoming.sqlite_master WHERE type = 'table' AND name = 'password_history')").Scan(&hasHistory)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading database: " + err.Error()))
+		return
+	}
+
+	local, err := readMergeEntries(ctx, conn, "main")
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error fetching passwords: " + err.Error()))
+		return
+	}
+	byKey := make(map[string]utils.Credential, len(local))
+	for _, e := range local {
+		byKey[credentialKey(e.Source, e.Username, e.URL)] = e
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error starting transaction: " + err.Error()))
+		return
+	}
+
+	var added, updated, unchanged int
+	var conflicts []string
+	for _, in := range incoming {
+		name := fmt.Sprintf("%s/%s", in.Source, in.Username)
+		current, exists := byKey[credentialKey(in.Source, in.Username, in.URL)]
+
+		var localID int64
+		switch {
+		case !exists:
+			localID, err = utils.InsertEntry(tx, in.Source, in.Username, in.Password, in.URL, utils.FormatDBTime(in.CreatedAt), utils.FormatDBTime(in.UpdatedAt))
+			if err == nil {
+				_, err = tx.ExecContext(ctx, "UPDATE passwords SET folder = ?, tags = ?, notes = ? WHERE id = ?", in.Folder, utils.FormatTags(in.Tags), in.Notes, localID)
+			}
+			if err != nil {
+				rollbackMerge(tx, "❌ Error merging "+name+": "+err.Error())
+				return
+			}
+			added++
+		case current.Password == in.Password:
+			localID = current.ID
+			unchanged++
+		case in.UpdatedAt.After(current.UpdatedAt):
+			localID = current.ID
+			err = utils.ArchivePassword(tx, current.ID)
+			if err == nil {
+				_, err = tx.ExecContext(ctx, "UPDATE passwords SET password = ?, updated_at = ? WHERE id = ?", in.Password, utils.FormatDBTime(in.UpdatedAt), current.ID)
+			}
+			if err != nil {
+				rollbackMerge(tx, "❌ Error merging "+name+": "+err.Error())
+				return
+			}
+			updated++
+			conflicts = append(conflicts, fmt.Sprintf("%s: took the merged password (updated %s, local %s)", name, formatMergeTime(in.UpdatedAt), formatMergeTime(current.UpdatedAt)))
+		default:
+			localID = current.ID
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO password_history (password_id, password, created_at, archived_at)
+				SELECT ?, ?, ?, CURRENT_TIMESTAMP
+				WHERE NOT EXISTS (SELECT 1 FROM password_history WHERE password_id = ? AND password = ?)`,
+				current.ID, in.Password, utils.FormatDBTime(in.UpdatedAt), current.ID, in.Password)
+			if err != nil {
+				rollbackMerge(tx, "❌ Error merging "+name+": "+err.Error())
+				return
+			}
+			conflicts = append(conflicts, fmt.Sprintf("%s: kept the local password (updated %s, merged %s)", name, formatMergeTime(current.UpdatedAt), formatMergeTime(in.UpdatedAt)))
+		}
+
+		if exists {
+			err = mergeEntryDetails(ctx, tx, current, in, in.UpdatedAt.After(current.UpdatedAt) && current.Password != in.Password)
+			if err != nil {
+				rollbackMerge(tx, "❌ Error merging "+name+": "+err.Error())
+				return
+			}
+		}
+
+		if hasHistory {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO password_history (password_id, password, created_at, archived_at)
+				SELECT ?, h.password, h.created_at, h.archived_at FROM incoming.password_history h
+				WHERE h.password_id = ? AND NOT EXISTS (
+					SELECT 1 FROM password_history l
+					WHERE l.password_id = ? AND l.password = h.password
+				)`, localID, in.ID, localID)
+			if err != nil {
+				rollbackMerge(tx, "❌ Error merging history of "+name+": "+err.Error())
+				return
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		rollbackMerge(tx, "❌ Error committing transaction: "+err.Error())
+		return
+	}
+	// Give the connection back before audit needs one from the pool.
+	conn.ExecContext(ctx, "DETACH DATABASE incoming")
+	conn.Close()
+
+	if len(conflicts) > 0 {
+		fmt.Println(utils.StyleHeading.Render(fmt.Sprintf("%d conflicting entries resolved by last update:", len(conflicts))))
+		for _, c := range conflicts {
+			fmt.Println(utils.StyleInfo.Render("  • " + c))
+		}
+	}
+	audit("importdb", 0, "", fmt.Sprintf("merged %s: %d added, %d updated, %d unchanged", dbPath, added, updated, unchanged))
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Merged %s: %d added, %d updated, %d unchanged, %d local entries kept",
+		dbPath, added, updated, unchanged, len(conflicts)-updated)))
+}
+
+// mergeEntryDetails merges the folder, tags and notes of an incoming entry
+// into the local entry current. Tags are combined; folder and notes are taken
+// over when they are empty locally, or when incomingWins.
+func mergeEntryDetails(ctx context.Context, tx *sql.Tx, current, in utils.Credential, incomingWins bool) error {
+	folder, notes := current.Folder, current.Notes
+	if in.Folder != "" && (folder == "" || incomingWins) {
+		folder = in.Folder
+	}
+	if in.Notes != "" && (notes == "" || incomingWins) {
+		notes = in.Notes
+	}
+	tags := utils.FormatTags(append(append([]string{}, current.Tags...), in.Tags...))
+	if folder == current.Folder && notes == current.Notes && tags == utils.FormatTags(current.Tags) {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE passwords SET folder = ?, tags = ?, notes = ? WHERE id = ?", folder, tags, notes, current.ID)
+	return err
+}
+
+func readMergeEntries(ctx context.Context, conn *sql.Conn, schema string) ([]utils.Credential, error) {
+	// Databases from before the trash, folders, tags and notes lack their
+	// columns. Entries in the trash are not merged.
+	columns := make(map[string]bool)
+	rows, err := conn.QueryContext(ctx, "SELECT name FROM pragma_table_info('passwords', ?)", schema)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	optional := func(column string) string {
+		if columns[column] {
+			return column
+		}
+		return "''"
+	}
+
+	query := "SELECT id, source, username, password, url, " + optional("folder") + ", " + optional("tags") + ", " + optional("notes") +
+		", created_at, updated_at FROM " + schema + ".passwords"
+	if columns["deleted_at"] {
+		query += " WHERE deleted_at IS NULL"
+	}
+	rows, err = conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []utils.Credential
+	for rows.Next() {
+		var e utils.Credential
+		var tags string
+		var createdAt, updatedAt sql.NullTime
+		if err := rows.Scan(&e.ID, &e.Source, &e.Username, &e.Password, &e.URL, &e.Folder, &tags, &e.Notes, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		e.Tags = utils.ParseTags(tags)
+		e.CreatedAt, e.UpdatedAt = createdAt.Time, updatedAt.Time
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func rollbackMerge(tx *sql.Tx, message string) {
+	fmt.Println(utils.StyleError.Render(message))
+	if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+		fmt.Println(utils.StyleError.Render("❌ Error rolling back transaction: " + err.Error()))
+	}
+}
+
+func formatMergeTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Local().Format("2006-01-02 15:04")
 }
@@ -0,0 +1,51 @@
+package functions
+
+import (
+	"testing"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+	"github.com/zalando/go-keyring"
+)
+
+func TestMergeDatabaseKeepsFoldersTagsAndNotes(t *testing.T) {
+	keyring.MockInit()
+	t.Setenv("HOME", t.TempDir())
+
+	other := newTestVault(t)
+	for _, e := range [][]string{
+		{"github.com", "alice", "new", "2026-01-02 10:00:00", "work", "2fa", "codes in the safe"},
+		{"mail", "bob", "mailpw", "2026-01-01 10:00:00", "home", "mail", "incoming notes"},
+		{"aws", "root", "awspw", "2026-01-01 10:00:00", "work/cloud", "admin,aws", "mfa on the yubikey"},
+	} {
+		other.exec(t, "INSERT INTO passwords (source, username, password, url, created_at, updated_at, folder, tags, notes) VALUES (?, ?, ?, '', ?, ?, ?, ?, ?)",
+			e[0], e[1], e[2], e[3], e[3], e[4], e[5], e[6])
+	}
+
+	vault := newTestVault(t)
+	vault.exec(t, "INSERT INTO passwords (source, username, password, url, created_at, updated_at, folder, tags, notes) VALUES ('github.com', 'alice', 'old', '', '2026-01-01 10:00:00', '2026-01-01 10:00:00', '', 'personal', '')")
+	vault.exec(t, "INSERT INTO passwords (source, username, password, url, created_at, updated_at, folder, tags, notes) VALUES ('mail', 'bob', 'mailpw', '', '2026-01-01 10:00:00', '2026-01-01 10:00:00', 'private', '', 'local notes')")
+
+	MergeDatabase(other.dbPath, MergeOptions{})
+
+	credentials, err := utils.GetCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][4]string{
+		// The merged password is newer, so its folder and notes win.
+		"github.com": {"new", "work", "2fa,personal", "codes in the safe"},
+		// Same password: local values stay, empty ones are filled in.
+		"mail": {"mailpw", "private", "mail", "local notes"},
+		"aws":  {"awspw", "work/cloud", "admin,aws", "mfa on the yubikey"},
+	}
+	if len(credentials) != len(want) {
+		t.Fatalf("got %d entries after the merge, want %d: %+v", len(credentials), len(want), credentials)
+	}
+	for _, c := range credentials {
+		w := want[c.Source]
+		got := [4]string{c.Password, c.Folder, utils.FormatTags(c.Tags), c.Notes}
+		if got != w {
+			t.Errorf("%s: got %q, want %q", c.Source, got, w)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+func init() {
+	RegisterImporter(Importer{
+		Name:        "fortpass-encrypted",
+		Description: "Encrypted archive created by `fortpass export --format encrypted`",
+		Detect:      utils.IsArchive,
+		Parse:       parseArchive,
+	})
+	RegisterImporter(Importer{
+		Name:        "bitwarden-json",
+		Description: "Bitwarden unencrypted JSON export",
+		Detect:      detectBitwarden,
+		Parse:       parseBitwarden,
+	})
+	RegisterImporter(Importer{
+		Name:        "1password-1pux",
+		Description: "1Password 1PUX export",
+		Detect:      detect1PUX,
+		Parse:       parse1PUX,
+	})
+	RegisterImporter(Importer{
+		Name:        "keepass-xml",
+		Description: "KeePass 2 XML export",
+		Detect: func(data []byte) bool {
+			return bytes.Contains(data[:min(len(data), 512)], []byte("<KeePassFile"))
+		},
+		Parse: parseKeePass,
+	})
+	RegisterImporter(Importer{
+		Name:        "lastpass-csv",
+		Description: "LastPass CSV export",
+		Detect: func(data []byte) bool {
+			return csvHeaderMatches(data, "url", "username", "password", "extra", "name", "grouping")
+		},
+		Parse: parseLastPass,
+	})
+	RegisterImporter(Importer{
+		Name:        "firefox-csv",
+		Description: "Firefox password CSV export",
+		Detect: func(data []byte) bool {
+			return csvHeaderMatches(data, "url", "username", "password", "httprealm", "formactionorigin")
+		},
+		Parse: func(data []byte) ([]ImportRecord, []ImportError, error) {
+			columns := csvColumns{"url": "url", "username": "username", "password": "password", "updated_at": "timePasswordChanged"}
+			return parseMappedCSV(data, columns, parseUnixMillis)
+		},
+	})
+	RegisterImporter(Importer{
+		Name:        "chrome-csv",
+		Description: "Chrome, Edge and Brave password CSV export",
+		Detect: func(data []byte) bool {
+			return csvHeaderMatches(data, "name", "url", "username", "password")
+		},
+		Parse: func(data []byte) ([]ImportRecord, []ImportError, error) {
+			columns := csvColumns{"source": "name", "url": "url", "username": "username", "password": "password"}
+			return parseMappedCSV(data, columns, nil)
+		},
+	})
+	RegisterImporter(Importer{
+		Name:        "fortpass-csv",
+		Description: "CSV with source, url, username and password columns, as written by `fortpass export`",
+		// Registered last, so that CSV files of older versions without a
+		// recognizable header fall back to it.
+		Detect: func(data []byte) bool {
+			return csvHeaderMatches(data, "source", "url", "username", "password") || isLegacyFortpassCSV(data)
+		},
+		Parse: parseFortpassCSV,
+	})
+}
+
+func parseUnixMillis(raw string) (time.Time, error) {
+	ms, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// isLegacyFortpassCSV reports whether data is a CSV file whose first row has
+// the four columns older versions read positionally.
+func isLegacyFortpassCSV(data []byte) bool {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	first, err := reader.Read()
+	return err == nil && len(first) >= 4
+}
+
+// parseFortpassCSV reads the CSV layout written by `export`. Files without a
+// recognizable header are read positionally as source, url, username and
+// password with the first row skipped, as older versions did.
+func parseFortpassCSV(data []byte) ([]ImportRecord, []ImportError, error) {
+	if csvHeaderMatches(data, "source", "url", "username", "password") {
+		columns := csvColumns{"source": "source", "url": "url", "username": "username", "password": "password"}
+		for _, optional := range []string{"folder", "tags", "notes", "updated_at"} {
+			if csvHeaderMatches(data, optional) {
+				columns[optional] = optional
+			}
+		}
+		return parseMappedCSV(data, columns, func(raw string) (time.Time, error) {
+			return time.Parse(time.RFC3339, raw)
+		})
+	}
+
+	rows, err := readCSV(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var records []ImportRecord
+	var rejected []ImportError
+	for i, row := range rows[1:] {
+		line := i + 2
+		if len(row) < 4 {
+			rejected = append(rejected, ImportError{Line: line, Reason: fmt.Sprintf("row has %d columns, expected 4", len(row))})
+			continue
+		}
+		records = append(records, ImportRecord{Line: line, Source: row[0], URL: row[1], Username: row[2], Password: row[3]})
+	}
+	return records, rejected, nil
+}
+
+// parseMappedCSVFile returns a parser for arbitrary CSV files using the
+// columns given with --map.
+func parseMappedCSVFile(columns csvColumns) func(data []byte) ([]ImportRecord, []ImportError, error) {
+	return func(data []byte) ([]ImportRecord, []ImportError, error) {
+		return parseMappedCSV(data, columns, func(raw string) (time.Time, error) {
+			if t, err := time.Parse(time.RFC3339, raw); err == nil {
+				return t, nil
+			}
+			if t, err := time.Parse("2006-01-02 15:04:05", raw); err == nil {
+				return t, nil
+			}
+			return parseUnixMillis(raw)
+		})
+	}
+}
+
+func parseLastPass(data []byte) ([]ImportRecord, []ImportError, error) {
+	columns := csvColumns{"source": "name", "url": "url", "username": "username", "password": "password"}
+	records, rejected, err := parseMappedCSV(data, columns, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Secure notes are exported with the placeholder URL http://sn.
+	var logins []ImportRecord
+	for _, r := range records {
+		if r.URL == "http://sn" {
+			rejected = append(rejected, ImportError{Line: r.Line, Reason: fmt.Sprintf("%s is a secure note, not a login", r.Source)})
+			continue
+		}
+		logins = append(logins, r)
+	}
+	return logins, rejected, nil
+}
+
+func parseArchive(data []byte) ([]ImportRecord, []ImportError, error) {
+	passphrase, err := utils.ReadPassphrase("Enter the passphrase of the export: ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := openExport(data, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records := make([]ImportRecord, len(doc.Entries))
+	for i, e := range doc.Entries {
+		records[i] = ImportRecord{Line: i + 1, Source: e.Source, URL: e.URL, Username: e.Username, Password: e.Password,
+			Folder: e.Folder, Tags: e.Tags, Notes: e.Notes}
+		if e.CreatedAt != nil {
+			records[i].CreatedAt = *e.CreatedAt
+		}
+		if e.UpdatedAt != nil {
+			records[i].UpdatedAt = *e.UpdatedAt
+		}
+	}
+	return records, nil, nil
+}
+
+func detectBitwarden(data []byte) bool {
+	var doc map[string]json.RawMessage
+	if json.Unmarshal(data, &doc) != nil {
+		return false
+	}
+	_, hasItems := doc["items"]
+	_, hasEncrypted := doc["encrypted"]
+	return hasItems && hasEncrypted
+}
+
+func parseBitwarden(data []byte) ([]ImportRecord, []ImportError, error) {
+	var doc bitwardenExport
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	if doc.Encrypted {
+		return nil, nil, errors.New("encrypted Bitwarden exports are not supported, export as unencrypted JSON")
+	}
+
+	folders := make(map[string]string, len(doc.Folders))
+	for _, f := range doc.Folders {
+		folders[f.ID] = f.Name
+	}
+
+	var records []ImportRecord
+	var rejected []ImportError
+	for i, item := range doc.Items {
+		line := i + 1
+		if item.Type != 1 {
+			rejected = append(rejected, ImportError{Line: line, Reason: fmt.Sprintf("%s is not a login item", item.Name)})
+			continue
+		}
+		record := ImportRecord{
+			Line:     line,
+			Source:   item.Name,
+			Username: item.Login.Username,
+			Password: item.Login.Password,
+		}
+		if len(item.Login.URIs) > 0 {
+			record.URL = item.Login.URIs[0].URI
+		}
+		if item.Notes != nil {
+			record.Notes = *item.Notes
+		}
+		if item.FolderID != nil {
+			record.Folder = folders[*item.FolderID]
+		}
+		if item.RevisionDate != nil {
+			record.UpdatedAt = *item.RevisionDate
+		}
+		records = append(records, record)
+	}
+	return records, rejected, nil
+}
+
+type onePasswordExport struct {
+	Accounts []struct {
+		Vaults []struct {
+			Attrs struct {
+				Name string `json:"name"`
+			} `json:"attrs"`
+			Items []onePasswordItem `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+type onePasswordItem struct {
+	UpdatedAt    int64  `json:"updatedAt"`
+	State        string `json:"state"`
+	CategoryUUID string `json:"categoryUuid"`
+	Details      struct {
+		LoginFields []struct {
+			Value       string `json:"value"`
+			Designation string `json:"designation"`
+		} `json:"loginFields"`
+		Password *string `json:"password"`
+	} `json:"details"`
+	Overview struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+	} `json:"overview"`
+}
+
+func detect1PUX(data []byte) bool {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return false
+	}
+	_, err := read1PUXData(data)
+	return err == nil
+}
+
+func read1PUXData(data []byte) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range archive.File {
+		if f.Name != "export.data" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, errors.New("export.data not found in 1PUX archive")
+}
+
+func parse1PUX(data []byte) ([]ImportRecord, []ImportError, error) {
+	exportData, err := read1PUXData(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var doc onePasswordExport
+	if err := json.Unmarshal(exportData, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	var records []ImportRecord
+	var rejected []ImportError
+	line := 0
+	for _, account := range doc.Accounts {
+		for _, vault := range account.Vaults {
+			for _, item := range vault.Items {
+				line++
+				if item.State == "archived" {
+					rejected = append(rejected, ImportError{Line: line, Reason: fmt.Sprintf("%s is archived", item.Overview.Title)})
+					continue
+				}
+
+				record := ImportRecord{Line: line, Source: item.Overview.Title, URL: item.Overview.URL}
+				for _, field := range item.Details.LoginFields {
+					switch field.Designation {
+					case "username":
+						record.Username = field.Value
+					case "password":
+						record.Password = field.Value
+					}
+				}
+				if record.Password == "" && item.Details.Password != nil {
+					record.Password = *item.Details.Password
+				}
+				if record.Password == "" {
+					rejected = append(rejected, ImportError{Line: line, Reason: fmt.Sprintf("%s has no password (category %s)", item.Overview.Title, item.CategoryUUID)})
+					continue
+				}
+				if item.UpdatedAt > 0 {
+					record.UpdatedAt = time.Unix(item.UpdatedAt, 0)
+				}
+				records = append(records, record)
+			}
+		}
+	}
+	return records, rejected, nil
+}
+
+func parseKeePass(data []byte) ([]ImportRecord, []ImportError, error) {
+	var doc keePassFile
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	var records []ImportRecord
+	line := 0
+	// Groups below the root group become folders.
+	var walk func(group keePassGroup, folder string)
+	walk = func(group keePassGroup, folder string) {
+		if group.Name == "Recycle Bin" {
+			return
+		}
+		for _, entry := range group.Entries {
+			line++
+			record := ImportRecord{Line: line, Folder: folder, Tags: utils.ParseTags(strings.ReplaceAll(entry.Tags, ";", ","))}
+			for _, s := range entry.Strings {
+				switch s.Key {
+				case "Title":
+					record.Source = s.Value.Value
+				case "UserName":
+					record.Username = s.Value.Value
+				case "Password":
+					record.Password = s.Value.Value
+				case "URL":
+					record.URL = s.Value.Value
+				case "Notes":
+					record.Notes = s.Value.Value
+				}
+			}
+			if entry.Times != nil && entry.Times.LastModificationTime != "" {
+				if t, err := time.Parse(time.RFC3339, entry.Times.LastModificationTime); err == nil {
+					record.UpdatedAt = t
+				}
+			}
+			records = append(records, record)
+		}
+		for _, child := range group.Groups {
+			walk(child, strings.TrimPrefix(folder+"/"+child.Name, "/"))
+		}
+	}
+	walk(doc.Root.Group, "")
+
+	return records, nil, nil
+}
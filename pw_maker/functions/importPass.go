@@ -1,85 +1,310 @@
 package functions
 
 import (
-	"encoding/csv"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/tadeasf/pw_maker/pw_maker/utils"
 
-	"github.com/spf13/cobra"
+	tea "github.com/charmbracelet/bubbletea"
 )
 
-var ImportCmd = &cobra.Command{
-	Use:   "import [csv_file]",
-	Short: "Import passwords from a CSV file",
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		ImportPasswords(args[0])
-	},
+var ConflictStrategies = []string{utils.ResolutionSkip, utils.ResolutionOverwrite, utils.ResolutionKeepBoth, "prompt"}
+
+type ImportOptions struct {
+	Format     string
+	Map        string
+	DryRun     bool
+	OnConflict string
 }
 
-func ImportPasswords(filename string) {
-	file, err := os.Open(filename)
+func ImportPasswords(filename string, opts ImportOptions) {
+	if !slices.Contains(ConflictStrategies, opts.OnConflict) {
+		fmt.Println(utils.StyleError.Render(fmt.Sprintf("❌ Unknown conflict strategy %q (available: %s)", opts.OnConflict, strings.Join(ConflictStrategies, ", "))))
+		return
+	}
+
+	data, err := os.ReadFile(filename)
 	if err != nil {
-		fmt.Println(utils.StyleError.Render("❌ Error opening CSV file: " + err.Error()))
+		fmt.Println(utils.StyleError.Render("❌ Error opening import file: " + err.Error()))
 		return
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	importer, err := selectImporter(data, opts)
 	if err != nil {
-		fmt.Println(utils.StyleError.Render("❌ Error reading CSV file: " + err.Error()))
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
 		return
 	}
+	fmt.Println(utils.StyleInfo.Render("ℹ️ Importing " + importer.Description))
 
-	tx, err := utils.DB.Begin()
+	records, rejected, err := importer.Parse(data)
 	if err != nil {
-		fmt.Println(utils.StyleError.Render("❌ Error starting transaction: " + err.Error()))
+		fmt.Println(utils.StyleError.Render(fmt.Sprintf("❌ Error reading %s file: %s", importer.Name, err.Error())))
 		return
 	}
+	records, rejected = validateRecords(records, rejected)
 
-	stmt, err := tx.Prepare(`
-		INSERT INTO passwords (source, username, password, url, created_at, updated_at)
-		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-		ON CONFLICT(source, username, url) DO UPDATE SET
-		password = excluded.password,
-		updated_at = CURRENT_TIMESTAMP
-		WHERE password != excluded.password
-	`)
+	existing, err := utils.GetCredentials()
 	if err != nil {
-		fmt.Println(utils.StyleError.Render("❌ Error preparing statement: " + err.Error()))
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			fmt.Println(utils.StyleError.Render("❌ Error rolling back transaction: " + rollbackErr.Error()))
-		}
+		fmt.Println(utils.StyleError.Render("❌ Error fetching passwords: " + err.Error()))
 		return
 	}
-	defer stmt.Close()
 
-	var inserted, updated, skipped int
-	for _, record := range records[1:] {
-		source := record[0]
-		url := utils.BeautifyURL(record[1])
-		username := record[2]
-		password := record[3]
+	changes := planImport(records, existing)
+	// A dry run writes nothing, so conflicts are listed instead of reviewed.
+	if !(opts.DryRun && opts.OnConflict == "prompt") && !resolveConflicts(changes, opts) {
+		fmt.Println(utils.StylePrompt.Render("👋 Import aborted, nothing was changed."))
+		return
+	}
 
-		result, err := stmt.Exec(source, username, password, url)
-		if err != nil {
-			fmt.Printf(utils.StyleError.Render("❌ Error importing password for %s: %s\n"), source, err.Error())
-			continue
+	if opts.DryRun {
+		printImportDiff(changes)
+		printRejected(rejected)
+		fmt.Println(utils.StylePrompt.Render("Dry run, nothing was written. Run again without --dry-run to import."))
+		return
+	}
+
+	if !autoBackup("import") {
+		return
+	}
+
+	counts, failed := applyImport(changes, existing, filename)
+	rejected = append(rejected, failed...)
+	if counts == nil {
+		return
+	}
+
+	printRejected(rejected)
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("Import completed: %d inserted, %d updated, %d unchanged, %d skipped, %d rejected",
+		counts["inserted"], counts["updated"], counts["unchanged"], counts["skipped"], len(rejected))))
+}
+
+const (
+	importNew         = "new"
+	importChanged     = "changed"
+	importUnchanged   = "unchanged"
+	importConflicting = "conflicting"
+)
+
+// importChange is the planned outcome for a single imported record.
+type importChange struct {
+	Record     ImportRecord
+	Kind       string
+	Existing   *utils.Credential
+	Resolution string
+}
+
+func credentialKey(source, username, url string) string {
+	return source + "\x00" + username + "\x00" + url
+}
+
+// planImport compares the imported records with the stored entries. An entry
+// with a different password is "changed" when the import is known to be
+// newer and "conflicting" otherwise. Records repeating an earlier record of
+// the same import are compared against that record.
+func planImport(records []ImportRecord, existing []utils.Credential) []importChange {
+	stored := make(map[string]*utils.Credential, len(existing))
+	for i := range existing {
+		c := &existing[i]
+		stored[credentialKey(c.Source, c.Username, c.URL)] = c
+	}
+
+	var changes []importChange
+	for _, r := range records {
+		key := credentialKey(r.Source, r.Username, r.URL)
+		current, ok := stored[key]
+
+		change := importChange{Record: r, Existing: current}
+		switch {
+		case !ok:
+			change.Kind = importNew
+		case current.Password == r.Password:
+			change.Kind = importUnchanged
+		case !r.UpdatedAt.IsZero() && r.UpdatedAt.After(current.UpdatedAt):
+			change.Kind = importChanged
+		default:
+			change.Kind = importConflicting
+		}
+		changes = append(changes, change)
+
+		planned := utils.Credential{Source: r.Source, Username: r.Username, URL: r.URL, Password: r.Password, UpdatedAt: r.UpdatedAt}
+		if ok {
+			planned.ID = current.ID
+		}
+		stored[key] = &planned
+	}
+	return changes
+}
+
+// resolveConflicts sets the resolution of every conflicting change according
+// to --on-conflict. It returns false when the user aborted the review.
+func resolveConflicts(changes []importChange, opts ImportOptions) bool {
+	var conflicts []int
+	for i, c := range changes {
+		if c.Kind == importConflicting {
+			conflicts = append(conflicts, i)
+		}
+	}
+	if len(conflicts) == 0 {
+		return true
+	}
+
+	if opts.OnConflict != "prompt" {
+		for _, i := range conflicts {
+			changes[i].Resolution = opts.OnConflict
+		}
+		return true
+	}
+
+	review := make([]utils.Conflict, len(conflicts))
+	for n, i := range conflicts {
+		c := changes[i]
+		review[n] = utils.Conflict{
+			Source:           c.Record.Source,
+			Username:         c.Record.Username,
+			URL:              c.Record.URL,
+			LocalPassword:    c.Existing.Password,
+			IncomingPassword: c.Record.Password,
+			LocalUpdated:     c.Existing.UpdatedAt,
+			IncomingUpdated:  c.Record.UpdatedAt,
+		}
+	}
+
+	p := tea.NewProgram(utils.NewConflictReviewModel(review), tea.WithAltScreen())
+	m, err := p.Run()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("Error running program: " + err.Error()))
+		return false
+	}
+	result := m.(utils.ConflictReviewModel)
+	if result.Aborted {
+		return false
+	}
+	for n, i := range conflicts {
+		changes[i].Resolution = result.Conflicts[n].Resolution
+	}
+	return true
+}
+
+func printImportDiff(changes []importChange) {
+	counts := make(map[string]int)
+	for _, c := range changes {
+		counts[c.Kind]++
+		name := fmt.Sprintf("%s/%s", c.Record.Source, c.Record.Username)
+		if c.Record.URL != "" {
+			name += " (" + c.Record.URL + ")"
+		}
+		switch c.Kind {
+		case importNew:
+			fmt.Println(utils.StyleSuccess.Render("+ " + name))
+		case importChanged:
+			fmt.Println(utils.StyleInfo.Render("~ " + name + " (newer password)"))
+		case importConflicting:
+			resolution := c.Resolution
+			if resolution == "" {
+				resolution = "unresolved"
+			}
+			fmt.Println(utils.StyleError.Render("! " + name + " (conflict, " + resolution + ")"))
+		}
+	}
+	fmt.Println(utils.StyleHeading.Render(fmt.Sprintf("%d new, %d changed, %d unchanged, %d conflicting",
+		counts[importNew], counts[importChanged], counts[importUnchanged], counts[importConflicting])))
+}
+
+// applyImport writes the planned changes in a single transaction. It returns
+// nil counts when the transaction could not be committed.
+func applyImport(changes []importChange, existing []utils.Credential, filename string) (map[string]int, []ImportError) {
+	counts := make(map[string]int)
+	var failed []ImportError
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error starting transaction: " + err.Error()))
+		return nil, nil
+	}
+
+	now := utils.FormatDBTime(time.Now())
+	taken := make(map[string]bool)
+	for _, c := range existing {
+		taken[credentialKey(c.Source, c.Username, c.URL)] = true
+	}
+	for _, c := range changes {
+		taken[credentialKey(c.Record.Source, c.Record.Username, c.Record.URL)] = true
+	}
+
+	for _, c := range changes {
+		r := c.Record
+		name := fmt.Sprintf("%s/%s", r.Source, r.Username)
+
+		action := c.Kind
+		if c.Kind == importConflicting {
+			action = c.Resolution
 		}
 
-		rowsAffected, _ := result.RowsAffected()
-		if rowsAffected == 0 {
-			skipped++
-			fmt.Printf(utils.StyleInfo.Render("ℹ️ Skipped duplicate password for %s (no changes)\n"), source)
-		} else if rowsAffected == 1 {
-			inserted++
-			fmt.Printf(utils.StyleSuccess.Render("✅ Imported new password for %s\n"), source)
-		} else {
-			updated++
-			fmt.Printf(utils.StyleSuccess.Render("✅ Updated existing password for %s\n"), source)
+		switch action {
+		case importUnchanged:
+			counts["unchanged"]++
+			continue
+		case utils.ResolutionSkip:
+			counts["skipped"]++
+			fmt.Println(utils.StyleInfo.Render("ℹ️ Kept stored password for " + name))
+			continue
+		case importNew:
+			createdAt, updatedAt := importTimes(r, now)
+			var id int64
+			id, err = utils.InsertEntry(tx, r.Source, r.Username, r.Password, r.URL, createdAt, updatedAt)
+			if err == nil {
+				err = storeImportDetails(tx, id, r)
+			}
+			if err == nil {
+				err = utils.Audit(tx, "import", id, name, "added from "+filename)
+			}
+			if err == nil {
+				counts["inserted"]++
+				fmt.Println(utils.StyleSuccess.Render("✅ Imported new password for " + name))
+			}
+		case importChanged, utils.ResolutionOverwrite:
+			if c.Existing.ID != 0 {
+				err = utils.ArchivePassword(tx, c.Existing.ID)
+				if err != nil {
+					break
+				}
+			}
+			_, err = tx.Exec(`UPDATE passwords SET password = ?, updated_at = CURRENT_TIMESTAMP,
+				folder = COALESCE(NULLIF(?, ''), folder), tags = COALESCE(NULLIF(?, ''), tags), notes = COALESCE(NULLIF(?, ''), notes)
+				WHERE source = ? AND username = ? AND url = ? AND deleted_at IS NULL`,
+				r.Password, r.Folder, utils.FormatTags(r.Tags), r.Notes, r.Source, r.Username, r.URL)
+			if err == nil {
+				err = utils.Audit(tx, "import", c.Existing.ID, name, "updated from "+filename)
+			}
+			if err == nil {
+				counts["updated"]++
+				fmt.Println(utils.StyleSuccess.Render("✅ Updated existing password for " + name))
+			}
+		case utils.ResolutionKeepBoth:
+			source := r.Source + " (imported)"
+			for n := 2; taken[credentialKey(source, r.Username, r.URL)]; n++ {
+				source = fmt.Sprintf("%s (imported %d)", r.Source, n)
+			}
+			taken[credentialKey(source, r.Username, r.URL)] = true
+			var id int64
+			id, err = utils.InsertEntry(tx, source, r.Username, r.Password, r.URL, now, now)
+			if err == nil {
+				err = storeImportDetails(tx, id, r)
+			}
+			if err == nil {
+				err = utils.Audit(tx, "import", id, source+"/"+r.Username, "added from "+filename+" next to "+name)
+			}
+			if err == nil {
+				counts["inserted"]++
+				fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Imported password for %s as %s/%s", name, source, r.Username)))
+			}
+		}
+		if err != nil {
+			failed = append(failed, ImportError{Line: r.Line, Reason: fmt.Sprintf("error importing password for %s: %s", name, err.Error())})
 		}
 	}
 
@@ -89,7 +314,68 @@ func ImportPasswords(filename string) {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
 			fmt.Println(utils.StyleError.Render("❌ Error rolling back transaction: " + rollbackErr.Error()))
 		}
-	} else {
-		fmt.Printf(utils.StyleSuccess.Render("Import completed: %d inserted, %d updated, %d skipped\n"), inserted, updated, skipped)
+		return nil, nil
+	}
+	return counts, failed
+}
+
+// storeImportDetails sets the folder, tags and notes the import file has for
+// an entry. Values missing in the file leave the stored ones alone.
+func storeImportDetails(tx utils.Execer, id int64, r ImportRecord) error {
+	if r.Folder == "" && len(r.Tags) == 0 && r.Notes == "" {
+		return nil
+	}
+	_, err := tx.Exec("UPDATE passwords SET folder = COALESCE(NULLIF(?, ''), folder), tags = COALESCE(NULLIF(?, ''), tags), notes = COALESCE(NULLIF(?, ''), notes) WHERE id = ?",
+		r.Folder, utils.FormatTags(r.Tags), r.Notes, id)
+	return err
+}
+
+// importTimes returns the timestamps of a new entry, taken from the record
+// when the import file has them and now otherwise.
+func importTimes(r ImportRecord, now string) (string, string) {
+	createdAt, updatedAt := now, now
+	if !r.UpdatedAt.IsZero() {
+		updatedAt = utils.FormatDBTime(r.UpdatedAt)
+		createdAt = updatedAt
+	}
+	if !r.CreatedAt.IsZero() {
+		createdAt = utils.FormatDBTime(r.CreatedAt)
+	}
+	return createdAt, updatedAt
+}
+
+// selectImporter picks the importer requested with --format or --map, or
+// detects it from the file contents.
+func selectImporter(data []byte, opts ImportOptions) (Importer, error) {
+	if opts.Map != "" {
+		columns, err := ParseColumnMapping(opts.Map)
+		if err != nil {
+			return Importer{}, err
+		}
+		return Importer{Name: "csv", Description: "CSV with custom column mapping", Parse: parseMappedCSVFile(columns)}, nil
+	}
+
+	if opts.Format != "" {
+		importer, ok := findImporter(opts.Format)
+		if !ok {
+			return Importer{}, fmt.Errorf("unknown import format %q (available: %s)", opts.Format, strings.Join(ImporterNames(), ", "))
+		}
+		return importer, nil
+	}
+
+	importer, ok := detectImporter(data)
+	if !ok {
+		return Importer{}, fmt.Errorf("could not detect the file format, use --format (%s) or --map for other CSV files", strings.Join(ImporterNames(), ", "))
+	}
+	return importer, nil
+}
+
+func printRejected(rejected []ImportError) {
+	if len(rejected) == 0 {
+		return
+	}
+	fmt.Println(utils.StyleError.Render(fmt.Sprintf("⚠️  %d rows were not imported:", len(rejected))))
+	for _, r := range rejected {
+		fmt.Println(utils.StyleError.Render(fmt.Sprintf("  • row %d: %s", r.Line, r.Reason)))
 	}
 }
@@ -0,0 +1,296 @@
+package functions
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+// ImportRecord is a single credential read from an import file.
+type ImportRecord struct {
+	Line      int
+	Source    string
+	URL       string
+	Username  string
+	Password  string
+	Folder    string
+	Tags      []string
+	Notes     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ImportError describes a row of an import file that could not be imported.
+type ImportError struct {
+	Line   int
+	Reason string
+}
+
+// Importer reads the export format of a single password manager. Detect is
+// used for auto-detection and gets the raw file contents, Parse turns them
+// into records and reports rows it had to reject.
+type Importer struct {
+	Name        string
+	Description string
+	Detect      func(data []byte) bool
+	Parse       func(data []byte) ([]ImportRecord, []ImportError, error)
+}
+
+var importers []Importer
+
+// RegisterImporter adds an importer to the registry. Importers are tried in
+// registration order during auto-detection, so more specific formats have to
+// be registered before more generic ones.
+func RegisterImporter(importer Importer) {
+	importers = append(importers, importer)
+}
+
+func ImporterNames() []string {
+	names := make([]string, len(importers))
+	for i, importer := range importers {
+		names[i] = importer.Name
+	}
+	return names
+}
+
+func findImporter(name string) (Importer, bool) {
+	for _, importer := range importers {
+		if importer.Name == name {
+			return importer, true
+		}
+	}
+	return Importer{}, false
+}
+
+func detectImporter(data []byte) (Importer, bool) {
+	for _, importer := range importers {
+		if importer.Detect(data) {
+			return importer, true
+		}
+	}
+	return Importer{}, false
+}
+
+// csvColumns maps vault fields (source, url, username, password, folder,
+// tags, notes, updated_at) to the header names used by a CSV format.
+type csvColumns map[string]string
+
+// ParseColumnMapping parses a --map value like "source=Title,username=Login"
+// into a column mapping for arbitrary CSV files.
+func ParseColumnMapping(spec string) (csvColumns, error) {
+	columns := csvColumns{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		field, column, ok := strings.Cut(pair, "=")
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !ok || strings.TrimSpace(column) == "" {
+			return nil, fmt.Errorf("invalid mapping %q, use field=column", pair)
+		}
+		switch field {
+		case "source", "url", "username", "password", "folder", "tags", "notes", "updated_at":
+			columns[field] = strings.TrimSpace(column)
+		default:
+			return nil, fmt.Errorf("unknown field %q in mapping (available: source, url, username, password, folder, tags, notes, updated_at)", field)
+		}
+	}
+	if columns["password"] == "" {
+		return nil, fmt.Errorf("the mapping has to contain a password column")
+	}
+	if columns["source"] == "" && columns["url"] == "" {
+		return nil, fmt.Errorf("the mapping has to contain a source or url column")
+	}
+	return columns, nil
+}
+
+func readCSV(data []byte) ([][]string, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return records, nil
+}
+
+func normalizeHeader(header []string) []string {
+	normalized := make([]string, len(header))
+	for i, h := range header {
+		normalized[i] = strings.ToLower(strings.TrimSpace(h))
+	}
+	return normalized
+}
+
+// csvHeaderMatches reports whether the first row of data contains all of the
+// given column names.
+func csvHeaderMatches(data []byte, required ...string) bool {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return false
+	}
+	present := make(map[string]bool)
+	for _, h := range normalizeHeader(header) {
+		present[h] = true
+	}
+	for _, r := range required {
+		if !present[r] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseMappedCSV reads a CSV file with a header row and picks values by the
+// column names in columns. parseTime converts the updated_at column, it may be
+// nil when the format has no such column.
+func parseMappedCSV(data []byte, columns csvColumns, parseTime func(string) (time.Time, error)) ([]ImportRecord, []ImportError, error) {
+	records, err := readCSV(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := normalizeHeader(records[0])
+	index := make(map[string]int)
+	for field, column := range columns {
+		index[field] = -1
+		for i, h := range header {
+			if h == strings.ToLower(column) {
+				index[field] = i
+				break
+			}
+		}
+		if index[field] == -1 {
+			return nil, nil, fmt.Errorf("column %q for %s not found in the CSV header", column, field)
+		}
+	}
+
+	var result []ImportRecord
+	var rejected []ImportError
+	for i, row := range records[1:] {
+		line := i + 2
+		if len(row) == 1 && strings.TrimSpace(row[0]) == "" {
+			continue
+		}
+
+		value := func(field string) (string, bool) {
+			idx, ok := index[field]
+			if !ok {
+				return "", true
+			}
+			if idx >= len(row) {
+				return "", false
+			}
+			return row[idx], true
+		}
+
+		record := ImportRecord{Line: line}
+		complete := true
+		var ok bool
+		if record.Source, ok = value("source"); !ok {
+			complete = false
+		}
+		if record.URL, ok = value("url"); !ok {
+			complete = false
+		}
+		if record.Username, ok = value("username"); !ok {
+			complete = false
+		}
+		if record.Password, ok = value("password"); !ok {
+			complete = false
+		}
+		if record.Folder, ok = value("folder"); !ok {
+			complete = false
+		}
+		if record.Notes, ok = value("notes"); !ok {
+			complete = false
+		}
+		tags, ok := value("tags")
+		if !ok {
+			complete = false
+		}
+		record.Tags = utils.ParseTags(tags)
+		if !complete {
+			rejected = append(rejected, ImportError{Line: line, Reason: fmt.Sprintf("row has %d columns, expected %d", len(row), len(header))})
+			continue
+		}
+
+		if raw, _ := value("updated_at"); raw != "" && parseTime != nil {
+			updatedAt, err := parseTime(raw)
+			if err != nil {
+				rejected = append(rejected, ImportError{Line: line, Reason: "invalid timestamp " + raw})
+				continue
+			}
+			record.UpdatedAt = updatedAt
+		}
+
+		result = append(result, record)
+	}
+	return result, rejected, nil
+}
+
+// validateRecords normalizes imported records and moves records that cannot
+// be stored into the rejected list.
+func validateRecords(records []ImportRecord, rejected []ImportError) ([]ImportRecord, []ImportError) {
+	var valid []ImportRecord
+	for _, r := range records {
+		r.Source = strings.TrimSpace(r.Source)
+		r.Username = strings.TrimSpace(r.Username)
+		r.URL = strings.TrimSpace(r.URL)
+		if r.Source == "" {
+			r.Source = hostFromURL(r.URL)
+		}
+		folder, folderErr := utils.NormalizeFolder(r.Folder)
+		r.Folder = folder
+		r.Tags = utils.ParseTags(strings.Join(r.Tags, ","))
+
+		switch {
+		case r.Source == "":
+			rejected = append(rejected, ImportError{Line: r.Line, Reason: "missing source and URL"})
+		case r.Password == "":
+			rejected = append(rejected, ImportError{Line: r.Line, Reason: fmt.Sprintf("missing password for %s", r.Source)})
+		case strings.Contains(r.Source, "/") || strings.Contains(r.Username, "/"):
+			// Entries are addressed as source/username on the command line,
+			// so a slash would make them unreachable.
+			rejected = append(rejected, ImportError{Line: r.Line, Reason: fmt.Sprintf("source or username of %s/%s contains '/'", r.Source, r.Username)})
+		case folderErr != nil:
+			rejected = append(rejected, ImportError{Line: r.Line, Reason: fmt.Sprintf("%s/%s: %s", r.Source, r.Username, folderErr.Error())})
+		default:
+			if r.URL != "" {
+				r.URL = utils.BeautifyURL(r.URL)
+			}
+			valid = append(valid, r)
+		}
+	}
+
+	sort.SliceStable(rejected, func(i, j int) bool { return rejected[i].Line < rejected[j].Line })
+	return valid, rejected
+}
+
+func hostFromURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(parsed.Hostname(), "www.")
+}
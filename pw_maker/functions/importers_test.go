@@ -0,0 +1,163 @@
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func onePUX(t *testing.T, exportData string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range map[string]string{"export.attributes": `{"version":3}`, "export.data": exportData} {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImporters(t *testing.T) {
+	changed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		format   string
+		records  []ImportRecord
+		rejected int
+	}{
+		{
+			name: "bitwarden",
+			data: []byte(`{"encrypted": false, "folders": [], "items": [
+				{"type": 1, "name": "GitHub", "revisionDate": "2026-01-02T03:04:05Z",
+				 "login": {"username": "alice", "password": "pw1", "uris": [{"uri": "https://github.com/login"}]}},
+				{"type": 2, "name": "Note"}]}`),
+			format:   "bitwarden-json",
+			records:  []ImportRecord{{Line: 1, Source: "GitHub", URL: "https://github.com/login", Username: "alice", Password: "pw1", UpdatedAt: changed}},
+			rejected: 1,
+		},
+		{
+			name: "1password",
+			data: onePUX(t, `{"accounts": [{"vaults": [{"attrs": {"name": "Private"}, "items": [
+				{"updatedAt": 1767323045, "state": "active", "categoryUuid": "001",
+				 "overview": {"title": "GitHub", "url": "https://github.com"},
+				 "details": {"loginFields": [{"value": "alice", "designation": "username"}, {"value": "pw1", "designation": "password"}]}},
+				{"state": "archived", "overview": {"title": "Old"}},
+				{"state": "active", "categoryUuid": "003", "overview": {"title": "Note"}}]}]}]}`),
+			format:   "1password-1pux",
+			records:  []ImportRecord{{Line: 1, Source: "GitHub", URL: "https://github.com", Username: "alice", Password: "pw1", UpdatedAt: changed}},
+			rejected: 2,
+		},
+		{
+			name: "keepass",
+			data: []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<KeePassFile><Root><Group><Name>Root</Name>
+	<Entry>
+		<Times><LastModificationTime>2026-01-02T03:04:05Z</LastModificationTime></Times>
+		<String><Key>Title</Key><Value>GitHub</Value></String>
+		<String><Key>UserName</Key><Value>alice</Value></String>
+		<String><Key>Password</Key><Value ProtectInMemory="True">pw1</Value></String>
+		<String><Key>URL</Key><Value>https://github.com</Value></String>
+	</Entry>
+	<Group><Name>Mail</Name><Entry>
+		<String><Key>Title</Key><Value>mail</Value></String>
+		<String><Key>UserName</Key><Value>bob</Value></String>
+		<String><Key>Password</Key><Value>pw2</Value></String>
+	</Entry></Group>
+	<Group><Name>Recycle Bin</Name><Entry>
+		<String><Key>Title</Key><Value>deleted</Value></String>
+	</Entry></Group>
+</Group></Root></KeePassFile>`),
+			format: "keepass-xml",
+			records: []ImportRecord{
+				{Line: 1, Source: "GitHub", URL: "https://github.com", Username: "alice", Password: "pw1", UpdatedAt: changed},
+				{Line: 2, Source: "mail", Username: "bob", Password: "pw2"},
+			},
+		},
+		{
+			name: "lastpass",
+			data: []byte("url,username,password,totp,extra,name,grouping,fav\n" +
+				"https://github.com,alice,pw1,,,GitHub,Work,0\n" +
+				"http://sn,,,,secret text,Note,,0\n"),
+			format:   "lastpass-csv",
+			records:  []ImportRecord{{Line: 2, Source: "GitHub", URL: "https://github.com", Username: "alice", Password: "pw1"}},
+			rejected: 1,
+		},
+		{
+			name: "firefox",
+			data: []byte("\"url\",\"username\",\"password\",\"httpRealm\",\"formActionOrigin\",\"guid\",\"timeCreated\",\"timeLastUsed\",\"timePasswordChanged\"\n" +
+				"\"https://github.com\",\"alice\",\"pw1\",,\"https://github.com\",\"{1}\",\"1767323045000\",\"1767323045000\",\"1767323045000\"\n"),
+			format:  "firefox-csv",
+			records: []ImportRecord{{Line: 2, URL: "https://github.com", Username: "alice", Password: "pw1", UpdatedAt: changed}},
+		},
+		{
+			name: "chrome",
+			data: []byte("name,url,username,password,note\n" +
+				"github.com,https://github.com/,alice,pw1,\n" +
+				"broken,https://example.com\n"),
+			format:   "chrome-csv",
+			records:  []ImportRecord{{Line: 2, Source: "github.com", URL: "https://github.com/", Username: "alice", Password: "pw1"}},
+			rejected: 1,
+		},
+		{
+			name: "fortpass",
+			data: []byte("\xef\xbb\xbfsource,url,username,password,updated_at\n" +
+				"GitHub,https://github.com,alice,pw1,2026-01-02T03:04:05Z\n"),
+			format:  "fortpass-csv",
+			records: []ImportRecord{{Line: 2, Source: "GitHub", URL: "https://github.com", Username: "alice", Password: "pw1", UpdatedAt: changed}},
+		},
+		{
+			name: "legacy fortpass without a known header",
+			data: []byte("Service,Website,Login,Secret\n" +
+				"GitHub,https://github.com,alice,pw1\n" +
+				"mail,,bob\n"),
+			format:   "fortpass-csv",
+			records:  []ImportRecord{{Line: 2, Source: "GitHub", URL: "https://github.com", Username: "alice", Password: "pw1"}},
+			rejected: 1,
+		},
+	}
+	for _, tt := range tests {
+		importer, ok := detectImporter(tt.data)
+		if !ok || importer.Name != tt.format {
+			t.Errorf("%s: detected %q, want %s", tt.name, importer.Name, tt.format)
+			continue
+		}
+		records, rejected, err := importer.Parse(tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(records) != len(tt.records) {
+			t.Errorf("%s: got %d records, want %d: %+v", tt.name, len(records), len(tt.records), records)
+			continue
+		}
+		for i, want := range tt.records {
+			got := records[i]
+			if got.Line != want.Line || got.Source != want.Source || got.URL != want.URL || got.Username != want.Username ||
+				got.Password != want.Password || !got.UpdatedAt.Equal(want.UpdatedAt) {
+				t.Errorf("%s: record %d is %+v, want %+v", tt.name, i, got, want)
+			}
+		}
+		if len(rejected) != tt.rejected {
+			t.Errorf("%s: rejected %+v, want %d rows", tt.name, rejected, tt.rejected)
+		}
+	}
+}
+
+func TestDetectImporterRejectsUnknownFiles(t *testing.T) {
+	for _, data := range []string{`{"entries": []}`, "just some notes\n", "a,b\n1,2\n"} {
+		if importer, ok := detectImporter([]byte(data)); ok {
+			t.Errorf("%q detected as %s", data, importer.Name)
+		}
+	}
+}
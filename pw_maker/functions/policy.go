@@ -0,0 +1,198 @@
+package functions
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+// PolicyOptions are the flags of "policy set". Policies start from
+// utils.DefaultGeneratorOptions.
+type PolicyOptions struct {
+	Length      int
+	NoLower     bool
+	NoUpper     bool
+	NoDigits    bool
+	NoSpecial   bool
+	Exclude     string
+	NoAmbiguous bool
+	Passphrase  bool
+	Words       int
+	Separator   string
+}
+
+// GeneratorOptions returns the policy described by the flags.
+func (p PolicyOptions) GeneratorOptions() utils.GeneratorOptions {
+	return utils.GeneratorOptions{
+		Length:           p.Length,
+		Lower:            !p.NoLower,
+		Upper:            !p.NoUpper,
+		Digits:           !p.NoDigits,
+		Special:          !p.NoSpecial,
+		Exclude:          p.Exclude,
+		ExcludeAmbiguous: p.NoAmbiguous,
+		Passphrase:       p.Passphrase,
+		Words:            p.Words,
+		Separator:        p.Separator,
+	}
+}
+
+// generatePasswordFor generates a password for an entry with its generator
+// policy and returns the name of the policy, "" for the default one.
+func generatePasswordFor(source, username, url string) (string, string, error) {
+	opts, policy, err := utils.GeneratorPolicy(utils.DB, source, username, url)
+	if err != nil {
+		return "", "", err
+	}
+	password, err := opts.Generate()
+	return password, policy, err
+}
+
+// policyTarget finds the entry given as source/username, or else returns
+// the domain of target. id is 0 for domains.
+func policyTarget(target string) (int64, string, error) {
+	if source, username, ok := strings.Cut(target, "/"); ok && !strings.Contains(target, "://") {
+		var id int64
+		err := utils.DB.QueryRow("SELECT id FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL", source, username).Scan(&id)
+		if err == sql.ErrNoRows {
+			return 0, "", fmt.Errorf("no password found for %s", target)
+		}
+		return id, target, err
+	}
+	domain := utils.PolicyDomain(target)
+	if domain == "" {
+		return 0, "", fmt.Errorf("%s is neither source/username nor a domain", target)
+	}
+	return 0, domain, nil
+}
+
+// SetGeneratorPolicy stores how passwords are generated for an entry given
+// as source/username, or for the entries of a domain and its subdomains.
+// opts nil removes the policy.
+func SetGeneratorPolicy(target string, opts *utils.GeneratorOptions) {
+	id, name, err := policyTarget(target)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	details := "removed"
+	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+			return
+		}
+		details = opts.String()
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error starting transaction: " + err.Error()))
+		return
+	}
+	defer tx.Rollback()
+	if id != 0 {
+		stored := ""
+		if opts != nil {
+			stored = utils.EncodeGeneratorPolicy(*opts)
+		}
+		_, err = tx.Exec("UPDATE passwords SET generator_policy = ? WHERE id = ?", stored, id)
+		if err == nil {
+			err = utils.Audit(tx, "policy", id, name, details)
+		}
+	} else {
+		err = utils.SetDomainGeneratorPolicy(tx, name, opts)
+		if err == nil {
+			err = utils.Audit(tx, "policy", 0, "", "domain "+name+" "+details)
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving generator policy: " + err.Error()))
+		return
+	}
+	if opts == nil {
+		fmt.Println(utils.StyleSuccess.Render("✅ Removed the generator policy of " + name))
+	} else {
+		fmt.Println(utils.StyleSuccess.Render("✅ Passwords for " + name + " are now generated with " + details))
+	}
+}
+
+// ShowGeneratorPolicy prints the policy passwords for an entry or domain
+// are generated with, and where it comes from.
+func ShowGeneratorPolicy(target string) {
+	id, name, err := policyTarget(target)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	var opts utils.GeneratorOptions
+	var policy string
+	if id != 0 {
+		var url string
+		err = utils.DB.QueryRow("SELECT url FROM passwords WHERE id = ?", id).Scan(&url)
+		if err == nil {
+			source, username, _ := strings.Cut(name, "/")
+			opts, policy, err = utils.GeneratorPolicy(utils.DB, source, username, url)
+		}
+	} else {
+		opts, policy, err = utils.GeneratorPolicy(utils.DB, "", "", name)
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading generator policy: " + err.Error()))
+		return
+	}
+	switch policy {
+	case "":
+		policy = "the default policy"
+	case name:
+		policy = "its own policy"
+	default:
+		policy = "the policy of " + policy
+	}
+	fmt.Println(utils.StyleInfo.Render(fmt.Sprintf("ℹ️ Passwords for %s are generated with %s: %s", name, policy, opts)))
+}
+
+// ListGeneratorPolicies prints the policies of domains and entries.
+func ListGeneratorPolicies() {
+	domains, policies, err := utils.DomainGeneratorPolicies(utils.DB)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading generator policies: " + err.Error()))
+		return
+	}
+	rows, err := utils.DB.Query("SELECT source, username, generator_policy FROM passwords WHERE deleted_at IS NULL AND generator_policy != '' ORDER BY source, username")
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading generator policies: " + err.Error()))
+		return
+	}
+	defer rows.Close()
+	var entries []string
+	for rows.Next() {
+		var source, username, stored string
+		if err := rows.Scan(&source, &username, &stored); err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error reading generator policies: " + err.Error()))
+			return
+		}
+		opts, err := utils.DecodeGeneratorPolicy(stored)
+		if err != nil {
+			fmt.Println(utils.StyleError.Render(fmt.Sprintf("❌ Invalid generator policy of %s/%s: %v", source, username, err)))
+			continue
+		}
+		entries = append(entries, fmt.Sprintf("%s %s/%s  %s", utils.StylePrompt.Render("•"), source, username, opts))
+	}
+
+	if len(domains) == 0 && len(entries) == 0 {
+		fmt.Println(utils.StylePrompt.Render("No generator policies, passwords are generated with " + utils.DefaultGeneratorOptions.String()))
+		return
+	}
+	fmt.Println(utils.StyleHeading.Render("Generator policies:"))
+	for _, domain := range domains {
+		fmt.Printf("%s %s  %s\n", utils.StylePrompt.Render("🌐"), domain, policies[domain])
+	}
+	for _, entry := range entries {
+		fmt.Println(entry)
+	}
+}
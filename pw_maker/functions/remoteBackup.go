@@ -0,0 +1,292 @@
+package functions
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+const (
+	backupKeyName       = "backup_encryption_key"
+	backupTargetSetting = "backup.target"
+	encryptedBackupHead = "FORTPASS-BACKUP-1\n"
+)
+
+// backupTarget resolves the target URL given on the command line or the
+// configured default target.
+func backupTarget(rawURL string) (utils.BackupTarget, string, error) {
+	if rawURL == "" {
+		rawURL = utils.GetSetting(backupTargetSetting, "")
+	}
+	target, err := utils.OpenBackupTarget(rawURL)
+	return target, rawURL, err
+}
+
+// PushBackup uploads an encrypted backup of the vault to a backup target.
+func PushBackup(rawURL string) {
+	target, rawURL, err := backupTarget(rawURL)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	key, err := utils.KeyringSecret(backupKeyName)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading backup key: " + err.Error()))
+		return
+	}
+
+	base := utils.BackupName(time.Now())
+	// The copy is not encrypted with the backup key yet, keep it where only
+	// this user can read it
+	tmp := utils.NewBackupPath(utils.BackupDir(), "push")
+	defer os.Remove(tmp)
+	err = utils.CreateBackup(tmp)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error backing up database: " + err.Error()))
+		return
+	}
+
+	data, err := encryptBackup(tmp, key)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error encrypting backup: " + err.Error()))
+		return
+	}
+
+	name, err := remoteBackupName(target, base)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error listing backups: " + err.Error()))
+		return
+	}
+	err = target.Put(name, data)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error uploading backup: " + err.Error()))
+		return
+	}
+
+	audit("backup-push", 0, "", "uploaded "+name+" to "+rawURL)
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Encrypted backup %s uploaded to %s (%d bytes)", name, rawURL, len(data))))
+}
+
+// ListRemoteBackups prints the backups stored in a backup target.
+func ListRemoteBackups(rawURL string) {
+	target, rawURL, err := backupTarget(rawURL)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	backups, err := target.List()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error listing backups: " + err.Error()))
+		return
+	}
+	if len(backups) == 0 {
+		fmt.Println(utils.StylePrompt.Render("No backups found in " + rawURL))
+		return
+	}
+
+	fmt.Println(utils.StyleHeading.Render(fmt.Sprintf("Backups in %s:", rawURL)))
+	for _, b := range backups {
+		size := ""
+		if b.Size > 0 {
+			size = fmt.Sprintf("  %d KiB", (b.Size+1023)/1024)
+		}
+		fmt.Printf("%s %s  %s%s\n", utils.StylePrompt.Render("•"), b.Path, b.CreatedAt.Format("2006-01-02 15:04:05"), size)
+	}
+}
+
+// PruneRemoteBackups removes the backups of a target that fall outside of
+// the retention policy.
+func PruneRemoteBackups(rawURL string, retention utils.Retention, dryRun bool) {
+	target, rawURL, err := backupTarget(rawURL)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	backups, err := target.List()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error listing backups: " + err.Error()))
+		return
+	}
+
+	expired := utils.SelectExpiredBackups(backups, retention)
+	for _, b := range expired {
+		if dryRun {
+			fmt.Println(utils.StyleInfo.Render("Would remove " + b.Path))
+			continue
+		}
+		if err := target.Delete(b.Path); err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error removing " + b.Path + ": " + err.Error()))
+			return
+		}
+		fmt.Println(utils.StyleInfo.Render("🗑️  Removed old backup " + b.Path))
+	}
+	if !dryRun && len(expired) > 0 {
+		audit("backup-prune", 0, "", fmt.Sprintf("removed %d backups from %s", len(expired), rawURL))
+	}
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ %d of %d backups in %s kept", len(backups)-len(expired), len(backups), rawURL)))
+}
+
+// PullBackup downloads and decrypts a backup into a database file that can be
+// restored with restore-backup.
+func PullBackup(rawURL, name, destination string) {
+	target, _, err := backupTarget(rawURL)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	if destination == "" {
+		destination = strings.TrimSuffix(filepath.Base(name), ".enc")
+	}
+	if _, err := os.Stat(destination); err == nil {
+		fmt.Println(utils.StyleError.Render("❌ Destination already exists: " + destination))
+		return
+	}
+
+	key, err := utils.KeyringSecret(backupKeyName)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading backup key: " + err.Error()))
+		return
+	}
+
+	data, err := target.Get(name)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error downloading backup: " + err.Error()))
+		return
+	}
+
+	db, err := decryptBackup(data, key)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error decrypting backup: " + err.Error()))
+		return
+	}
+
+	err = os.WriteFile(destination, db, 0600)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error writing backup: " + err.Error()))
+		return
+	}
+
+	audit("backup-pull", 0, "", "downloaded "+name+" from "+rawURL+" to "+destination)
+	fmt.Println(utils.StyleSuccess.Render("✅ Backup decrypted to " + destination))
+	fmt.Println(utils.StyleInfo.Render("   Restore it with: fortpass restore-backup " + destination))
+}
+
+// BackupTargetCommand shows or sets the default backup target.
+func BackupTargetCommand(rawURL string) {
+	if rawURL == "" {
+		current := utils.GetSetting(backupTargetSetting, "")
+		if current == "" {
+			fmt.Println(utils.StylePrompt.Render("No default backup target configured."))
+			return
+		}
+		fmt.Println(current)
+		return
+	}
+
+	if _, err := utils.OpenBackupTarget(rawURL); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	if err := utils.SetSetting(backupTargetSetting, rawURL); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving backup target: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render("✅ Default backup target set to " + rawURL))
+}
+
+// BackupKeyCommand prints the backup key, or replaces it with the given hex
+// key so backups from another machine can be decrypted.
+func BackupKeyCommand(newKey string) {
+	if newKey != "" {
+		key, err := hex.DecodeString(newKey)
+		if err == nil {
+			err = utils.SetKeyringSecret(backupKeyName, key)
+		}
+		if err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error setting backup key: " + err.Error()))
+			return
+		}
+		fmt.Println(utils.StyleSuccess.Render("✅ Backup key replaced"))
+		return
+	}
+
+	key, err := utils.KeyringSecret(backupKeyName)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading backup key: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StylePrompt.Render("Keep this key somewhere safe, remote backups cannot be restored without it:"))
+	fmt.Println(utils.StylePassword.Render(hex.EncodeToString(key)))
+}
+
+// remoteBackupName returns a backup name based on base that is not yet used
+// in target.
+func remoteBackupName(target utils.BackupTarget, base string) (string, error) {
+	backups, err := target.List()
+	if err != nil {
+		return "", err
+	}
+	used := make(map[string]bool)
+	for _, b := range backups {
+		used[b.Path] = true
+	}
+
+	name := base + ".db.enc"
+	for n := 2; used[name]; n++ {
+		name = fmt.Sprintf("%s_%d.db.enc", base, n)
+	}
+	return name, nil
+}
+
+func encryptBackup(path string, key []byte) ([]byte, error) {
+	db, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.ModTime = time.Now()
+	if _, err := zw.Write(db); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := utils.EncryptWithKey(key, compressed.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(encryptedBackupHead), ciphertext...), nil
+}
+
+func decryptBackup(data, key []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptedBackupHead)) {
+		return nil, errors.New("not an encrypted fortpass backup")
+	}
+	compressed, err := utils.DecryptWithKey(key, data[len(encryptedBackupHead):])
+	if err != nil {
+		return nil, errors.New("wrong backup key or corrupted backup")
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
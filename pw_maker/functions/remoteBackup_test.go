@@ -0,0 +1,41 @@
+package functions
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedBackupRoundTrip(t *testing.T) {
+	db := bytes.Repeat([]byte("SQLite format 3\x00 github.com johndoe hunter2 "), 100)
+	path := filepath.Join(t.TempDir(), "fortpass_20260101_120000_push.db")
+	if err := os.WriteFile(path, db, 0600); err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{3}, 32)
+
+	data, err := encryptBackup(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(encryptedBackupHead)) || bytes.Contains(data, []byte("hunter2")) {
+		t.Fatal("the uploaded backup is not encrypted")
+	}
+
+	got, err := decryptBackup(data, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Error("decrypted backup differs from the database")
+	}
+
+	if _, err := decryptBackup(data, bytes.Repeat([]byte{4}, 32)); err == nil {
+		t.Error("backup decrypted with the wrong key")
+	}
+	data[len(data)-1] ^= 1
+	if _, err := decryptBackup(data, key); err == nil {
+		t.Error("changed backup decrypted")
+	}
+}
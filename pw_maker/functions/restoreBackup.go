@@ -0,0 +1,210 @@
+package functions
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+type RestoreOptions struct {
+	Yes bool
+}
+
+// RestoreBackup replaces the vault with a backup after validating it and
+// showing what would change. The current vault is backed up first, so the
+// restore can be rolled back by restoring that backup.
+func RestoreBackup(backupPath string, opts RestoreOptions) {
+	err := checkSQLiteHeader(backupPath)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	err = utils.VerifyDatabase(backupPath)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ The backup failed verification: " + err.Error()))
+		return
+	}
+
+	backupDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&_pragma_key=%s", backupPath, utils.EncryptionKey))
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error opening backup: " + err.Error()))
+		return
+	}
+	version, err := utils.ReadSchemaVersion(backupDB)
+	if err != nil {
+		backupDB.Close()
+		fmt.Println(utils.StyleError.Render("❌ Error reading backup schema version: " + err.Error()))
+		return
+	}
+	if version > utils.SchemaVersion {
+		backupDB.Close()
+		fmt.Println(utils.StyleError.Render(fmt.Sprintf("❌ The backup has schema version %d, this fortpass only supports up to %d. Upgrade fortpass first.", version, utils.SchemaVersion)))
+		return
+	}
+	restored, err := utils.ReadCredentials(backupDB)
+	backupDB.Close()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading backup: " + err.Error()))
+		return
+	}
+
+	current, err := utils.GetCredentials()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error fetching passwords: " + err.Error()))
+		return
+	}
+
+	fmt.Println(utils.StyleHeading.Render("Backup " + backupPath))
+	fmt.Printf("Schema version: %d (current %d)\n", version, utils.SchemaVersion)
+	fmt.Printf("Entries: %d in backup, %d in current vault\n\n", len(restored), len(current))
+	printRestorePreview(current, restored)
+
+	if !opts.Yes && !confirm("Replace the current vault with this backup? (y/n)") {
+		fmt.Println(utils.StylePrompt.Render("👋 Restore cancelled, nothing was changed."))
+		return
+	}
+
+	// Migrate a copy of the backup next to the vault so a failing migration
+	// leaves the current vault untouched.
+	staged := utils.DBPath + ".restore"
+	err = copyFile(backupPath, staged)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error copying backup: " + err.Error()))
+		return
+	}
+	err = migrateStaged(staged, version)
+	if err != nil {
+		os.Remove(staged)
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	// Recorded before the rollback backup is taken, so it is part of the log
+	// of the replaced vault as well.
+	audit("restore-backup", 0, "", "replacing the vault with "+backupPath)
+	rollback, err := utils.AutoBackup("pre-restore")
+	if rollback == "" {
+		os.Remove(staged)
+		fmt.Println(utils.StyleError.Render("❌ Error backing up the current vault, aborting: " + err.Error()))
+		return
+	}
+
+	err = utils.ReplaceVault(staged)
+	if err != nil {
+		os.Remove(staged)
+		fmt.Println(utils.StyleError.Render("❌ Error replacing the vault: " + err.Error()))
+		return
+	}
+
+	audit("restore-backup", 0, "", "restored from "+backupPath+", previous vault saved to "+rollback)
+	fmt.Println(utils.StyleSuccess.Render("✅ Vault restored from " + backupPath))
+	fmt.Println(utils.StyleInfo.Render("💾 The previous vault was saved to " + rollback))
+	fmt.Println(utils.StyleInfo.Render("   To roll back run: fortpass restore-backup " + rollback))
+}
+
+func checkSQLiteHeader(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening backup: %w", err)
+	}
+	defer f.Close()
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.Equal(header, []byte("SQLite format 3\x00")) {
+		return fmt.Errorf("%s is not a SQLite database", path)
+	}
+	return nil
+}
+
+func migrateStaged(path string, version int) error {
+	if version == utils.SchemaVersion {
+		return nil
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%s?_pragma_key=%s", path, utils.EncryptionKey))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fmt.Println(utils.StyleInfo.Render(fmt.Sprintf("ℹ️ Migrating backup from schema version %d to %d", version, utils.SchemaVersion)))
+	return utils.MigrateDatabase(db)
+}
+
+// printRestorePreview lists the entries that a restore would bring back, drop
+// or change compared to the current vault.
+func printRestorePreview(current, restored []utils.Credential) {
+	currentByKey := make(map[string]utils.Credential, len(current))
+	for _, c := range current {
+		currentByKey[credentialKey(c.Source, c.Username, c.URL)] = c
+	}
+
+	var added, changed, removed []string
+	unchanged := 0
+	restoredKeys := make(map[string]bool, len(restored))
+	for _, r := range restored {
+		key := credentialKey(r.Source, r.Username, r.URL)
+		restoredKeys[key] = true
+		c, ok := currentByKey[key]
+		switch {
+		case !ok:
+			added = append(added, r.Source+"/"+r.Username)
+		case c.Password != r.Password:
+			changed = append(changed, r.Source+"/"+r.Username)
+		default:
+			unchanged++
+		}
+	}
+	for _, c := range current {
+		if !restoredKeys[credentialKey(c.Source, c.Username, c.URL)] {
+			removed = append(removed, c.Source+"/"+c.Username)
+		}
+	}
+
+	for _, name := range added {
+		fmt.Println(utils.StyleSuccess.Render("+ " + name + " (only in backup)"))
+	}
+	for _, name := range changed {
+		fmt.Println(utils.StyleInfo.Render("~ " + name + " (different password)"))
+	}
+	for _, name := range removed {
+		fmt.Println(utils.StyleError.Render("- " + name + " (not in backup, will be lost)"))
+	}
+	fmt.Println(utils.StyleHeading.Render(fmt.Sprintf("%d restored, %d changed, %d lost, %d unchanged",
+		len(added), len(changed), len(removed), unchanged)))
+}
+
+func confirm(prompt string) bool {
+	fmt.Println(utils.StylePrompt.Render(prompt))
+	var response string
+	_, err := fmt.Scanln(&response)
+	if err != nil {
+		return false
+	}
+	response = strings.ToLower(strings.TrimSpace(response))
+	return response == "y" || response == "yes"
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,345 @@
+package functions
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+// DueOptions select the entries listed by ShowDue.
+type DueOptions struct {
+	// Within also lists the entries due in the next Within days.
+	Within int
+	Format string
+}
+
+// DueFormats are the output formats of ShowDue.
+var DueFormats = []string{"list", "csv", "json"}
+
+// dueResult is the JSON representation of an entry due for rotation.
+type dueResult struct {
+	Source      string    `json:"source"`
+	Username    string    `json:"username"`
+	Folder      string    `json:"folder"`
+	LastChanged time.Time `json:"last_changed"`
+	Due         time.Time `json:"due"`
+	Overdue     bool      `json:"overdue"`
+}
+
+// SetRotation sets the number of days after which the password of an entry
+// given as source/username, or of every entry in a folder, has to be
+// rotated. A policy of an entry wins over the one of its folders, 0 removes
+// the policy.
+func SetRotation(target, days string) {
+	n, err := strconv.Atoi(strings.TrimSuffix(days, "d"))
+	if err != nil || n < 0 {
+		fmt.Println(utils.StyleError.Render("❌ Invalid number of days " + days))
+		return
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error starting transaction: " + err.Error()))
+		return
+	}
+	defer tx.Rollback()
+
+	label, err := setRotation(tx, target, n)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving rotation policy: " + err.Error()))
+		return
+	}
+	if n == 0 {
+		fmt.Println(utils.StyleSuccess.Render("✅ Removed the rotation policy of " + label))
+	} else {
+		fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Passwords of %s now have to be rotated every %d days", label, n)))
+	}
+}
+
+// setRotation stores the policy of an entry or else a folder and returns
+// what it applies to.
+func setRotation(tx *sql.Tx, target string, days int) (string, error) {
+	if source, username, ok := strings.Cut(target, "/"); ok {
+		var id int64
+		err := tx.QueryRow("SELECT id FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL", source, username).Scan(&id)
+		if err == nil {
+			if _, err := tx.Exec("UPDATE passwords SET rotate_days = ? WHERE id = ?", days, id); err != nil {
+				return "", err
+			}
+			return target, utils.Audit(tx, "rotation", id, target, rotationDetails(days))
+		}
+		if err != sql.ErrNoRows {
+			return "", err
+		}
+	}
+
+	folder, err := utils.NormalizeFolder(target)
+	if err != nil {
+		return "", err
+	}
+	if err := utils.SetFolderRotation(tx, folder, days); err != nil {
+		return "", err
+	}
+	label := "folder " + folder
+	if folder == "" {
+		label = "the whole vault"
+	}
+	return label, utils.Audit(tx, "rotation", 0, "", label+" "+rotationDetails(days))
+}
+
+func rotationDetails(days int) string {
+	if days == 0 {
+		return "policy removed"
+	}
+	return fmt.Sprintf("every %d days", days)
+}
+
+// ListRotation prints the rotation policies of folders and entries.
+func ListRotation() {
+	policies, err := utils.FolderRotationPolicies(utils.DB)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading rotation policies: " + err.Error()))
+		return
+	}
+	var entries []utils.PasswordEntry
+	for _, e := range utils.GetPasswordEntries() {
+		if e.RotateDays > 0 {
+			entries = append(entries, e)
+		}
+	}
+	if len(policies) == 0 && len(entries) == 0 {
+		fmt.Println(utils.StylePrompt.Render("No rotation policies, set one with: fortpass rotation set <source/username|folder> <days>"))
+		return
+	}
+
+	fmt.Println(utils.StyleHeading.Render("Rotation policies:"))
+	folders := make([]string, 0, len(policies))
+	for folder := range policies {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+	for _, folder := range folders {
+		fmt.Printf("%s %s/  every %d days\n", utils.StylePrompt.Render("📁"), folder, policies[folder])
+	}
+	for _, e := range entries {
+		fmt.Printf("%s %s/%s  every %d days\n", utils.StylePrompt.Render("•"), e.Source, e.Username, e.RotateDays)
+	}
+}
+
+// ShowDue lists the entries whose password is overdue for rotation, and
+// with opts.Within those due in the next days, most overdue first.
+func ShowDue(opts DueOptions) {
+	if opts.Format == "" {
+		opts.Format = "list"
+	}
+	if !slices.Contains(DueFormats, opts.Format) {
+		fmt.Println(utils.StyleError.Render(fmt.Sprintf("❌ unknown format %q (available: %s)", opts.Format, strings.Join(DueFormats, ", "))))
+		return
+	}
+
+	now := time.Now()
+	limit := now.AddDate(0, 0, opts.Within)
+	var due []utils.PasswordEntry
+	for _, e := range utils.GetPasswordEntries() {
+		if !e.RotationDue.IsZero() && e.RotationDue.Before(limit) {
+			due = append(due, e)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].RotationDue.Before(due[j].RotationDue)
+	})
+
+	switch opts.Format {
+	case "json":
+		results := make([]dueResult, len(due))
+		for i, e := range due {
+			results[i] = dueResult{Source: e.Source, Username: e.Username, Folder: e.Folder,
+				LastChanged: e.UpdatedAt, Due: e.RotationDue, Overdue: e.Overdue(now)}
+		}
+		data, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Println(string(data))
+		return
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"source", "username", "folder", "last_changed", "due", "overdue"})
+		for _, e := range due {
+			w.Write([]string{e.Source, e.Username, e.Folder, e.UpdatedAt.Local().Format("2006-01-02"),
+				e.RotationDue.Local().Format("2006-01-02"), strconv.FormatBool(e.Overdue(now))})
+		}
+		w.Flush()
+		return
+	}
+
+	if len(due) == 0 {
+		if opts.Within > 0 {
+			fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ No passwords are due for rotation in the next %d days", opts.Within)))
+		} else {
+			fmt.Println(utils.StyleSuccess.Render("✅ No passwords are overdue for rotation"))
+		}
+		return
+	}
+	fmt.Println(utils.StyleHeading.Render(fmt.Sprintf("%d passwords are due for rotation:", len(due))))
+	for _, e := range due {
+		days := int(math.Round(e.RotationDue.Sub(now).Hours() / 24))
+		when := fmt.Sprintf("due in %d days", days)
+		if e.Overdue(now) {
+			when = utils.StyleError.Render(fmt.Sprintf("overdue by %d days", -days))
+		}
+		fmt.Printf("%s %s/%s  %s (due %s, last changed %s)\n", utils.StylePrompt.Render("•"), e.Source, e.Username, when,
+			e.RotationDue.Local().Format("2006-01-02"), e.UpdatedAt.Local().Format("2006-01-02"))
+	}
+}
+
+// RotateOptions configure RotatePassword.
+type RotateOptions struct {
+	// Timeout is how long the rotation hook may run.
+	Timeout time.Duration
+}
+
+// RotationHookCommand shows, sets or with remove removes the rotation hook
+// of an entry given as source/username.
+func RotationHookCommand(name, path string, remove bool) {
+	source, username, ok := strings.Cut(name, "/")
+	if !ok {
+		fmt.Println(utils.StyleError.Render("❌ Invalid password name format. Use 'source/username'."))
+		return
+	}
+	var id int64
+	var hook string
+	err := utils.DB.QueryRow("SELECT id, rotate_hook FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL", source, username).Scan(&id, &hook)
+	if err == sql.ErrNoRows {
+		fmt.Println(utils.StyleError.Render("❌ No password found for " + name))
+		return
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error fetching password: " + err.Error()))
+		return
+	}
+
+	if path == "" && !remove {
+		if hook == "" {
+			fmt.Println(utils.StylePrompt.Render(name + " has no rotation hook."))
+		} else {
+			fmt.Println(utils.StyleInfo.Render("ℹ️ " + name + " is rotated by " + hook))
+		}
+		return
+	}
+
+	details := "hook removed"
+	if !remove {
+		if path, err = filepath.Abs(path); err == nil {
+			err = utils.CheckRotationHook(path)
+		}
+		if err != nil {
+			fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+			return
+		}
+		details = "hook " + path
+	} else {
+		path = ""
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error starting transaction: " + err.Error()))
+		return
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("UPDATE passwords SET rotate_hook = ? WHERE id = ?", path, id)
+	if err == nil {
+		err = utils.Audit(tx, "rotation", id, name, details)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving rotation hook: " + err.Error()))
+		return
+	}
+	if remove {
+		fmt.Println(utils.StyleSuccess.Render("✅ Removed the rotation hook of " + name))
+	} else {
+		fmt.Println(utils.StyleSuccess.Render("✅ " + name + " is now rotated by " + path))
+	}
+}
+
+// RotatePassword generates a new password for an entry and runs its
+// rotation hook to change it where it is used. The vault is only updated
+// when the hook succeeds; the old password is kept in the history. It exits
+// with status 1 when the password was not rotated.
+func RotatePassword(name string, opts RotateOptions) {
+	source, username, ok := strings.Cut(name, "/")
+	if !ok {
+		fmt.Println(utils.StyleError.Render("❌ Invalid password name format. Use 'source/username'."))
+		os.Exit(1)
+	}
+	var id int64
+	var password, url, hook string
+	err := utils.DB.QueryRow("SELECT id, password, url, rotate_hook FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL",
+		source, username).Scan(&id, &password, &url, &hook)
+	if err == sql.ErrNoRows {
+		fmt.Println(utils.StyleError.Render("❌ No password found for " + name))
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error fetching password: " + err.Error()))
+		os.Exit(1)
+	}
+	if hook == "" {
+		fmt.Println(utils.StyleError.Render("❌ " + name + " has no rotation hook, set one with: fortpass rotation hook " + name + " <executable>"))
+		os.Exit(1)
+	}
+	if err := utils.CheckRotationHook(hook); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Rotation hook: " + err.Error()))
+		os.Exit(1)
+	}
+
+	newPassword, policy, err := generatePasswordFor(source, username, url)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error generating password: " + err.Error()))
+		os.Exit(1)
+	}
+	if policy != "" {
+		fmt.Println(utils.StyleInfo.Render("ℹ️ Generated with the generator policy of " + policy))
+	}
+	fmt.Println(utils.StyleInfo.Render("ℹ️ Running rotation hook " + hook))
+	err = utils.RotationHook{
+		Path:        hook,
+		Source:      source,
+		Username:    username,
+		URL:         url,
+		OldPassword: password,
+		NewPassword: newPassword,
+	}.Run(opts.Timeout, os.Stdout, os.Stderr)
+	if err != nil {
+		audit("rotate", id, name, "hook failed: "+err.Error())
+		fmt.Println(utils.StyleError.Render("❌ Rotation hook failed, the vault keeps the old password: " + err.Error()))
+		// A hook that failed or timed out may have changed the password
+		// before it stopped, so the new one must not get lost either.
+		fmt.Println(utils.StyleError.Render("⚠️  If the hook changed the password anyway, it is now:"))
+		fmt.Println(utils.StylePassword.Render("New password: " + newPassword))
+		os.Exit(1)
+	}
+
+	if err := replacePassword(id, name, newPassword, "rotate", "by hook "+hook); err != nil {
+		// The password has been changed already, it must not get lost.
+		fmt.Println(utils.StyleError.Render("❌ The hook changed the password but it could not be saved: " + err.Error()))
+		fmt.Println(utils.StylePassword.Render("New password: " + newPassword))
+		os.Exit(1)
+	}
+	fmt.Println(utils.StyleSuccess.Render("✅ Rotated the password of " + name))
+}
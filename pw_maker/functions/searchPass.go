@@ -1,8 +1,13 @@
 package functions
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/tadeasf/pw_maker/pw_maker/utils"
 
@@ -14,16 +19,79 @@ var SearchCmd = &cobra.Command{
 	Use:   "search",
 	Short: "Search passwords",
 	Run: func(cmd *cobra.Command, args []string) {
-		SearchPasswords()
+		SearchPasswords("", SearchOptions{})
 	},
 }
 
-func SearchPasswords() {
+// SearchFormats are the output formats of a search with a query. The list
+// format is the default on a terminal, table otherwise.
+var SearchFormats = []string{"list", "table", "json"}
+
+type SearchOptions struct {
+	Format string
+	First  bool
+}
+
+// searchResult is the JSON representation of a match. It never contains
+// the password.
+type searchResult struct {
+	Source      string     `json:"source"`
+	Username    string     `json:"username"`
+	URL         string     `json:"url"`
+	Folder      string     `json:"folder"`
+	Tags        []string   `json:"tags"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	RotationDue *time.Time `json:"rotation_due,omitempty"`
+	Score       int        `json:"score"`
+}
+
+// SearchPasswords opens the search screen, or prints the entries matching
+// query when one is given or the output is not a terminal. With opts.First
+// the password of the best match is copied instead. It exits with status 1
+// when nothing matches.
+func SearchPasswords(query string, opts SearchOptions) {
+	format := opts.Format
+	if format == "" {
+		format = "table"
+		if utils.IsTerminal(os.Stdout) {
+			format = "list"
+		}
+	}
+	if !slices.Contains(SearchFormats, format) {
+		fmt.Fprintln(os.Stderr, utils.StyleError.Render(fmt.Sprintf("❌ unknown search format %q (available: %s)", format, strings.Join(SearchFormats, ", "))))
+		os.Exit(1)
+	}
+
 	entries := utils.GetPasswordEntries()
+	interactive := query == "" && !opts.First && opts.Format == "" && utils.IsTerminal(os.Stdout)
 	if len(entries) == 0 {
-		fmt.Println(utils.StylePrompt.Render("No passwords found in the store."))
+		fmt.Fprintln(os.Stderr, utils.StylePrompt.Render("No passwords found in the store."))
+		if !interactive {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if !interactive {
+		matches := utils.SearchEntries(entries, utils.ParseSearchQuery(query))
+		if len(matches) == 0 {
+			fmt.Fprintln(os.Stderr, utils.StyleError.Render("❌ No passwords match "+query))
+			os.Exit(1)
+		}
+		if opts.First {
+			e := matches[0].Entry
+			if utils.CopyPasswordToClipboard(e.ID) {
+				audit("search", e.ID, e.Source+"/"+e.Username, "first match of "+query)
+			} else {
+				os.Exit(1)
+			}
+			return
+		}
+		printSearchMatches(query, matches, format)
 		return
 	}
+
 	p := tea.NewProgram(utils.InitialSearchModel(entries), tea.WithAltScreen())
 	m, err := p.Run()
 	if err != nil {
@@ -34,7 +102,64 @@ func SearchPasswords() {
 	// Handle the selected item
 	if m, ok := m.(utils.SearchModel); ok && m.SelectedItem != nil {
 		selectedItem := m.SelectedItem.(utils.ListItem)
-		utils.CopyPasswordToClipboard(selectedItem.Source, selectedItem.Username)
+		if utils.CopyPasswordToClipboard(selectedItem.ID) {
+			audit("search", selectedItem.ID, selectedItem.Source+"/"+selectedItem.Username, "")
+		}
+
+	}
+}
 
+func printSearchMatches(query string, matches []utils.SearchMatch, format string) {
+	switch format {
+	case "json":
+		results := make([]searchResult, len(matches))
+		for i, match := range matches {
+			e := match.Entry
+			tags := e.Tags
+			if tags == nil {
+				tags = []string{}
+			}
+			results[i] = searchResult{Source: e.Source, Username: e.Username, URL: e.URL, Folder: e.Folder, Tags: tags,
+				CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt, Score: match.Score}
+			if !e.RotationDue.IsZero() {
+				results[i].RotationDue = &e.RotationDue
+			}
+		}
+		data, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Println(string(data))
+		return
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SOURCE\tUSERNAME\tURL\tFOLDER\tTAGS\tUPDATED")
+		for _, match := range matches {
+			e := match.Entry
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Source, e.Username, e.URL, e.Folder,
+				strings.Join(e.Tags, ","), e.UpdatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		w.Flush()
+		return
+	}
+
+	entries := make([]utils.PasswordEntry, len(matches))
+	for i, match := range matches {
+		entries[i] = match.Entry
+	}
+	if n := utils.CountOverdue(entries, time.Now()); n > 0 {
+		fmt.Println(utils.OverdueBanner(n))
+	}
+	fmt.Println(utils.StyleHeading.Render(fmt.Sprintf("%d passwords match %s:", len(matches), query)))
+	for _, match := range matches {
+		e := match.Entry
+		line := fmt.Sprintf("%s %s/%s  %s", utils.StylePrompt.Render("•"),
+			utils.Highlight(e.Source, match.Matched[utils.SearchFieldSource], utils.StyleMatch),
+			utils.Highlight(e.Username, match.Matched[utils.SearchFieldUsername], utils.StyleMatch),
+			utils.Highlight(e.URL, match.Matched[utils.SearchFieldURL], utils.StyleMatch))
+		if e.Folder != "" {
+			line += "  📁 " + utils.Highlight(e.Folder, match.Matched[utils.SearchFieldFolder], utils.StyleMatch)
+		}
+		if len(e.Tags) > 0 {
+			line += "  #" + strings.Join(e.Tags, " #")
+		}
+		fmt.Println(line + overdueMark(e))
 	}
 }
@@ -2,6 +2,7 @@ package functions
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/tadeasf/pw_maker/pw_maker/utils"
 
@@ -12,19 +13,40 @@ var ShowCmd = &cobra.Command{
 	Use:   "show",
 	Short: "Show all passwords",
 	Run: func(cmd *cobra.Command, args []string) {
-		ShowPasswords()
+		ShowPasswords("")
 	},
 }
 
-func ShowPasswords() {
-	passwords := utils.GetPasswordEntries()
-	fmt.Printf("Debug: Number of passwords found: %d\n", len(passwords))
+// ShowPasswords lists the passwords grouped by folder, only those inside
+// folder when it is set.
+func ShowPasswords(folder string) {
+	folder, err := utils.NormalizeFolder(folder)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	var passwords []utils.PasswordEntry
+	for _, entry := range utils.GetPasswordEntries() {
+		if utils.InFolder(entry.Folder, folder) {
+			passwords = append(passwords, entry)
+		}
+	}
 	if len(passwords) == 0 {
-		fmt.Println(utils.StylePrompt.Render("No passwords found in the store."))
+		if folder == "" {
+			fmt.Println(utils.StylePrompt.Render("No passwords found in the store."))
+		} else {
+			fmt.Println(utils.StylePrompt.Render("No passwords found in folder " + folder + "."))
+		}
 		return
 	}
-	fmt.Println(utils.StyleHeading.Render("Available passwords:"))
-	for _, entry := range passwords {
-		fmt.Printf("%s %s/%s\n", utils.StylePrompt.Render("•"), entry.Source, entry.Username)
+	if n := utils.CountOverdue(passwords, time.Now()); n > 0 {
+		fmt.Println(utils.OverdueBanner(n))
+	}
+	if folder == "" {
+		fmt.Println(utils.StyleHeading.Render("Available passwords:"))
+	} else {
+		fmt.Println(utils.StyleHeading.Render("Available passwords in " + folder + ":"))
 	}
+	printFolderTree(passwords, folder)
 }
@@ -0,0 +1,412 @@
+package functions
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+const (
+	syncModeSetting  = "sync.mode"
+	syncTokenSetting = "sync.token"
+	syncSeqSetting   = "sync.server_seq"
+	syncModeServer   = "server"
+)
+
+type ServeOptions struct {
+	Addr    string
+	Data    string
+	Token   string
+	TLSCert string
+	TLSKey  string
+}
+
+// Serve runs the sync server. It stores only the encrypted entries pushed by
+// clients and never needs the sync key.
+func Serve(opts ServeOptions) {
+	if opts.Data == "" {
+		opts.Data = filepath.Join(filepath.Dir(utils.DBPath), "server.db")
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Data), 0700); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error creating data directory: " + err.Error()))
+		return
+	}
+
+	db, err := sql.Open("sqlite3", opts.Data+"?_busy_timeout=5000")
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error opening server database: " + err.Error()))
+		return
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	server, err := utils.NewSyncServer(db, opts.Token)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	if opts.Token == "" {
+		fmt.Println(utils.StyleInfo.Render("ℹ️ No --token given, anyone who can reach the server can read and write the encrypted entries"))
+	}
+
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Sync server listening on %s, storing entries in %s", opts.Addr, opts.Data)))
+	if opts.TLSCert != "" {
+		err = http.ListenAndServeTLS(opts.Addr, opts.TLSCert, opts.TLSKey, server)
+	} else {
+		err = http.ListenAndServe(opts.Addr, server)
+	}
+	fmt.Println(utils.StyleError.Render("❌ Sync server stopped: " + err.Error()))
+}
+
+// initServerSync switches syncing to a sync server. Revisions of a previous
+// server do not apply to the new one, so every entry is pushed again.
+func initServerSync(remote, token string) error {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE passwords SET revision = 0, synced_hash = NULL")
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM sync_tombstones")
+	}
+	if err != nil {
+		return err
+	}
+	for key, value := range map[string]string{
+		syncRemoteSetting: remote,
+		syncModeSetting:   syncModeServer,
+		syncTokenSetting:  token,
+		syncSeqSetting:    "0",
+	} {
+		_, err = tx.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// serverSyncState is the sync metadata of a local entry.
+type serverSyncState struct {
+	ID         int64
+	Entry      syncEntry
+	Revision   int64
+	SyncedHash string
+}
+
+func (s serverSyncState) dirty() bool {
+	return syncEntryHash(s.Entry) != s.SyncedHash
+}
+
+func syncEntryHash(entry syncEntry) string {
+	data, _ := json.Marshal(entry)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// syncWithServer pulls the changes of other clients, merges them entry by
+// entry and pushes the local changes. Pushes rejected because another client
+// changed the entry first are merged and pushed again.
+func syncWithServer(remote string, key []byte) (syncSummary, error) {
+	var summary syncSummary
+	client := utils.NewSyncClient(remote, utils.GetSetting(syncTokenSetting, ""))
+
+	for attempt := 1; ; attempt++ {
+		if err := pullFromServer(client, key, &summary); err != nil {
+			return summary, err
+		}
+		conflicts, err := pushToServer(client, key, &summary)
+		if err != nil {
+			return summary, err
+		}
+		if conflicts == 0 {
+			return summary, nil
+		}
+		if attempt == syncMaxAttempts {
+			return summary, errSyncPushRejected
+		}
+	}
+}
+
+func readServerSyncState() (map[string]*serverSyncState, map[string]int64, error) {
+	entries, ids, err := readLocalSyncEntries()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	states := make(map[string]*serverSyncState, len(entries))
+	rows, err := utils.DB.Query("SELECT uuid, revision, COALESCE(synced_hash, '') FROM passwords WHERE deleted_at IS NULL")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		state := &serverSyncState{}
+		var uuid string
+		if err := rows.Scan(&uuid, &state.Revision, &state.SyncedHash); err != nil {
+			return nil, nil, err
+		}
+		state.ID = ids[uuid]
+		state.Entry = entries[uuid]
+		states[uuid] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	tombstones := make(map[string]int64)
+	rows, err = utils.DB.Query("SELECT uuid, revision FROM sync_tombstones")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uuid string
+		var revision int64
+		if err := rows.Scan(&uuid, &revision); err != nil {
+			return nil, nil, err
+		}
+		tombstones[uuid] = revision
+	}
+	return states, tombstones, rows.Err()
+}
+
+func pullFromServer(client *utils.SyncClient, key []byte, summary *syncSummary) error {
+	since, _ := strconv.ParseInt(utils.GetSetting(syncSeqSetting, "0"), 10, 64)
+	resp, err := client.Pull(since)
+	if err != nil {
+		return err
+	}
+	if len(resp.Entries) == 0 {
+		return nil
+	}
+
+	states, tombstones, err := readServerSyncState()
+	if err != nil {
+		return err
+	}
+
+	// Skip the changes already known locally, such as our own pushes.
+	var blobs []utils.SyncBlob
+	for _, blob := range resp.Entries {
+		if local, ok := states[blob.UUID]; ok && local.Revision >= blob.Revision {
+			continue
+		}
+		if revision, ok := tombstones[blob.UUID]; ok && revision >= blob.Revision {
+			continue
+		}
+		blobs = append(blobs, blob)
+	}
+	if len(blobs) > 0 && !autoBackup("sync") {
+		return errors.New("no backup of the vault could be taken")
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, blob := range blobs {
+		err := applyServerBlob(tx, blob, key, states, tombstones, summary)
+		if errors.Is(err, errUnreadableBlob) {
+			// One broken entry must not stop every later sync.
+			fmt.Println(utils.StyleError.Render(fmt.Sprintf("⚠️  Skipped entry %s: %s", blob.UUID, err.Error())))
+			summary.Skipped++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("applying entry %s: %w", blob.UUID, err)
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value",
+		syncSeqSetting, strconv.FormatInt(resp.Seq, 10))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// errUnreadableBlob is returned by applyServerBlob for entries that cannot
+// be decrypted or decoded. They are skipped instead of failing the sync.
+var errUnreadableBlob = errors.New("cannot read the entry from the server")
+
+// applyServerBlob merges one entry pulled from the server into the vault.
+// Entries without local changes take the server version. Entries changed
+// locally are merged and stay marked as changed, so they are pushed with the
+// server revision as their new base.
+func applyServerBlob(tx *sql.Tx, blob utils.SyncBlob, key []byte, states map[string]*serverSyncState, tombstones map[string]int64, summary *syncSummary) error {
+	local, exists := states[blob.UUID]
+	if blob.Deleted {
+		switch {
+		case exists && local.dirty():
+			// Changed locally after it was deleted elsewhere: keep it.
+			summary.Conflicts++
+			local.Revision = blob.Revision
+			_, err := tx.Exec("UPDATE passwords SET revision = ? WHERE id = ?", blob.Revision, local.ID)
+			return err
+		case exists:
+			if err := utils.TrashEntry(tx, local.ID); err != nil {
+				return err
+			}
+			delete(states, blob.UUID)
+			summary.Deleted++
+		}
+		delete(tombstones, blob.UUID)
+		_, err := tx.Exec("DELETE FROM sync_tombstones WHERE uuid = ?", blob.UUID)
+		return err
+	}
+
+	remote, err := openServerBlob(blob, key)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errUnreadableBlob, err.Error())
+	}
+	syncedHash := syncEntryHash(remote)
+
+	merged := remote
+	var current *syncEntry
+	id := int64(0)
+	switch {
+	case exists && local.dirty():
+		merged = mergeSyncConflict(local.Entry, remote)
+		merged.UUID = blob.UUID
+		current, id = &local.Entry, local.ID
+		summary.Conflicts++
+	case exists:
+		current, id = &local.Entry, local.ID
+		summary.Updated++
+	default:
+		// A different local entry for the same account, e.g. added on two
+		// machines before syncing, is merged into the server's entry. It is
+		// stored in place of the local one, keeping its history, since the
+		// account can only be stored once.
+		for uuid, other := range states {
+			if credentialKey(other.Entry.Source, other.Entry.Username, other.Entry.URL) != credentialKey(remote.Source, remote.Username, remote.URL) {
+				continue
+			}
+			merged = mergeSyncConflict(other.Entry, remote)
+			merged.UUID = blob.UUID
+			current, id = &other.Entry, other.ID
+			delete(states, uuid)
+			summary.Conflicts++
+			break
+		}
+		summary.Added++
+	}
+
+	// A local deletion loses against a newer change on the server.
+	delete(tombstones, blob.UUID)
+	if _, err := tx.Exec("DELETE FROM sync_tombstones WHERE uuid = ?", blob.UUID); err != nil {
+		return err
+	}
+
+	id, err = storeSyncEntry(tx, id, current, merged)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE passwords SET revision = ?, synced_hash = ? WHERE id = ?", blob.Revision, syncedHash, id)
+	if err != nil {
+		return err
+	}
+	states[blob.UUID] = &serverSyncState{ID: id, Entry: merged, Revision: blob.Revision, SyncedHash: syncedHash}
+	return nil
+}
+
+// serverBlobAD is the additional data sync server blobs are encrypted with.
+// It binds every blob to the uuid and the revision it is pushed as, so a
+// server cannot pass a blob off as another entry or replay an older one as
+// a new revision.
+func serverBlobAD(uuid string, revision int64) []byte {
+	return []byte(fmt.Sprintf("fortpass-sync-blob\x00%s\x00%d", uuid, revision))
+}
+
+// sealServerBlob encrypts entry to be pushed as revision of uuid. The server
+// accepts a push as the revision after its base revision.
+func sealServerBlob(entry syncEntry, uuid string, revision int64, key []byte) ([]byte, error) {
+	plaintext, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return utils.EncryptBound(key, plaintext, serverBlobAD(uuid, revision))
+}
+
+// openServerBlob decrypts a blob pulled from the server and checks that it
+// was pushed as this uuid and revision.
+func openServerBlob(blob utils.SyncBlob, key []byte) (syncEntry, error) {
+	var entry syncEntry
+	plaintext, err := utils.DecryptBound(key, blob.Data, serverBlobAD(blob.UUID, blob.Revision))
+	if err != nil {
+		return entry, fmt.Errorf("cannot decrypt revision %d, check the sync key; otherwise the server changed the entry", blob.Revision)
+	}
+	if err := json.Unmarshal(plaintext, &entry); err != nil {
+		return entry, err
+	}
+	if entry.UUID != blob.UUID {
+		return entry, fmt.Errorf("the server sent entry %s under uuid %s", entry.UUID, blob.UUID)
+	}
+	return entry, nil
+}
+
+// pushToServer sends the changed and deleted entries and returns the number
+// of changes rejected because of newer versions on the server.
+func pushToServer(client *utils.SyncClient, key []byte, summary *syncSummary) (int, error) {
+	states, tombstones, err := readServerSyncState()
+	if err != nil {
+		return 0, err
+	}
+
+	var req utils.SyncPushRequest
+	hashes := make(map[string]string)
+	for uuid, state := range states {
+		if !state.dirty() {
+			continue
+		}
+		data, err := sealServerBlob(state.Entry, uuid, state.Revision+1, key)
+		if err != nil {
+			return 0, err
+		}
+		req.Entries = append(req.Entries, utils.SyncPush{UUID: uuid, BaseRevision: state.Revision, Data: data})
+		hashes[uuid] = syncEntryHash(state.Entry)
+	}
+	for uuid, revision := range tombstones {
+		req.Entries = append(req.Entries, utils.SyncPush{UUID: uuid, BaseRevision: revision, Deleted: true})
+	}
+	if len(req.Entries) == 0 {
+		return 0, nil
+	}
+
+	resp, err := client.Push(req)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for _, accepted := range resp.Accepted {
+		if accepted.Deleted {
+			_, err = tx.Exec("DELETE FROM sync_tombstones WHERE uuid = ?", accepted.UUID)
+		} else {
+			_, err = tx.Exec("UPDATE passwords SET revision = ?, synced_hash = ? WHERE uuid = ?", accepted.Revision, hashes[accepted.UUID], accepted.UUID)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	summary.Pushed += len(resp.Accepted)
+	return len(resp.Conflicts), tx.Commit()
+}
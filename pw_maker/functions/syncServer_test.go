@@ -0,0 +1,140 @@
+package functions
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+	"github.com/zalando/go-keyring"
+)
+
+func TestServerBlobBinding(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	entry := syncEntry{UUID: "a", Source: "github.com", Username: "johndoe", Password: "hunter2"}
+	data, err := sealServerBlob(entry, "a", 2, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := openServerBlob(utils.SyncBlob{UUID: "a", Revision: 2, Data: data}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Password != entry.Password {
+		t.Errorf("got password %q, want %q", got.Password, entry.Password)
+	}
+
+	for _, blob := range []utils.SyncBlob{
+		{UUID: "b", Revision: 2, Data: data}, // moved to another entry
+		{UUID: "a", Revision: 3, Data: data}, // replayed as a newer revision
+		{UUID: "a", Revision: 1, Data: data},
+	} {
+		if _, err := openServerBlob(blob, key); err == nil {
+			t.Errorf("blob served as %s revision %d was accepted", blob.UUID, blob.Revision)
+		}
+	}
+
+	// An entry sealed for its own uuid must not be accepted under another one
+	other, err := sealServerBlob(syncEntry{UUID: "c"}, "b", 1, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openServerBlob(utils.SyncBlob{UUID: "b", Revision: 1, Data: other}, key); err == nil {
+		t.Error("entry c served as b was accepted")
+	}
+}
+
+func newTestSyncClient(t *testing.T) *utils.SyncClient {
+	t.Helper()
+	keyring.MockInit()
+	t.Setenv("HOME", t.TempDir())
+	serverDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "server.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { serverDB.Close() })
+	server, err := utils.NewSyncServer(serverDB, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return utils.NewSyncClient(ts.URL, "")
+}
+
+func TestPullMovesDeletedEntriesToTheTrash(t *testing.T) {
+	client := newTestSyncClient(t)
+	key := bytes.Repeat([]byte{7}, 32)
+	data, err := sealServerBlob(syncEntry{UUID: "a", Source: "github.com", Username: "alice", Password: "hunter2",
+		CreatedAt: "2026-01-01 10:00:00", UpdatedAt: "2026-01-01 10:00:00"}, "a", 1, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Push(utils.SyncPushRequest{Entries: []utils.SyncPush{{UUID: "a", Data: data}}}); err != nil {
+		t.Fatal(err)
+	}
+	vault := newTestVault(t)
+	var summary syncSummary
+	if err := pullFromServer(client, key, &summary); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Push(utils.SyncPushRequest{Entries: []utils.SyncPush{{UUID: "a", BaseRevision: 1, Deleted: true}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pullFromServer(client, key, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Deleted != 1 || len(vault.entries(t)) != 0 {
+		t.Fatalf("deleted %d entries, %d left, want the entry deleted", summary.Deleted, len(vault.entries(t)))
+	}
+	trash, err := utils.ListTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].UUID != "a" {
+		t.Errorf("got trash %+v, want the deleted entry", trash)
+	}
+}
+
+func TestPullSkipsUnreadableBlobs(t *testing.T) {
+	client := newTestSyncClient(t)
+
+	key := bytes.Repeat([]byte{7}, 32)
+	good, err := sealServerBlob(syncEntry{UUID: "good", Source: "github.com", Username: "alice", Password: "hunter2",
+		CreatedAt: "2026-01-01 10:00:00", UpdatedAt: "2026-01-01 10:00:00"}, "good", 1, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := sealServerBlob(syncEntry{UUID: "foreign", Source: "mail", Username: "bob", Password: "mailpw"}, "foreign", 1, bytes.Repeat([]byte{8}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Push(utils.SyncPushRequest{Entries: []utils.SyncPush{
+		{UUID: "foreign", Data: foreign},
+		{UUID: "corrupt", Data: []byte("not encrypted")},
+		{UUID: "good", Data: good},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vault := newTestVault(t)
+	var summary syncSummary
+	if err := pullFromServer(client, key, &summary); err != nil {
+		t.Fatalf("pull failed on unreadable entries: %v", err)
+	}
+	if summary.Added != 1 || summary.Skipped != 2 {
+		t.Errorf("pull added %d and skipped %d entries, want 1 and 2", summary.Added, summary.Skipped)
+	}
+	entries := vault.entries(t)
+	if len(entries) != 1 || entries["good"].Password != "hunter2" {
+		t.Errorf("got entries %+v, want only good", entries)
+	}
+	if seq := utils.GetSetting(syncSeqSetting, "0"); seq != "3" {
+		t.Errorf("sync sequence is %s after the pull, want 3", seq)
+	}
+}
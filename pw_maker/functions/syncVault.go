@@ -0,0 +1,753 @@
+package functions
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+const (
+	syncKeyName       = "sync_key"
+	syncRemoteSetting = "sync.remote"
+	syncCommitSetting = "sync.last_commit"
+	syncBranch        = "main"
+	syncEntriesDir    = "entries"
+	syncKeyCheckFile  = "key-check"
+	syncKeyCheckText  = "fortpass-sync"
+	syncMaxAttempts   = 3
+)
+
+var errSyncPushRejected = errors.New("the remote changed while syncing")
+
+type syncHistory struct {
+	Password   string `json:"password"`
+	CreatedAt  string `json:"created_at"`
+	ArchivedAt string `json:"archived_at"`
+}
+
+// syncEntry is the content of one encrypted entry file in the sync
+// repository.
+type syncEntry struct {
+	UUID       string        `json:"uuid"`
+	Source     string        `json:"source"`
+	Username   string        `json:"username"`
+	URL        string        `json:"url"`
+	Folder     string        `json:"folder,omitempty"`
+	Tags       []string      `json:"tags,omitempty"`
+	Notes      string        `json:"notes,omitempty"`
+	RotateDays int           `json:"rotate_days,omitempty"`
+	Policy     string        `json:"generator_policy,omitempty"`
+	Password   string        `json:"password"`
+	CreatedAt  string        `json:"created_at"`
+	UpdatedAt  string        `json:"updated_at"`
+	History    []syncHistory `json:"history,omitempty"`
+}
+
+type syncSummary struct {
+	Added     int
+	Updated   int
+	Deleted   int
+	Pushed    int
+	Conflicts int
+	// Skipped counts entries pulled from a sync server that could not be
+	// read.
+	Skipped int
+}
+
+func syncRepo() utils.GitRepo {
+	return utils.GitRepo{Dir: filepath.Join(filepath.Dir(utils.DBPath), "sync")}
+}
+
+type SyncInitOptions struct {
+	AskKey bool
+	Server bool
+	Token  string
+}
+
+// SyncInit sets up syncing with a git remote, or with a sync server when
+// opts.Server is set, and runs a first sync. With opts.AskKey the sync key
+// of a vault that is already synced from another machine is read first.
+func SyncInit(remote string, opts SyncInitOptions) {
+	if opts.AskKey {
+		key, err := utils.ReadPassphrase("Enter the sync key: ")
+		var decoded []byte
+		if err == nil {
+			decoded, err = hex.DecodeString(strings.TrimSpace(key))
+		}
+		if err == nil {
+			err = utils.SetKeyringSecret(syncKeyName, decoded)
+		}
+		if err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error setting sync key: " + err.Error()))
+			return
+		}
+	}
+
+	if opts.Server {
+		if err := initServerSync(remote, opts.Token); err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error saving sync settings: " + err.Error()))
+			return
+		}
+		fmt.Println(utils.StyleSuccess.Render("✅ Sync set up with server " + remote))
+		SyncVault()
+		return
+	}
+
+	repo := syncRepo()
+	err := os.MkdirAll(repo.Dir, 0700)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error creating sync directory: " + err.Error()))
+		return
+	}
+	if _, err := os.Stat(filepath.Join(repo.Dir, ".git")); os.IsNotExist(err) {
+		_, err = repo.Git("init", "-q")
+		if err == nil {
+			_, err = repo.Git("symbolic-ref", "HEAD", "refs/heads/"+syncBranch)
+		}
+		if err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error creating sync repository: " + err.Error()))
+			return
+		}
+	}
+
+	if _, err := repo.Git("remote", "get-url", "origin"); err != nil {
+		_, err = repo.Git("remote", "add", "origin", remote)
+	} else {
+		_, err = repo.Git("remote", "set-url", "origin", remote)
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error setting sync remote: " + err.Error()))
+		return
+	}
+
+	err = utils.SetSetting(syncRemoteSetting, remote)
+	if err == nil {
+		err = utils.SetSetting(syncModeSetting, "git")
+	}
+	if err == nil {
+		err = utils.SetSetting(syncCommitSetting, "")
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving sync settings: " + err.Error()))
+		return
+	}
+
+	fmt.Println(utils.StyleSuccess.Render("✅ Sync set up with " + remote))
+	SyncVault()
+}
+
+// SyncVault merges the local vault with the sync repository and pushes the
+// result.
+func SyncVault() {
+	remote := utils.GetSetting(syncRemoteSetting, "")
+	if remote == "" {
+		fmt.Println(utils.StyleError.Render("❌ Sync is not set up, run: fortpass sync init <git remote>"))
+		return
+	}
+
+	key, err := utils.KeyringSecret(syncKeyName)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading sync key: " + err.Error()))
+		return
+	}
+
+	repo := syncRepo()
+	for attempt := 1; ; attempt++ {
+		var summary syncSummary
+		if utils.GetSetting(syncModeSetting, "git") == syncModeServer {
+			summary, err = syncWithServer(remote, key)
+		} else {
+			summary, err = syncOnce(repo, key)
+		}
+		if errors.Is(err, errSyncPushRejected) && attempt < syncMaxAttempts {
+			fmt.Println(utils.StyleInfo.Render("ℹ️ The remote changed while syncing, trying again"))
+			continue
+		}
+		if err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error syncing vault: " + err.Error()))
+			return
+		}
+
+		if summary.Conflicts > 0 {
+			fmt.Println(utils.StyleInfo.Render(fmt.Sprintf("ℹ️ %d entries were changed on both sides, the newer version was kept and the other password moved to the history", summary.Conflicts)))
+		}
+		if summary.Skipped > 0 {
+			fmt.Println(utils.StyleError.Render(fmt.Sprintf("⚠️  %d entries from the server could not be read and were skipped", summary.Skipped)))
+		}
+		if summary.Added+summary.Updated+summary.Deleted+summary.Conflicts > 0 {
+			audit("sync", 0, "", fmt.Sprintf("with %s: %d added, %d updated, %d deleted, %d conflicts",
+				remote, summary.Added, summary.Updated, summary.Deleted, summary.Conflicts))
+		}
+		fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Sync completed with %s: %d added, %d updated, %d deleted locally, %d entries pushed",
+			remote, summary.Added, summary.Updated, summary.Deleted, summary.Pushed)))
+		return
+	}
+}
+
+// SyncKeyCommand prints the sync key, which other machines need to join the
+// synced vault.
+func SyncKeyCommand() {
+	key, err := utils.KeyringSecret(syncKeyName)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading sync key: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StylePrompt.Render("Use this key to sync another machine: fortpass sync init <git remote> --ask-key"))
+	fmt.Println(utils.StylePassword.Render(hex.EncodeToString(key)))
+}
+
+func syncOnce(repo utils.GitRepo, key []byte) (syncSummary, error) {
+	var summary syncSummary
+
+	if _, err := repo.Git("fetch", "-q", "origin"); err != nil {
+		return summary, err
+	}
+	remoteHead := repo.RevParse("refs/remotes/origin/" + syncBranch)
+	base := ""
+	if last := utils.GetSetting(syncCommitSetting, ""); last != "" {
+		base = repo.RevParse(last)
+	}
+
+	if err := checkSyncKey(repo, remoteHead, key); err != nil {
+		return summary, err
+	}
+	baseEntries, err := readSyncEntries(repo, base, key)
+	if err != nil {
+		return summary, err
+	}
+	remoteEntries, err := readSyncEntries(repo, remoteHead, key)
+	if err != nil {
+		return summary, err
+	}
+	localEntries, ids, err := readLocalSyncEntries()
+	if err != nil {
+		return summary, err
+	}
+
+	merged, conflicts := mergeSyncEntries(baseEntries, localEntries, remoteEntries)
+	summary.Conflicts = conflicts
+
+	if !sameSyncEntries(localEntries, merged) {
+		if !autoBackup("sync") {
+			return summary, errors.New("no backup of the vault could be taken")
+		}
+		err = applySyncEntries(localEntries, ids, merged, &summary)
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	if remoteHead != "" {
+		if _, err := repo.Git("checkout", "-q", "-f", "-B", syncBranch, remoteHead); err != nil {
+			return summary, err
+		}
+	}
+	pushed, err := writeSyncEntries(repo.Dir, merged, key)
+	if err != nil {
+		return summary, err
+	}
+	summary.Pushed = pushed
+
+	if _, err := repo.Git("add", "-A"); err != nil {
+		return summary, err
+	}
+	status, err := repo.Git("status", "--porcelain")
+	if err != nil {
+		return summary, err
+	}
+	if status != "" {
+		if err := commitSync(repo, pushed); err != nil {
+			return summary, err
+		}
+	}
+
+	head := repo.RevParse("HEAD")
+	if head != "" && head != remoteHead {
+		if _, err := repo.Git("push", "-q", "origin", "HEAD:refs/heads/"+syncBranch); err != nil {
+			if strings.Contains(err.Error(), "rejected") {
+				return summary, errSyncPushRejected
+			}
+			return summary, err
+		}
+	}
+
+	return summary, utils.SetSetting(syncCommitSetting, head)
+}
+
+// checkSyncKey makes sure key can decrypt the repository before anything is
+// merged, so a wrong key is reported instead of treating every entry as
+// unreadable.
+func checkSyncKey(repo utils.GitRepo, commit string, key []byte) error {
+	files, err := repo.ReadTree(commit, syncKeyCheckFile)
+	if err != nil {
+		return err
+	}
+	data, ok := files[syncKeyCheckFile]
+	if !ok {
+		return nil
+	}
+	plaintext, err := utils.DecryptWithKey(key, data)
+	if err != nil || string(plaintext) != syncKeyCheckText {
+		return errors.New("the sync key does not match the repository, set it with: fortpass sync init <git remote> --ask-key")
+	}
+	return nil
+}
+
+func readSyncEntries(repo utils.GitRepo, commit string, key []byte) (map[string]syncEntry, error) {
+	files, err := repo.ReadTree(commit, syncEntriesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]syncEntry, len(files))
+	for name, data := range files {
+		entry, err := decryptSyncEntry(data, key)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s/%s: %w", syncEntriesDir, name, err)
+		}
+		entries[entry.UUID] = entry
+	}
+	return entries, nil
+}
+
+func decryptSyncEntry(data, key []byte) (syncEntry, error) {
+	var entry syncEntry
+	plaintext, err := utils.DecryptWithKey(key, data)
+	if err != nil {
+		return entry, errors.New("cannot decrypt entry")
+	}
+	err = json.Unmarshal(plaintext, &entry)
+	return entry, err
+}
+
+// readLocalSyncEntries returns the entries of the vault keyed by uuid and
+// their row ids.
+func readLocalSyncEntries() (map[string]syncEntry, map[string]int64, error) {
+	rows, err := utils.DB.Query("SELECT id, uuid, source, username, password, url, folder, tags, notes, rotate_days, generator_policy, created_at, updated_at FROM passwords WHERE deleted_at IS NULL")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	entries := make(map[string]syncEntry)
+	ids := make(map[string]int64)
+	for rows.Next() {
+		var c utils.Credential
+		var uuid, tags, policy string
+		var rotateDays int
+		err := rows.Scan(&c.ID, &uuid, &c.Source, &c.Username, &c.Password, &c.URL, &c.Folder, &tags, &c.Notes, &rotateDays, &policy, &c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries[uuid] = syncEntry{
+			UUID:       uuid,
+			Source:     c.Source,
+			Username:   c.Username,
+			URL:        c.URL,
+			Folder:     c.Folder,
+			Tags:       utils.ParseTags(tags),
+			Notes:      c.Notes,
+			RotateDays: rotateDays,
+			Policy:     policy,
+			Password:   c.Password,
+			CreatedAt:  utils.FormatDBTime(c.CreatedAt),
+			UpdatedAt:  utils.FormatDBTime(c.UpdatedAt),
+		}
+		ids[uuid] = c.ID
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	rows.Close()
+
+	for uuid, id := range ids {
+		history, err := readSyncHistory(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		entry := entries[uuid]
+		entry.History = history
+		entries[uuid] = entry
+	}
+	return entries, ids, nil
+}
+
+func readSyncHistory(id int64) ([]syncHistory, error) {
+	rows, err := utils.DB.Query("SELECT password, created_at, archived_at FROM password_history WHERE password_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []syncHistory
+	for rows.Next() {
+		var h syncHistory
+		var c utils.Credential
+		if err := rows.Scan(&h.Password, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		h.CreatedAt = utils.FormatDBTime(c.CreatedAt)
+		h.ArchivedAt = utils.FormatDBTime(c.UpdatedAt)
+		history = append(history, h)
+	}
+	return mergeSyncHistory(history), rows.Err()
+}
+
+// mergeSyncEntries performs a three-way merge of the entries. Entries
+// changed on one side only take that side's version; entries changed on both
+// sides keep the newer version and move the other password to the history.
+// An entry deleted on one side but changed on the other is kept.
+func mergeSyncEntries(base, local, remote map[string]syncEntry) (map[string]syncEntry, int) {
+	uuids := make(map[string]bool)
+	for _, entries := range []map[string]syncEntry{base, local, remote} {
+		for uuid := range entries {
+			uuids[uuid] = true
+		}
+	}
+
+	merged := make(map[string]syncEntry)
+	conflicts := 0
+	for uuid := range uuids {
+		b, inBase := base[uuid]
+		l, inLocal := local[uuid]
+		r, inRemote := remote[uuid]
+
+		switch {
+		case inLocal == inRemote && (!inLocal || sameSyncEntry(l, r)):
+			if inLocal {
+				merged[uuid] = l
+			}
+		case inLocal == inBase && (!inLocal || sameSyncEntry(l, b)):
+			if inRemote {
+				merged[uuid] = r
+			}
+		case inRemote == inBase && (!inRemote || sameSyncEntry(r, b)):
+			if inLocal {
+				merged[uuid] = l
+			}
+		case !inLocal:
+			merged[uuid] = r
+		case !inRemote:
+			merged[uuid] = l
+		default:
+			entry := mergeSyncConflict(l, r)
+			if inBase {
+				entry = mergeSyncFields(entry, b, l, r)
+			}
+			merged[uuid] = entry
+			conflicts++
+		}
+	}
+
+	return merged, conflicts + mergeDuplicateSyncEntries(merged)
+}
+
+// mergeSyncFields takes over the fields besides the password that only one
+// side changed since base. Moving or tagging an entry does not change its
+// updated_at, so the newer version alone would drop such changes.
+func mergeSyncFields(merged, base, local, remote syncEntry) syncEntry {
+	mergeSyncField(&merged.Folder, base.Folder, local.Folder, remote.Folder)
+	mergeSyncField(&merged.Notes, base.Notes, local.Notes, remote.Notes)
+	mergeSyncField(&merged.RotateDays, base.RotateDays, local.RotateDays, remote.RotateDays)
+	mergeSyncField(&merged.Policy, base.Policy, local.Policy, remote.Policy)
+	tags := utils.FormatTags(merged.Tags)
+	mergeSyncField(&tags, utils.FormatTags(base.Tags), utils.FormatTags(local.Tags), utils.FormatTags(remote.Tags))
+	merged.Tags = utils.ParseTags(tags)
+	return merged
+}
+
+func mergeSyncField[T comparable](merged *T, base, local, remote T) {
+	switch {
+	case local == remote:
+	case local == base:
+		*merged = remote
+	case remote == base:
+		*merged = local
+	}
+}
+
+// mergeSyncConflict keeps the newer of two versions of an entry and adds the
+// password of the other one to the history.
+func mergeSyncConflict(a, b syncEntry) syncEntry {
+	winner, loser := a, b
+	if b.UpdatedAt > a.UpdatedAt || (b.UpdatedAt == a.UpdatedAt && b.Password > a.Password) {
+		winner, loser = b, a
+	}
+
+	history := append(append([]syncHistory{}, a.History...), b.History...)
+	if loser.Password != winner.Password {
+		history = append(history, syncHistory{Password: loser.Password, CreatedAt: loser.UpdatedAt, ArchivedAt: winner.UpdatedAt})
+	}
+	winner.History = mergeSyncHistory(history)
+	if loser.CreatedAt < winner.CreatedAt {
+		winner.CreatedAt = loser.CreatedAt
+	}
+	return winner
+}
+
+// mergeDuplicateSyncEntries merges entries with different uuids that share
+// source, username and URL, e.g. when the same account was added on two
+// machines. It returns the number of entries merged away.
+func mergeDuplicateSyncEntries(entries map[string]syncEntry) int {
+	byKey := make(map[string][]syncEntry)
+	for _, entry := range entries {
+		key := credentialKey(entry.Source, entry.Username, entry.URL)
+		byKey[key] = append(byKey[key], entry)
+	}
+
+	merged := 0
+	for _, duplicates := range byKey {
+		if len(duplicates) < 2 {
+			continue
+		}
+		sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].UUID < duplicates[j].UUID })
+
+		winner := duplicates[0]
+		for _, other := range duplicates[1:] {
+			result := mergeSyncConflict(winner, other)
+			// The lowest uuid survives so every machine merges the same way.
+			result.UUID = winner.UUID
+			delete(entries, other.UUID)
+			winner = result
+			merged++
+		}
+		entries[winner.UUID] = winner
+	}
+	return merged
+}
+
+// mergeSyncHistory removes duplicate history entries and sorts them.
+func mergeSyncHistory(history []syncHistory) []syncHistory {
+	seen := make(map[string]bool)
+	var result []syncHistory
+	for _, h := range history {
+		key := h.Password + "\x00" + h.CreatedAt
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, h)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt != result[j].CreatedAt {
+			return result[i].CreatedAt < result[j].CreatedAt
+		}
+		return result[i].Password < result[j].Password
+	})
+	return result
+}
+
+func sameSyncEntry(a, b syncEntry) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return bytes.Equal(x, y)
+}
+
+func sameSyncEntries(a, b map[string]syncEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for uuid, entry := range a {
+		other, ok := b[uuid]
+		if !ok || !sameSyncEntry(entry, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// applySyncEntries changes the vault so it contains exactly the merged
+// entries.
+func applySyncEntries(local map[string]syncEntry, ids map[string]int64, merged map[string]syncEntry, summary *syncSummary) error {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for uuid := range local {
+		if _, ok := merged[uuid]; ok {
+			continue
+		}
+		if err := utils.TrashEntry(tx, ids[uuid]); err != nil {
+			return err
+		}
+		summary.Deleted++
+	}
+
+	// Renamed entries may take each other's names, e.g. two entries whose
+	// usernames were swapped, so they are moved to a name of their own first
+	// and none of the updates below collides with UNIQUE(source, username,
+	// url) whatever order they run in.
+	for uuid, entry := range merged {
+		current, exists := local[uuid]
+		if !exists || credentialKey(current.Source, current.Username, current.URL) == credentialKey(entry.Source, entry.Username, entry.URL) {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE passwords SET source = ? WHERE id = ?", "\x00sync-rename "+uuid, ids[uuid]); err != nil {
+			return err
+		}
+	}
+
+	for uuid, entry := range merged {
+		current, exists := local[uuid]
+		if exists && sameSyncEntry(current, entry) {
+			continue
+		}
+
+		var currentEntry *syncEntry
+		if exists {
+			currentEntry = &current
+		}
+		_, err = storeSyncEntry(tx, ids[uuid], currentEntry, entry)
+		if err != nil {
+			return err
+		}
+		if exists {
+			summary.Updated++
+		} else {
+			summary.Added++
+		}
+	}
+
+	return tx.Commit()
+}
+
+// storeSyncEntry writes entry into the vault, updating the row id when
+// current is the stored version of the entry and inserting it otherwise.
+// History entries missing locally are added. It returns the row id.
+func storeSyncEntry(tx *sql.Tx, id int64, current *syncEntry, entry syncEntry) (int64, error) {
+	known := make(map[string]bool)
+	if current != nil {
+		// A deleted entry in the trash may still hold the name the entry is
+		// renamed to.
+		if credentialKey(current.Source, current.Username, current.URL) != credentialKey(entry.Source, entry.Username, entry.URL) {
+			if err := utils.PurgeTrashedAccount(tx, entry.Source, entry.Username, entry.URL); err != nil {
+				return 0, err
+			}
+		}
+		_, err := tx.Exec("UPDATE passwords SET uuid = ?, source = ?, username = ?, password = ?, url = ?, folder = ?, tags = ?, notes = ?, rotate_days = ?, generator_policy = ?, created_at = ?, updated_at = ? WHERE id = ?",
+			entry.UUID, entry.Source, entry.Username, entry.Password, entry.URL, entry.Folder, utils.FormatTags(entry.Tags), entry.Notes, entry.RotateDays, entry.Policy, entry.CreatedAt, entry.UpdatedAt, id)
+		if err != nil {
+			return 0, err
+		}
+		for _, h := range current.History {
+			known[h.Password+"\x00"+h.CreatedAt] = true
+		}
+	} else {
+		// An entry deleted here but changed elsewhere comes back from the
+		// trash instead of being stored twice.
+		trashed, err := utils.ReviveTrashed(tx, entry.UUID, entry.Source, entry.Username, entry.URL)
+		if err != nil {
+			return 0, err
+		}
+		if trashed != 0 {
+			id = trashed
+			_, err = tx.Exec("UPDATE passwords SET uuid = ?, source = ?, username = ?, password = ?, url = ?, folder = ?, tags = ?, notes = ?, rotate_days = ?, generator_policy = ?, created_at = ?, updated_at = ? WHERE id = ?",
+				entry.UUID, entry.Source, entry.Username, entry.Password, entry.URL, entry.Folder, utils.FormatTags(entry.Tags), entry.Notes, entry.RotateDays, entry.Policy, entry.CreatedAt, entry.UpdatedAt, id)
+			if err != nil {
+				return 0, err
+			}
+		} else {
+			result, err := tx.Exec("INSERT INTO passwords (uuid, source, username, password, url, folder, tags, notes, rotate_days, generator_policy, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				entry.UUID, entry.Source, entry.Username, entry.Password, entry.URL, entry.Folder, utils.FormatTags(entry.Tags), entry.Notes, entry.RotateDays, entry.Policy, entry.CreatedAt, entry.UpdatedAt)
+			if err != nil {
+				return 0, err
+			}
+			id, err = result.LastInsertId()
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	for _, h := range entry.History {
+		if known[h.Password+"\x00"+h.CreatedAt] {
+			continue
+		}
+		_, err := tx.Exec("INSERT INTO password_history (password_id, password, created_at, archived_at) VALUES (?, ?, ?, ?)",
+			id, h.Password, h.CreatedAt, h.ArchivedAt)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// writeSyncEntries writes the merged entries into the work tree of the sync
+// repository. Files whose content did not change are left alone, since every
+// encryption produces different bytes. It returns the number of entry files
+// written or removed.
+func writeSyncEntries(dir string, merged map[string]syncEntry, key []byte) (int, error) {
+	entriesDir := filepath.Join(dir, syncEntriesDir)
+	if err := os.MkdirAll(entriesDir, 0700); err != nil {
+		return 0, err
+	}
+
+	keyCheck := filepath.Join(dir, syncKeyCheckFile)
+	if _, err := os.Stat(keyCheck); os.IsNotExist(err) {
+		data, err := utils.EncryptWithKey(key, []byte(syncKeyCheckText))
+		if err != nil {
+			return 0, err
+		}
+		if err := os.WriteFile(keyCheck, data, 0600); err != nil {
+			return 0, err
+		}
+	}
+
+	changed := 0
+	files, err := os.ReadDir(entriesDir)
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		if _, ok := merged[file.Name()]; !ok {
+			if err := os.Remove(filepath.Join(entriesDir, file.Name())); err != nil {
+				return 0, err
+			}
+			changed++
+		}
+	}
+
+	for uuid, entry := range merged {
+		path := filepath.Join(entriesDir, uuid)
+		if data, err := os.ReadFile(path); err == nil {
+			if current, err := decryptSyncEntry(data, key); err == nil && sameSyncEntry(current, entry) {
+				continue
+			}
+		}
+
+		plaintext, err := json.Marshal(entry)
+		if err != nil {
+			return 0, err
+		}
+		data, err := utils.EncryptWithKey(key, plaintext)
+		if err != nil {
+			return 0, err
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return 0, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+func commitSync(repo utils.GitRepo, changed int) error {
+	host, _ := os.Hostname()
+	args := []string{"commit", "-q", "-m", fmt.Sprintf("Sync %d entries from %s", changed, host)}
+	if email, _ := repo.Git("config", "user.email"); email == "" {
+		args = append([]string{"-c", "user.name=fortpass", "-c", "user.email=fortpass@" + host}, args...)
+	}
+	_, err := repo.Git(args...)
+	return err
+}
@@ -0,0 +1,186 @@
+package functions
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+	"github.com/zalando/go-keyring"
+)
+
+// syncMachine is a vault with its own clone of the sync repository.
+type syncMachine struct {
+	db     *sql.DB
+	dbPath string
+}
+
+// newTestVault opens an empty vault and makes it the one functions work on.
+func newTestVault(t *testing.T) *syncMachine {
+	t.Helper()
+	m := &syncMachine{dbPath: filepath.Join(t.TempDir(), "passwords.db")}
+	var err error
+	m.db, err = sql.Open("sqlite3", m.dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.db.Close() })
+	m.db.SetMaxOpenConns(1)
+
+	m.use()
+	if err := utils.MigrateDatabase(m.db); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func newSyncMachine(t *testing.T, remote string) *syncMachine {
+	t.Helper()
+	m := newTestVault(t)
+	repo := syncRepo()
+	if err := os.MkdirAll(repo.Dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"symbolic-ref", "HEAD", "refs/heads/" + syncBranch},
+		{"remote", "add", "origin", remote},
+	} {
+		if _, err := repo.Git(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+// use makes the machine's vault the one functions work on.
+func (m *syncMachine) use() {
+	utils.DB = m.db
+	utils.DBPath = m.dbPath
+}
+
+func (m *syncMachine) sync(t *testing.T, key []byte) syncSummary {
+	t.Helper()
+	m.use()
+	summary, err := syncOnce(syncRepo(), key)
+	if err != nil {
+		t.Fatalf("sync of %s: %v", m.dbPath, err)
+	}
+	return summary
+}
+
+func (m *syncMachine) exec(t *testing.T, query string, args ...any) {
+	t.Helper()
+	if _, err := m.db.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+func (m *syncMachine) entries(t *testing.T) map[string]syncEntry {
+	t.Helper()
+	m.use()
+	entries, _, err := readLocalSyncEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestGitSyncThreeWayMerge(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	keyring.MockInit()
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	remote := filepath.Join(dir, "remote.git")
+	if _, err := (utils.GitRepo{Dir: dir}).Git("init", "-q", "--bare", remote); err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{9}, 32)
+
+	laptop := newSyncMachine(t, remote)
+	for _, e := range [][]string{
+		{"edited", "github.com", "alice", "old"},
+		{"deleted", "mail", "bob", "mailpw"},
+		{"renamed", "aws", "root", "awspw"},
+		{"swap-a", "x", "carol", "xpw"},
+		{"swap-b", "y", "carol", "ypw"},
+		{"freed", "z", "erin", "zpw"},
+		{"takes-freed", "w", "erin", "wpw"},
+	} {
+		laptop.exec(t, "INSERT INTO passwords (uuid, source, username, password, url, created_at, updated_at) VALUES (?, ?, ?, ?, '', '2026-01-01 10:00:00', '2026-01-01 10:00:00')",
+			e[0], e[1], e[2], e[3])
+	}
+	laptop.sync(t, key)
+
+	desktop := newSyncMachine(t, remote)
+	if summary := desktop.sync(t, key); summary.Added != 7 {
+		t.Fatalf("first sync of the desktop added %d entries, want 7", summary.Added)
+	}
+
+	// Both machines change the vault before syncing again.
+	laptop.exec(t, "UPDATE passwords SET password = 'laptop', updated_at = '2026-01-02 10:00:00' WHERE uuid = 'edited'")
+	laptop.exec(t, "DELETE FROM passwords WHERE uuid IN ('deleted', 'freed')")
+	laptop.exec(t, "UPDATE passwords SET source = 'z', updated_at = '2026-01-02 10:00:00' WHERE uuid = 'takes-freed'")
+
+	desktop.exec(t, "UPDATE passwords SET password = 'desktop', updated_at = '2026-01-02 09:00:00' WHERE uuid = 'edited'")
+	desktop.exec(t, "UPDATE passwords SET folder = 'work' WHERE uuid = 'edited'")
+	desktop.exec(t, "UPDATE passwords SET username = 'admin', updated_at = '2026-01-02 09:00:00' WHERE uuid = 'renamed'")
+	desktop.exec(t, "UPDATE passwords SET source = 'tmp' WHERE uuid = 'swap-a'")
+	desktop.exec(t, "UPDATE passwords SET source = 'x', updated_at = '2026-01-02 09:00:00' WHERE uuid = 'swap-b'")
+	desktop.exec(t, "UPDATE passwords SET source = 'y', updated_at = '2026-01-02 09:00:00' WHERE uuid = 'swap-a'")
+
+	laptop.sync(t, key)
+	if summary := desktop.sync(t, key); summary.Conflicts != 1 {
+		t.Errorf("desktop sync found %d conflicts, want 1", summary.Conflicts)
+	}
+	laptop.sync(t, key)
+
+	got := laptop.entries(t)
+	if !sameSyncEntries(got, desktop.entries(t)) {
+		t.Fatalf("the vaults differ after syncing:\nlaptop  %+v\ndesktop %+v", got, desktop.entries(t))
+	}
+
+	want := map[string][3]string{
+		"edited":      {"github.com", "alice", "laptop"},
+		"renamed":     {"aws", "admin", "awspw"},
+		"swap-a":      {"y", "carol", "xpw"},
+		"swap-b":      {"x", "carol", "ypw"},
+		"takes-freed": {"z", "erin", "wpw"},
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for uuid, w := range want {
+		e, ok := got[uuid]
+		if !ok {
+			t.Errorf("%s is missing", uuid)
+			continue
+		}
+		if e.Source != w[0] || e.Username != w[1] || e.Password != w[2] {
+			t.Errorf("%s is %s/%s with %q, want %s/%s with %q", uuid, e.Source, e.Username, e.Password, w[0], w[1], w[2])
+		}
+	}
+	if folder := got["edited"].Folder; folder != "work" {
+		t.Errorf("edited is in folder %q, want the move of the older version kept", folder)
+	}
+	history := got["edited"].History
+	if len(history) != 1 || history[0].Password != "desktop" {
+		t.Errorf("the losing password of the conflict is not in the history: %+v", history)
+	}
+
+	// Entries deleted on the laptop are in the desktop's trash, except the
+	// one whose name was taken over by a renamed entry.
+	desktop.use()
+	trash, err := utils.ListTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].UUID != "deleted" {
+		t.Errorf("got trash %+v, want only the deleted entry", trash)
+	}
+}
@@ -0,0 +1,103 @@
+package functions
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+// TagPassword adds tags to an entry given as source/username, or removes
+// them with remove. Without tags it shows the tags of the entry.
+func TagPassword(name string, tags []string, remove bool) {
+	source, username, ok := strings.Cut(name, "/")
+	if !ok {
+		fmt.Println(utils.StyleError.Render("❌ Invalid password name format. Use 'source/username'."))
+		return
+	}
+
+	var id int64
+	var stored string
+	err := utils.DB.QueryRow("SELECT id, tags FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL", source, username).Scan(&id, &stored)
+	if err == sql.ErrNoRows {
+		fmt.Println(utils.StyleError.Render("❌ No password found for " + name))
+		return
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error fetching password: " + err.Error()))
+		return
+	}
+
+	current := utils.ParseTags(stored)
+	changes := utils.ParseTags(strings.Join(tags, ","))
+	if len(changes) == 0 {
+		if len(current) == 0 {
+			fmt.Println(utils.StylePrompt.Render(name + " has no tags."))
+		} else {
+			fmt.Println(utils.StyleInfo.Render("ℹ️ " + name + ": #" + strings.Join(current, " #")))
+		}
+		return
+	}
+
+	updated := append(append([]string{}, current...), changes...)
+	details := "added " + strings.Join(changes, ", ")
+	if remove {
+		updated = nil
+		for _, tag := range current {
+			if !utils.HasTag(changes, tag) {
+				updated = append(updated, tag)
+			}
+		}
+		details = "removed " + strings.Join(changes, ", ")
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error starting transaction: " + err.Error()))
+		return
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("UPDATE passwords SET tags = ? WHERE id = ?", utils.FormatTags(updated), id)
+	if err == nil {
+		err = utils.Audit(tx, "tag", id, name, details)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving tags: " + err.Error()))
+		return
+	}
+
+	if tags := utils.ParseTags(utils.FormatTags(updated)); len(tags) > 0 {
+		fmt.Println(utils.StyleSuccess.Render("✅ Tags of " + name + ": #" + strings.Join(tags, " #")))
+	} else {
+		fmt.Println(utils.StyleSuccess.Render("✅ " + name + " has no tags anymore"))
+	}
+}
+
+// ListTags shows every tag with the number of entries that have it.
+func ListTags() {
+	counts := make(map[string]int)
+	for _, e := range utils.GetPasswordEntries() {
+		for _, tag := range e.Tags {
+			counts[tag]++
+		}
+	}
+	if len(counts) == 0 {
+		fmt.Println(utils.StylePrompt.Render("No passwords are tagged yet."))
+		return
+	}
+
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	fmt.Println(utils.StyleHeading.Render("Tags:"))
+	for _, tag := range tags {
+		fmt.Printf("%s #%s  (%d)\n", utils.StylePrompt.Render("•"), tag, counts[tag])
+	}
+}
@@ -0,0 +1,896 @@
+package functions
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+const (
+	teamPathSetting    = "team.path."
+	teamOwnersSetting  = "team.owners."
+	teamDefaultSetting = "team.default"
+)
+
+// TeamOptions selects the team vault a team command works on. Without Team
+// the default team is used.
+type TeamOptions struct {
+	Team       string
+	MemberName string
+	Role       string
+	Folder     string
+	Readers    []string
+}
+
+// selectedTeam returns the name and file of the selected team.
+func selectedTeam(opts TeamOptions) (string, string, error) {
+	name := opts.Team
+	if name == "" {
+		name = utils.GetSetting(teamDefaultSetting, "")
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("no team selected, create one with: fortpass team init <name> or pass --team")
+	}
+	path := utils.GetSetting(teamPathSetting+name, "")
+	if path == "" {
+		return "", "", fmt.Errorf("unknown team %q, join it with: fortpass team join <file>", name)
+	}
+	return name, path, nil
+}
+
+// openTeam loads the selected team vault together with this user's identity.
+// The vault must be signed by an owner this user already trusts; the owners
+// of the verified vault are trusted from then on.
+func openTeam(opts TeamOptions) (*utils.TeamVault, string, *ecdh.PrivateKey, error) {
+	name, path, err := selectedTeam(opts)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	vault, err := utils.LoadTeamVault(path)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if err := vault.Verify(strings.Fields(utils.GetSetting(teamOwnersSetting+name, ""))); err != nil {
+		return nil, "", nil, err
+	}
+	if err := trustOwners(name, vault); err != nil {
+		return nil, "", nil, err
+	}
+	identity, err := utils.IdentityKey()
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("reading identity key: %w", err)
+	}
+	return vault, path, identity, nil
+}
+
+// trustOwners remembers the signing keys of the owners of a verified vault.
+func trustOwners(name string, vault *utils.TeamVault) error {
+	owners := strings.Join(vault.OwnerSigningKeys(), " ")
+	if owners == utils.GetSetting(teamOwnersSetting+name, "") {
+		return nil
+	}
+	return utils.SetSetting(teamOwnersSetting+name, owners)
+}
+
+// memberKey returns the key this user sends to team owners, which contains
+// the public key and the signing key.
+func memberKey(identity *ecdh.PrivateKey) (string, error) {
+	signing, err := utils.SigningKey()
+	if err != nil {
+		return "", fmt.Errorf("reading signing key: %w", err)
+	}
+	return utils.EncodeMemberKey(identity.PublicKey(), signing.Public().(ed25519.PublicKey)), nil
+}
+
+// teamSelf returns this user's membership of vault.
+func teamSelf(vault *utils.TeamVault, identity *ecdh.PrivateKey) (*utils.TeamMember, error) {
+	self, ok := vault.Member(utils.EncodePublicKey(identity.PublicKey()))
+	if !ok {
+		return nil, fmt.Errorf("you are not a member of team %s", vault.Name)
+	}
+	return self, nil
+}
+
+// openTeamAs opens the selected team and checks that this user's role allows
+// the operation: owners manage the team, editors change entries.
+func openTeamAs(opts TeamOptions, role string) (*utils.TeamVault, string, *ecdh.PrivateKey, error) {
+	vault, path, identity, err := openTeam(opts)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	self, err := teamSelf(vault, identity)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	switch {
+	case role == utils.RoleOwner && self.Role != utils.RoleOwner:
+		return nil, "", nil, fmt.Errorf("only owners of team %s can do this, you are %s", vault.Name, self.Role)
+	case role == utils.RoleEditor && !self.CanWrite():
+		return nil, "", nil, fmt.Errorf("viewers cannot change entries of team %s", vault.Name)
+	}
+	return vault, path, identity, nil
+}
+
+// reshareEntries updates the wrapped keys of every entry after the members
+// or access rules changed, signs the entries and the members again with the
+// owner's key and returns the number of entries whose key was rotated.
+func reshareEntries(vault *utils.TeamVault, identity *ecdh.PrivateKey) (int, error) {
+	key, err := utils.SigningKey()
+	if err != nil {
+		return 0, fmt.Errorf("reading signing key: %w", err)
+	}
+	rotated := 0
+	for i := range vault.Entries {
+		payload := vault.Entries[i].Payload
+		if err := vault.Reshare(&vault.Entries[i], identity); err != nil {
+			return rotated, fmt.Errorf("entry %s: %w", vault.Entries[i].UUID, err)
+		}
+		if !bytes.Equal(payload, vault.Entries[i].Payload) {
+			rotated++
+		}
+		// Entries signed by a member who lost write access stay valid
+		if err := vault.SignEntry(&vault.Entries[i], key); err != nil {
+			return rotated, err
+		}
+	}
+	return rotated, vault.SignMembers(key)
+}
+
+// sealTeamEntry encrypts secret into entry and signs it with this user's key.
+func sealTeamEntry(vault *utils.TeamVault, entry *utils.TeamEntry, secret utils.TeamSecret) error {
+	key, err := utils.SigningKey()
+	if err != nil {
+		return fmt.Errorf("reading signing key: %w", err)
+	}
+	if err := vault.Seal(entry, secret); err != nil {
+		return err
+	}
+	return vault.SignEntry(entry, key)
+}
+
+func validRole(role string) bool {
+	for _, r := range utils.TeamRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func registerTeam(name, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if err := utils.SetSetting(teamPathSetting+name, abs); err != nil {
+		return err
+	}
+	if utils.GetSetting(teamDefaultSetting, "") == "" {
+		return utils.SetSetting(teamDefaultSetting, name)
+	}
+	return nil
+}
+
+func defaultMemberName() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// TeamPublicKey prints this user's public key, which team owners need to add
+// the user to a team.
+func TeamPublicKey() {
+	identity, err := utils.IdentityKey()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading identity key: " + err.Error()))
+		return
+	}
+	key, err := memberKey(identity)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StylePrompt.Render("Send this public key to a team owner to be added to a team:"))
+	fmt.Println(key)
+}
+
+// TeamInit creates a new team vault file with this user as its first member.
+func TeamInit(name, path string, opts TeamOptions) {
+	if path == "" {
+		path = filepath.Join(filepath.Dir(utils.DBPath), "teams", name+".json")
+	}
+	if _, err := os.Stat(path); err == nil {
+		fmt.Println(utils.StyleError.Render("❌ A file already exists at " + path + ", use: fortpass team join " + path))
+		return
+	}
+
+	identity, err := utils.IdentityKey()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading identity key: " + err.Error()))
+		return
+	}
+	member := opts.MemberName
+	if member == "" {
+		member = defaultMemberName()
+	}
+
+	signing, err := utils.SigningKey()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading signing key: " + err.Error()))
+		return
+	}
+
+	vault := utils.NewTeamVault(name, utils.TeamMember{
+		Name:       member,
+		PublicKey:  utils.EncodePublicKey(identity.PublicKey()),
+		SigningKey: utils.EncodeSigningKey(signing.Public().(ed25519.PublicKey)),
+	})
+	err = vault.SignMembers(signing)
+	if err == nil {
+		err = vault.Save(path)
+	}
+	if err == nil {
+		err = registerTeam(name, path)
+	}
+	if err == nil {
+		err = trustOwners(name, vault)
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error creating team vault: " + err.Error()))
+		return
+	}
+
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Team %s created at %s", name, path)))
+	fmt.Println(utils.StyleInfo.Render("   Share the file with your team, e.g. in a git repository, and add members with: fortpass team add-member <public key>"))
+}
+
+// TeamJoin registers an existing team vault file and trusts its current
+// owners, which are printed to be checked with the team. Joining again
+// trusts the owners anew after they changed.
+func TeamJoin(path string) {
+	vault, err := utils.LoadTeamVault(path)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	if err := vault.Verify(nil); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	err = registerTeam(vault.Name, path)
+	if err == nil {
+		err = utils.SetSetting(teamOwnersSetting+vault.Name, strings.Join(vault.OwnerSigningKeys(), " "))
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving team: " + err.Error()))
+		return
+	}
+
+	fmt.Println(utils.StyleSuccess.Render("✅ Joined team " + vault.Name))
+	for _, m := range vault.Members {
+		if m.Role == utils.RoleOwner && m.SigningKey != "" {
+			fmt.Println(utils.StyleInfo.Render(fmt.Sprintf("ℹ️ Trusting owner %s with signing key %s, check it with them", m.Name, m.SigningKey)))
+		}
+	}
+	identity, err := utils.IdentityKey()
+	if err == nil {
+		if _, ok := vault.Member(utils.EncodePublicKey(identity.PublicKey())); !ok {
+			if key, err := memberKey(identity); err == nil {
+				fmt.Println(utils.StyleInfo.Render("ℹ️ You are not a member of this team yet, ask an owner to run: fortpass team add-member " + key))
+			}
+		}
+	}
+}
+
+// TeamSign signs a team vault created before team vaults were signed. The
+// owner running it vouches for the current members, roles and entries, so
+// they should check them with "team members" and "team ls" first.
+func TeamSign(opts TeamOptions) {
+	name, path, err := selectedTeam(opts)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	vault, err := utils.LoadTeamVault(path)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	if len(vault.Signature.Value) > 0 {
+		fmt.Println(utils.StyleError.Render("❌ Team vault " + vault.Name + " is already signed"))
+		return
+	}
+	identity, err := utils.IdentityKey()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading identity key: " + err.Error()))
+		return
+	}
+	signing, err := utils.SigningKey()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading signing key: " + err.Error()))
+		return
+	}
+	self, err := teamSelf(vault, identity)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	if self.Role != utils.RoleOwner {
+		fmt.Println(utils.StyleError.Render("❌ Only owners of team " + vault.Name + " can sign it"))
+		return
+	}
+	self.SigningKey = utils.EncodeSigningKey(signing.Public().(ed25519.PublicKey))
+
+	for i := range vault.Entries {
+		if err := vault.SignEntry(&vault.Entries[i], signing); err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error signing entries: " + err.Error()))
+			return
+		}
+	}
+	err = vault.SignMembers(signing)
+	if err == nil {
+		err = vault.Save(path)
+	}
+	if err == nil {
+		err = trustOwners(name, vault)
+	}
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error signing team vault: " + err.Error()))
+		return
+	}
+
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Members and %d entries of team %s signed", len(vault.Entries), vault.Name)))
+	unsigned := 0
+	for _, m := range vault.Members {
+		if m.SigningKey == "" && m.CanWrite() {
+			unsigned++
+		}
+	}
+	if unsigned > 0 {
+		fmt.Println(utils.StyleInfo.Render(fmt.Sprintf("ℹ️ %d owners and editors have no signing key yet, add their key from fortpass team pubkey with: fortpass team add-member <key>", unsigned)))
+	}
+}
+
+// TeamAddMember adds a member and wraps the key of every entry they may
+// read for them.
+func TeamAddMember(publicKey string, opts TeamOptions) {
+	vault, path, identity, err := openTeamAs(opts, utils.RoleOwner)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	publicKey, signingKey, err := utils.ParseMemberKey(publicKey)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	if other, ok := vault.MemberBySigningKey(signingKey); ok && other.PublicKey != publicKey {
+		fmt.Println(utils.StyleError.Render("❌ This signing key belongs to " + other.Name + " of team " + vault.Name))
+		return
+	}
+	if existing, ok := vault.Member(publicKey); ok {
+		if existing.SigningKey != "" || signingKey == "" {
+			fmt.Println(utils.StyleError.Render("❌ This key is already a member of team " + vault.Name))
+			return
+		}
+		// Members added before team vaults were signed
+		existing.SigningKey = signingKey
+		name := existing.Name
+		if _, err := reshareEntries(vault, identity); err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error signing team vault: " + err.Error()))
+			return
+		}
+		if err := vault.Save(path); err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error saving team vault: " + err.Error()))
+			return
+		}
+		fmt.Println(utils.StyleSuccess.Render("✅ Signing key of " + name + " added to team " + vault.Name))
+		return
+	}
+	name := opts.MemberName
+	if name == "" {
+		name = fmt.Sprintf("member%d", len(vault.Members)+1)
+	}
+	if _, ok := vault.Member(name); ok {
+		fmt.Println(utils.StyleError.Render("❌ Team " + vault.Name + " already has a member named " + name))
+		return
+	}
+	role := opts.Role
+	if role == "" {
+		role = utils.RoleViewer
+	}
+	if !validRole(role) {
+		fmt.Println(utils.StyleError.Render("❌ Invalid role " + role + ", use one of: " + strings.Join(utils.TeamRoles, ", ")))
+		return
+	}
+	if signingKey == "" && role != utils.RoleViewer {
+		fmt.Println(utils.StyleError.Render("❌ This key has no signing key, which " + role + "s need; ask for the key printed by: fortpass team pubkey"))
+		return
+	}
+
+	member := utils.TeamMember{Name: name, PublicKey: publicKey, SigningKey: signingKey, Role: role, AddedAt: utils.FormatDBTime(time.Now())}
+	vault.Members = append(vault.Members, member)
+	if _, err := reshareEntries(vault, identity); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error sharing entries: " + err.Error()))
+		return
+	}
+
+	if err := vault.Save(path); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving team vault: " + err.Error()))
+		return
+	}
+	readable := 0
+	for _, entry := range vault.Entries {
+		if vault.CanRead(member, entry) {
+			readable++
+		}
+	}
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ %s added to team %s as %s with access to %d of %d entries", name, vault.Name, role, readable, len(vault.Entries))))
+}
+
+// TeamRemoveMember removes a member and rotates the key of every entry they
+// could read, so the removed member cannot decrypt later versions of the
+// vault file.
+func TeamRemoveMember(member string, opts TeamOptions) {
+	vault, path, identity, err := openTeamAs(opts, utils.RoleOwner)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	removed, ok := vault.Member(member)
+	if !ok {
+		fmt.Println(utils.StyleError.Render("❌ No member " + member + " in team " + vault.Name))
+		return
+	}
+	if removed.PublicKey == utils.EncodePublicKey(identity.PublicKey()) {
+		fmt.Println(utils.StyleError.Render("❌ You cannot remove yourself from a team"))
+		return
+	}
+	removedName, removedKey := removed.Name, removed.PublicKey
+
+	var members []utils.TeamMember
+	for _, m := range vault.Members {
+		if m.PublicKey != removedKey {
+			members = append(members, m)
+		}
+	}
+	vault.Members = members
+	// An access list without readers would open it to everyone, so one
+	// left empty is limited to the owners instead.
+	var owners []string
+	for _, m := range vault.Members {
+		if m.Role == utils.RoleOwner {
+			owners = append(owners, m.Name)
+		}
+	}
+	for folder, readers := range vault.FolderACLs {
+		if readers = withoutName(readers, removedName); len(readers) == 0 {
+			readers = owners
+		}
+		vault.FolderACLs[folder] = readers
+	}
+	for i := range vault.Entries {
+		readers := vault.Entries[i].Readers
+		if len(readers) == 0 {
+			continue
+		}
+		if readers = withoutName(readers, removedName); len(readers) == 0 {
+			readers = owners
+		}
+		vault.Entries[i].Readers = readers
+	}
+
+	rotated, err := reshareEntries(vault, identity)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error rotating entry keys: " + err.Error()))
+		return
+	}
+
+	if err := vault.Save(path); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving team vault: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ %s removed from team %s, keys of %d entries rotated", removedName, vault.Name, rotated)))
+	if rotated > 0 {
+		fmt.Println(utils.StyleInfo.Render("ℹ️ " + removedName + " may still know the current passwords, change them on the services themselves"))
+	}
+}
+
+func withoutName(names []string, name string) []string {
+	var result []string
+	for _, n := range names {
+		if n != name {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// TeamSetRole changes the role of a member.
+func TeamSetRole(member, role string, opts TeamOptions) {
+	vault, path, identity, err := openTeamAs(opts, utils.RoleOwner)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	if !validRole(role) {
+		fmt.Println(utils.StyleError.Render("❌ Invalid role " + role + ", use one of: " + strings.Join(utils.TeamRoles, ", ")))
+		return
+	}
+	m, ok := vault.Member(member)
+	if !ok {
+		fmt.Println(utils.StyleError.Render("❌ No member " + member + " in team " + vault.Name))
+		return
+	}
+	if m.Role == utils.RoleOwner && role != utils.RoleOwner {
+		owners := 0
+		for _, other := range vault.Members {
+			if other.Role == utils.RoleOwner {
+				owners++
+			}
+		}
+		if owners == 1 {
+			fmt.Println(utils.StyleError.Render("❌ " + m.Name + " is the last owner of team " + vault.Name))
+			return
+		}
+	}
+	if m.SigningKey == "" && role != utils.RoleViewer {
+		fmt.Println(utils.StyleError.Render("❌ " + m.Name + " has no signing key, which " + role + "s need; add it with: fortpass team add-member <key from their team pubkey>"))
+		return
+	}
+	m.Role = role
+	name := m.Name
+
+	rotated, err := reshareEntries(vault, identity)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error updating entry keys: " + err.Error()))
+		return
+	}
+	if err := vault.Save(path); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving team vault: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ %s is now %s of team %s", name, role, vault.Name)))
+	if rotated > 0 {
+		fmt.Println(utils.StyleInfo.Render(fmt.Sprintf("ℹ️ Keys of %d entries %s can no longer read were rotated", rotated, name)))
+	}
+}
+
+// TeamSetACL limits who can read an entry ("source/username") or all entries
+// of a folder ("folder:name"). Without readers the restriction is lifted.
+// Entry keys are wrapped only for the allowed readers and owners.
+func TeamSetACL(target string, readers []string, opts TeamOptions) {
+	vault, path, identity, err := openTeamAs(opts, utils.RoleOwner)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	for _, r := range readers {
+		if _, ok := vault.Member(r); !ok {
+			fmt.Println(utils.StyleError.Render("❌ No member " + r + " in team " + vault.Name))
+			return
+		}
+	}
+
+	if folder, ok := strings.CutPrefix(target, "folder:"); ok {
+		if vault.FolderACLs == nil {
+			vault.FolderACLs = make(map[string][]string)
+		}
+		if len(readers) == 0 {
+			delete(vault.FolderACLs, folder)
+		} else {
+			vault.FolderACLs[folder] = readers
+		}
+	} else {
+		source, username, ok := strings.Cut(target, "/")
+		if !ok {
+			fmt.Println(utils.StyleError.Render("❌ Invalid target, use 'source/username' or 'folder:name'."))
+			return
+		}
+		index, _ := findTeamEntry(vault, identity, source, username)
+		if index < 0 {
+			fmt.Println(utils.StyleError.Render("❌ No password found for " + target + " in team " + vault.Name))
+			return
+		}
+		vault.Entries[index].Readers = readers
+	}
+
+	rotated, err := reshareEntries(vault, identity)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error updating entry keys: " + err.Error()))
+		return
+	}
+	if err := vault.Save(path); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving team vault: " + err.Error()))
+		return
+	}
+
+	if len(readers) == 0 {
+		fmt.Println(utils.StyleSuccess.Render("✅ " + target + " can be read by every member of team " + vault.Name))
+	} else {
+		fmt.Println(utils.StyleSuccess.Render("✅ " + target + " can be read by owners and " + strings.Join(readers, ", ")))
+	}
+	if rotated > 0 {
+		fmt.Println(utils.StyleInfo.Render(fmt.Sprintf("ℹ️ Keys of %d entries were rotated for members who lost access", rotated)))
+	}
+}
+
+// TeamMembers lists the members of a team.
+func TeamMembers(opts TeamOptions) {
+	vault, _, identity, err := openTeam(opts)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	self := utils.EncodePublicKey(identity.PublicKey())
+	fmt.Println(utils.StyleHeading.Render(fmt.Sprintf("Members of %s:", vault.Name)))
+	for _, m := range vault.Members {
+		you := ""
+		if m.PublicKey == self {
+			you = " (you)"
+		}
+		fmt.Printf("%s %s%s  %s  %s  added %s\n", utils.StylePrompt.Render("•"), m.Name, you, m.Role, m.PublicKey, m.AddedAt)
+	}
+}
+
+// TeamShare copies an entry of the personal vault into a team vault,
+// replacing an earlier shared version of it.
+func TeamShare(name string, opts TeamOptions) {
+	source, username, ok := strings.Cut(name, "/")
+	if !ok {
+		fmt.Println(utils.StyleError.Render("❌ Invalid password name format. Use 'source/username'."))
+		return
+	}
+	vault, path, identity, err := openTeamAs(opts, utils.RoleEditor)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	self, _ := teamSelf(vault, identity)
+	for _, r := range opts.Readers {
+		if _, ok := vault.Member(r); !ok {
+			fmt.Println(utils.StyleError.Render("❌ No member " + r + " in team " + vault.Name))
+			return
+		}
+	}
+
+	var c utils.Credential
+	err = utils.DB.QueryRow("SELECT password, url, created_at, updated_at FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL", source, username).
+		Scan(&c.Password, &c.URL, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			fmt.Println(utils.StyleError.Render("❌ No password found for " + name))
+		} else {
+			fmt.Println(utils.StyleError.Render("❌ Error fetching password: " + err.Error()))
+		}
+		return
+	}
+
+	secret := utils.TeamSecret{
+		Source:    source,
+		Username:  username,
+		URL:       c.URL,
+		Password:  c.Password,
+		CreatedAt: utils.FormatDBTime(c.CreatedAt),
+		UpdatedAt: utils.FormatDBTime(c.UpdatedAt),
+		SharedBy:  self.Name,
+	}
+
+	index, _ := findTeamEntry(vault, identity, source, username)
+	entry := utils.TeamEntry{UUID: utils.NewUUID()}
+	if index >= 0 {
+		entry = vault.Entries[index]
+	}
+	if opts.Folder != "" {
+		entry.Folder = opts.Folder
+	}
+	if len(opts.Readers) > 0 {
+		// Keep access to what you share.
+		entry.Readers = append(withoutName(opts.Readers, self.Name), self.Name)
+	}
+	if err := sealTeamEntry(vault, &entry, secret); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error encrypting entry: " + err.Error()))
+		return
+	}
+	if index >= 0 {
+		vault.Entries[index] = entry
+	} else {
+		vault.Entries = append(vault.Entries, entry)
+	}
+
+	if err := vault.Save(path); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving team vault: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ %s shared with %d of the %d members of team %s", name, len(entry.Keys), len(vault.Members), vault.Name)))
+}
+
+// findTeamEntry returns the index of the entry for source and username, or
+// -1 when the team has none that this user can open.
+func findTeamEntry(vault *utils.TeamVault, identity *ecdh.PrivateKey, source, username string) (int, utils.TeamSecret) {
+	for i, entry := range vault.Entries {
+		secret, _, err := entry.Open(identity)
+		if err != nil {
+			continue
+		}
+		if secret.Source == source && secret.Username == username {
+			return i, secret
+		}
+	}
+	return -1, utils.TeamSecret{}
+}
+
+// TeamList lists the entries of a team vault.
+func TeamList(opts TeamOptions) {
+	vault, _, identity, err := openTeam(opts)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	type listed struct {
+		utils.TeamSecret
+		entry utils.TeamEntry
+	}
+	var secrets []listed
+	hidden := 0
+	for _, entry := range vault.Entries {
+		secret, _, err := entry.Open(identity)
+		if err != nil {
+			hidden++
+			continue
+		}
+		secrets = append(secrets, listed{secret, entry})
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		a := secrets[i].entry.Folder + "/" + secrets[i].Source + "/" + secrets[i].Username
+		b := secrets[j].entry.Folder + "/" + secrets[j].Source + "/" + secrets[j].Username
+		return a < b
+	})
+
+	if len(secrets) == 0 && hidden == 0 {
+		fmt.Println(utils.StylePrompt.Render("Team " + vault.Name + " has no entries yet, share one with: fortpass team share <source/username>"))
+		return
+	}
+	if len(secrets) == 0 {
+		fmt.Println(utils.StylePrompt.Render(fmt.Sprintf("None of the %d entries of team %s are shared with you.", hidden, vault.Name)))
+		return
+	}
+	fmt.Println(utils.StyleHeading.Render(fmt.Sprintf("Entries of %s:", vault.Name)))
+	for _, s := range secrets {
+		folder := ""
+		if s.entry.Folder != "" {
+			folder = "[" + s.entry.Folder + "] "
+		}
+		access := ""
+		if readers := s.entry.Readers; len(readers) > 0 {
+			access = ", readable by " + strings.Join(readers, ", ")
+		} else if readers := vault.FolderACLs[s.entry.Folder]; len(readers) > 0 {
+			access = ", readable by " + strings.Join(readers, ", ")
+		}
+		fmt.Printf("%s %s%s/%s  %s  shared by %s, updated %s%s\n", utils.StylePrompt.Render("•"), folder, s.Source, s.Username, s.URL, s.SharedBy, s.UpdatedAt, access)
+	}
+	if hidden > 0 {
+		fmt.Println(utils.StyleInfo.Render(fmt.Sprintf("ℹ️ %d entries are not shared with you", hidden)))
+	}
+}
+
+// TeamGet copies the password of a team entry to the clipboard.
+func TeamGet(name string, opts TeamOptions) {
+	source, username, ok := strings.Cut(name, "/")
+	if !ok {
+		fmt.Println(utils.StyleError.Render("❌ Invalid password name format. Use 'source/username'."))
+		return
+	}
+	vault, _, identity, err := openTeam(opts)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	index, secret := findTeamEntry(vault, identity, source, username)
+	if index < 0 {
+		fmt.Println(utils.StyleError.Render("❌ No password found for " + name + " in team " + vault.Name))
+		return
+	}
+
+	err = clipboard.WriteAll(secret.Password)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Failed to copy password to clipboard: " + err.Error()))
+		return
+	}
+	audit("get", 0, name, "team "+vault.Name)
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("📋 Password for %s/%s of team %s copied to clipboard.", source, username, vault.Name)))
+}
+
+// TeamUpdate replaces the password of a team entry. The entry gets a new key
+// wrapped for its current readers.
+func TeamUpdate(name string, opts TeamOptions, updateOpts UpdateOptions) {
+	source, username, ok := strings.Cut(name, "/")
+	if !ok {
+		fmt.Println(utils.StyleError.Render("❌ Invalid password name format. Use 'source/username'."))
+		return
+	}
+	vault, path, identity, err := openTeamAs(opts, utils.RoleEditor)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	index, secret := findTeamEntry(vault, identity, source, username)
+	if index < 0 {
+		fmt.Println(utils.StyleError.Render("❌ No password found for " + name + " in team " + vault.Name))
+		return
+	}
+
+	newPassword, err := readNewPassword(updateOpts, func() (string, string, error) {
+		// Team entries only follow the policies of domains.
+		return generatePasswordFor("", "", secret.URL)
+	})
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading input: " + err.Error()))
+		return
+	}
+	if newPassword == "" {
+		fmt.Println(utils.StylePrompt.Render("👋 Keeping the current password."))
+		return
+	}
+	self, _ := teamSelf(vault, identity)
+	secret.Password = newPassword
+	secret.UpdatedAt = utils.FormatDBTime(time.Now())
+	secret.SharedBy = self.Name
+
+	if err := sealTeamEntry(vault, &vault.Entries[index], secret); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error encrypting entry: " + err.Error()))
+		return
+	}
+	if err := vault.Save(path); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving team vault: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Password for %s/%s of team %s updated successfully", source, username, vault.Name)))
+}
+
+// TeamDelete removes an entry from a team vault. Team vaults have no trash,
+// so the entry is gone for every member.
+func TeamDelete(name string, opts TeamOptions, deleteOpts DeleteOptions) {
+	source, username, ok := strings.Cut(name, "/")
+	if !ok {
+		fmt.Println(utils.StyleError.Render("❌ Invalid password name format. Use 'source/username'."))
+		return
+	}
+	vault, path, identity, err := openTeamAs(opts, utils.RoleEditor)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	index, _ := findTeamEntry(vault, identity, source, username)
+	if index < 0 {
+		fmt.Println(utils.StyleError.Render("❌ No password found for " + name + " in team " + vault.Name))
+		return
+	}
+
+	if !deleteOpts.Yes && !confirm(fmt.Sprintf("Delete %s from team %s for every member? This cannot be undone. (y/n)", name, vault.Name)) {
+		fmt.Println(utils.StylePrompt.Render("👋 Nothing was deleted."))
+		return
+	}
+
+	vault.Entries = append(vault.Entries[:index], vault.Entries[index+1:]...)
+	if err := vault.Save(path); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving team vault: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Password for %s/%s deleted from team %s", source, username, vault.Name)))
+}
@@ -0,0 +1,199 @@
+package functions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+)
+
+// trashRetention returns the configured number of days deleted entries are
+// kept, 0 meaning forever.
+func trashRetention() int {
+	days, err := strconv.Atoi(utils.GetSetting(utils.TrashRetentionSetting, ""))
+	if err != nil || days < 0 {
+		return utils.DefaultTrashRetentionDays
+	}
+	return days
+}
+
+// ShowTrash lists the entries in the trash.
+func ShowTrash() {
+	entries, err := utils.ListTrash()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading trash: " + err.Error()))
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println(utils.StylePrompt.Render("The trash is empty."))
+		return
+	}
+
+	retention := trashRetention()
+	fmt.Println(utils.StyleHeading.Render(fmt.Sprintf("%d entries in the trash:", len(entries))))
+	for _, e := range entries {
+		purge := ""
+		if retention > 0 {
+			purge = ", purged after " + e.DeletedAt.AddDate(0, 0, retention).Local().Format("2006-01-02")
+		}
+		fmt.Printf("%s %s/%s  %s  deleted %s%s\n", utils.StylePrompt.Render("•"), e.Source, e.Username, e.URL,
+			e.DeletedAt.Local().Format("2006-01-02 15:04"), purge)
+	}
+}
+
+// findTrashed returns the trashed entries for source/username, or all of them
+// for an empty name.
+func findTrashed(name string) ([]utils.TrashedEntry, error) {
+	entries, err := utils.ListTrash()
+	if err != nil || name == "" {
+		return entries, err
+	}
+	source, username, ok := strings.Cut(name, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid password name format, use 'source/username'")
+	}
+	var found []utils.TrashedEntry
+	for _, e := range entries {
+		if e.Source == source && e.Username == username {
+			found = append(found, e)
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no password for %s in the trash", name)
+	}
+	return found, nil
+}
+
+// RestoreFromTrash takes an entry out of the trash.
+func RestoreFromTrash(name string) {
+	entries, err := findTrashed(name)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error starting transaction: " + err.Error()))
+		return
+	}
+	defer tx.Rollback()
+	for _, e := range entries {
+		err = utils.RestoreTrashed(tx, e.ID)
+		if err == nil {
+			err = utils.Audit(tx, "restore", e.ID, e.Source+"/"+e.Username, "restored from the trash")
+		}
+		if err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error restoring " + name + ": " + err.Error()))
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error restoring " + name + ": " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render("✅ Password for " + name + " restored from the trash"))
+}
+
+// PurgeTrash permanently deletes an entry in the trash, or every entry in it
+// when name is empty.
+func PurgeTrash(name string, opts DeleteOptions) {
+	entries, err := findTrashed(name)
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ " + err.Error()))
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println(utils.StylePrompt.Render("The trash is empty."))
+		return
+	}
+
+	prompt := fmt.Sprintf("Permanently delete the %d entries in the trash? This cannot be undone. (y/n)", len(entries))
+	if name != "" {
+		prompt = "Permanently delete the password for " + name + " and its history? This cannot be undone. (y/n)"
+	}
+	if !opts.Yes && !confirm(prompt) {
+		fmt.Println(utils.StylePrompt.Render("👋 Nothing was purged."))
+		return
+	}
+	if !autoBackup("purge") {
+		return
+	}
+
+	if err := purgeEntries(entries, "purged from the trash"); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error purging trash: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ %d entries permanently deleted", len(entries))))
+}
+
+// PurgeExpiredTrash permanently deletes the entries that have been in the
+// trash for longer than the retention. It runs before every command that
+// works on the vault.
+func PurgeExpiredTrash() {
+	entries, err := utils.ListTrash()
+	if err != nil || len(entries) == 0 {
+		return
+	}
+	retention := trashRetention()
+	expired := utils.SelectExpiredTrash(entries, retention, time.Now())
+	if len(expired) == 0 {
+		return
+	}
+	if !autoBackup("purge") {
+		return
+	}
+	if err := purgeEntries(expired, fmt.Sprintf("in the trash for more than %d days", retention)); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error purging expired trash: " + err.Error()))
+		return
+	}
+	fmt.Println(utils.StyleInfo.Render(fmt.Sprintf("🗑️  %d entries deleted more than %d days ago were purged from the trash", len(expired), retention)))
+}
+
+func purgeEntries(entries []utils.TrashedEntry, reason string) error {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, e := range entries {
+		if err := utils.PurgeEntry(tx, e.ID); err != nil {
+			return err
+		}
+		if err := utils.Audit(tx, "purge", e.ID, e.Source+"/"+e.Username, reason); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// TrashRetentionCommand shows or sets the number of days entries stay in
+// the trash.
+func TrashRetentionCommand(days string) {
+	if days == "" {
+		retention := trashRetention()
+		if retention == 0 {
+			fmt.Println(utils.StyleInfo.Render("ℹ️ Entries stay in the trash until they are purged"))
+		} else {
+			fmt.Println(utils.StyleInfo.Render(fmt.Sprintf("ℹ️ Entries are purged %d days after they were deleted", retention)))
+		}
+		return
+	}
+
+	n, err := strconv.Atoi(days)
+	if err != nil || n < 0 {
+		fmt.Println(utils.StyleError.Render("❌ Invalid number of days " + days))
+		return
+	}
+	if err := utils.SetSetting(utils.TrashRetentionSetting, strconv.Itoa(n)); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error saving setting: " + err.Error()))
+		return
+	}
+	if n == 0 {
+		fmt.Println(utils.StyleSuccess.Render("✅ Entries now stay in the trash until they are purged"))
+	} else {
+		fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Entries are now purged %d days after they were deleted", n)))
+	}
+}
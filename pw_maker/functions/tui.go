@@ -0,0 +1,103 @@
+package functions
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tadeasf/pw_maker/pw_maker/utils"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// BrowseVault runs the full-screen vault browser.
+func BrowseVault() {
+	m, err := utils.NewBrowserModel(utils.BrowserActions{
+		Load:     utils.GetCredentials,
+		Save:     saveBrowserEntry,
+		Delete:   trashBrowserEntry,
+		Generate: generateBrowserPassword,
+		Accessed: func(c utils.Credential, how string) error {
+			return utils.Audit(utils.DB, "get", c.ID, c.Source+"/"+c.Username, how+" in the tui")
+		},
+	})
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error reading passwords: " + err.Error()))
+		return
+	}
+	if _, err := tea.NewProgram(m, tea.WithAltScreen()).Run(); err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error running program: " + err.Error()))
+	}
+}
+
+// generateBrowserPassword generates a password with the generator policy of
+// an entry, or the default policy when it cannot be read.
+func generateBrowserPassword(c utils.Credential) (string, error) {
+	password, _, err := generatePasswordFor(c.Source, c.Username, c.URL)
+	if err != nil {
+		return GenerateNewPassword()
+	}
+	return password, nil
+}
+
+// saveBrowserEntry stores an entry edited in the browser. The replaced
+// password of an existing entry is kept in its history.
+func saveBrowserEntry(c utils.Credential) (int64, error) {
+	folder, err := utils.NormalizeFolder(c.Folder)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	name := c.Source + "/" + c.Username
+	id := c.ID
+	if id == 0 {
+		now := utils.FormatDBTime(time.Now())
+		id, err = utils.InsertEntry(tx, c.Source, c.Username, c.Password, c.URL, now, now)
+		if err == nil {
+			_, err = tx.Exec("UPDATE passwords SET folder = ? WHERE id = ?", folder, id)
+		}
+		if err == nil {
+			err = utils.Audit(tx, "store", id, name, "in the tui")
+		}
+	} else {
+		var password string
+		err = tx.QueryRow("SELECT password FROM passwords WHERE id = ? AND deleted_at IS NULL", id).Scan(&password)
+		if err == nil && password != c.Password {
+			err = utils.ArchivePassword(tx, id)
+			if err == nil {
+				_, err = tx.Exec("UPDATE passwords SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", c.Password, id)
+			}
+		}
+		if err == nil {
+			_, err = tx.Exec("UPDATE passwords SET source = ?, username = ?, url = ?, folder = ? WHERE id = ?",
+				c.Source, c.Username, c.URL, folder, id)
+		}
+		if err == nil {
+			err = utils.Audit(tx, "update", id, name, "in the tui")
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func trashBrowserEntry(c utils.Credential) error {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := utils.TrashEntry(tx, c.ID); err != nil {
+		return err
+	}
+	if err := utils.Audit(tx, "delete", c.ID, c.Source+"/"+c.Username, "moved to the trash in the tui"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,8 +1,11 @@
 package functions
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
-	"math/rand"
+	"io"
+	"os"
 	"strings"
 	"time"
 
@@ -17,11 +20,21 @@ var UpdateCmd = &cobra.Command{
 	Short: "Update a specific password",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		UpdatePassword(args[0])
+		UpdatePassword(args[0], UpdateOptions{})
 	},
 }
 
-func UpdatePassword(name string) {
+// UpdateOptions configure how the new password is read.
+type UpdateOptions struct {
+	// PasswordStdin reads the new password from stdin instead of asking.
+	PasswordStdin bool
+}
+
+// minPasswordEntropy is the estimated strength below which a manually
+// entered password has to be confirmed.
+const minPasswordEntropy = 60
+
+func UpdatePassword(name string, opts UpdateOptions) {
 	parts := strings.Split(name, "/")
 	if len(parts) != 2 {
 		fmt.Println(utils.StyleError.Render("❌ Invalid password name format. Use 'source/username'."))
@@ -31,48 +44,39 @@ func UpdatePassword(name string) {
 	source, username := parts[0], parts[1]
 
 	// Check if the password exists
-	var exists bool
-	err := utils.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM passwords WHERE source = ? AND username = ?)", source, username).Scan(&exists)
-	if err != nil {
-		fmt.Println(utils.StyleError.Render("❌ Error checking password existence: " + err.Error()))
+	var id int64
+	var url string
+	err := utils.DB.QueryRow("SELECT id, url FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL", source, username).Scan(&id, &url)
+	if err == sql.ErrNoRows {
+		fmt.Println(utils.StyleError.Render(fmt.Sprintf("❌ No password found for %s/%s", source, username)))
 		return
 	}
-
-	if !exists {
-		fmt.Println(utils.StyleError.Render(fmt.Sprintf("❌ No password found for %s/%s", source, username)))
+	if err != nil {
+		fmt.Println(utils.StyleError.Render("❌ Error checking password existence: " + err.Error()))
 		return
 	}
 
-	fmt.Println(utils.StylePrompt.Render("Do you want to generate a new password or input one manually? (g/m):"))
-	var choice string
-	_, err = fmt.Scanln(&choice)
+	newPassword, err := readNewPassword(opts, func() (string, string, error) {
+		return generatePasswordFor(source, username, url)
+	})
 	if err != nil {
 		fmt.Println(utils.StyleError.Render("❌ Error reading input: " + err.Error()))
 		return
 	}
-
-	var newPassword string
-	if strings.ToLower(choice) == "g" {
-		newPassword = GenerateNewPassword()
-		fmt.Println(utils.StylePassword.Render("New generated password: " + newPassword))
-	} else {
-		fmt.Println(utils.StylePrompt.Render("Enter the new password:"))
-		_, err = fmt.Scanln(&newPassword)
-		if err != nil {
-			fmt.Println(utils.StyleError.Render("❌ Error reading input: " + err.Error()))
-			return
-		}
+	if newPassword == "" {
+		fmt.Println(utils.StylePrompt.Render("👋 Keeping the current password."))
+		return
 	}
 
-	// Update the password in the database
-	_, err = utils.DB.Exec("UPDATE passwords SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE source = ? AND username = ?", newPassword, source, username)
-	if err != nil {
+	if err := replacePassword(id, source+"/"+username, newPassword, "update", ""); err != nil {
 		fmt.Println(utils.StyleError.Render("❌ Error updating password: " + err.Error()))
 		return
 	}
 
 	fmt.Println(utils.StyleSuccess.Render(fmt.Sprintf("✅ Password for %s/%s updated successfully", source, username)))
-	fmt.Println(utils.StylePassword.Render("New password: " + newPassword))
+	if opts.PasswordStdin {
+		return
+	}
 
 	// Copy the new password to clipboard
 	err = clipboard.WriteAll(newPassword)
@@ -91,11 +95,76 @@ func UpdatePassword(name string) {
 	}
 }
 
-func GenerateNewPassword() string {
-	charset := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()_+-=[]{}|;:,.<>?"
-	password := make([]byte, 16)
-	for i := range password {
-		password[i] = charset[rand.Intn(len(charset))]
+// replacePassword sets a new password for an entry and keeps the old one in
+// its history. The change is audited as action.
+func replacePassword(id int64, name, password, action, details string) error {
+	tx, err := utils.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := utils.ArchivePassword(tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE passwords SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", password, id); err != nil {
+		return err
+	}
+	if err := utils.Audit(tx, action, id, name, details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// readNewPassword asks whether to generate a new password with generate or
+// enter one. Entered passwords are not echoed and have to be repeated. It
+// returns "" when the user decided to keep the current password.
+func readNewPassword(opts UpdateOptions, generate func() (password, policy string, err error)) (string, error) {
+	if opts.PasswordStdin {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		password := strings.TrimRight(string(data), "\r\n")
+		if password == "" {
+			return "", errors.New("no password on stdin")
+		}
+		fmt.Println("Strength " + utils.EntropyMeter(utils.PasswordEntropy(password), 32))
+		return password, nil
 	}
-	return string(password)
+
+	fmt.Println(utils.StylePrompt.Render("Do you want to generate a new password or input one manually? (g/m):"))
+	var choice string
+	_, err := fmt.Scanln(&choice)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.ToLower(choice) == "g" {
+		newPassword, policy, err := generate()
+		if err != nil {
+			return "", err
+		}
+		if policy != "" {
+			fmt.Println(utils.StyleInfo.Render("ℹ️ Generated with the generator policy of " + policy))
+		}
+		fmt.Println(utils.StylePassword.Render("New generated password: " + newPassword))
+		return newPassword, nil
+	}
+
+	newPassword, err := utils.ReadNewSecret("Enter the new password: ", "password")
+	if err != nil {
+		return "", err
+	}
+	bits := utils.PasswordEntropy(newPassword)
+	fmt.Println("Strength " + utils.EntropyMeter(bits, 32))
+	if bits < minPasswordEntropy && !confirm("This password is weak. Save it anyway? (y/n)") {
+		return "", nil
+	}
+	return newPassword, nil
+}
+
+// GenerateNewPassword returns a password generated with the default
+// policy, 16 characters of every class.
+func GenerateNewPassword() (string, error) {
+	return utils.DefaultGeneratorOptions.Generate()
 }
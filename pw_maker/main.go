@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/tadeasf/pw_maker/pw_maker/functions"
 	"github.com/tadeasf/pw_maker/pw_maker/utils"
@@ -12,11 +14,13 @@ import (
 
 // TODO: refactor everything
 func init() {
-	rootCmd.Flags().BoolVarP(&utils.IncludeSpecial, "special", "s", false, "Include special characters")
-	rootCmd.Flags().IntVarP(&utils.Length, "length", "l", 12, "Password length")
+	addGeneratorFlags(rootCmd)
+	addGeneratorFlags(generateCmd)
 
 	utils.InitDB()
+	rootCmd.PersistentPreRun = purgeExpiredTrash
 
+	rootCmd.AddCommand(generateCmd)
 	rootCmd.AddCommand(showCmd)
 	rootCmd.AddCommand(searchCmd)
 	rootCmd.AddCommand(getCmd)
@@ -25,6 +29,160 @@ func init() {
 	rootCmd.AddCommand(updateCmd)
 	rootCmd.AddCommand(backupDBCmd)
 	rootCmd.AddCommand(importDBCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(restoreBackupCmd)
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(teamCmd)
+	rootCmd.AddCommand(logCmd)
+	rootCmd.AddCommand(trashCmd)
+	rootCmd.AddCommand(mvCmd)
+	rootCmd.AddCommand(lsCmd)
+	rootCmd.AddCommand(tuiCmd)
+	rootCmd.AddCommand(tagCmd)
+	rootCmd.AddCommand(rotationCmd)
+	rootCmd.AddCommand(dueCmd)
+	rootCmd.AddCommand(rotateCmd)
+	rootCmd.AddCommand(policyCmd)
+	rootCmd.AddCommand(completionCmd)
+	rootCmd.AddCommand(agentCmd)
+	rootCmd.AddCommand(lockCmd)
+
+	agentCmd.AddCommand(agentStatusCmd)
+
+	trashCmd.AddCommand(trashListCmd)
+	trashCmd.AddCommand(trashRestoreCmd)
+	trashCmd.AddCommand(trashPurgeCmd)
+	trashCmd.AddCommand(trashRetentionCmd)
+
+	rotationCmd.AddCommand(rotationSetCmd)
+	rotationCmd.AddCommand(rotationUnsetCmd)
+	rotationCmd.AddCommand(rotationListCmd)
+	rotationCmd.AddCommand(rotationHookCmd)
+
+	policyCmd.AddCommand(policySetCmd)
+	policyCmd.AddCommand(policyUnsetCmd)
+	policyCmd.AddCommand(policyShowCmd)
+	policyCmd.AddCommand(policyListCmd)
+
+	logCmd.AddCommand(logVerifyCmd)
+
+	teamCmd.AddCommand(teamInitCmd)
+	teamCmd.AddCommand(teamJoinCmd)
+	teamCmd.AddCommand(teamPubkeyCmd)
+	teamCmd.AddCommand(teamAddMemberCmd)
+	teamCmd.AddCommand(teamRemoveMemberCmd)
+	teamCmd.AddCommand(teamMembersCmd)
+	teamCmd.AddCommand(teamShareCmd)
+	teamCmd.AddCommand(teamListCmd)
+	teamCmd.AddCommand(teamGetCmd)
+	teamCmd.AddCommand(teamRoleCmd)
+	teamCmd.AddCommand(teamACLCmd)
+	teamCmd.AddCommand(teamSignCmd)
+
+	syncCmd.AddCommand(syncInitCmd)
+	syncCmd.AddCommand(syncKeyCmd)
+
+	backupCmd.AddCommand(backupPushCmd)
+	backupCmd.AddCommand(backupListCmd)
+	backupCmd.AddCommand(backupPruneCmd)
+	backupCmd.AddCommand(backupPullCmd)
+	backupCmd.AddCommand(backupTargetCmd)
+	backupCmd.AddCommand(backupKeyCmd)
+
+	importCmd.Flags().StringVarP(&importOpts.Format, "format", "f", "", "Import format ("+strings.Join(functions.ImporterNames(), ", ")+")")
+	importCmd.Flags().StringVar(&importOpts.Map, "map", "", "Column mapping for other CSV files, e.g. source=Title,username=Login,password=Secret")
+	importCmd.Flags().BoolVar(&importOpts.DryRun, "dry-run", false, "Show what would be imported without changing the vault")
+	importCmd.Flags().StringVar(&importOpts.OnConflict, "on-conflict", "overwrite", "How to handle entries with a different stored password ("+strings.Join(functions.ConflictStrategies, ", ")+")")
+
+	backupDBCmd.Flags().IntVar(&backupRetention.Keep, "keep", backupRetention.Keep, "Number of most recent backups to keep")
+	backupDBCmd.Flags().IntVar(&backupRetention.Daily, "keep-daily", backupRetention.Daily, "Number of days with backups to keep the newest backup of")
+	backupDBCmd.Flags().IntVar(&backupRetention.Weekly, "keep-weekly", backupRetention.Weekly, "Number of weeks with backups to keep the newest backup of")
+
+	backupPruneCmd.Flags().IntVar(&remoteRetention.Keep, "keep", remoteRetention.Keep, "Number of most recent backups to keep")
+	backupPruneCmd.Flags().IntVar(&remoteRetention.Daily, "keep-daily", remoteRetention.Daily, "Number of days with backups to keep the newest backup of")
+	backupPruneCmd.Flags().IntVar(&remoteRetention.Weekly, "keep-weekly", remoteRetention.Weekly, "Number of weeks with backups to keep the newest backup of")
+	backupPruneCmd.Flags().BoolVar(&backupPruneDryRun, "dry-run", false, "Only show which backups would be removed")
+	backupPullCmd.Flags().StringVarP(&backupPullTarget, "target", "t", "", "Target to download the backup from instead of the default target")
+	backupKeyCmd.Flags().StringVar(&backupKeySet, "set", "", "Replace the backup key with this hex encoded key")
+
+	syncInitCmd.Flags().BoolVar(&syncInitOpts.AskKey, "ask-key", false, "Prompt for the sync key of a vault that is already synced from another machine")
+	syncInitCmd.Flags().BoolVar(&syncInitOpts.Server, "server", false, "Sync with a fortpass sync server instead of a git remote")
+	syncInitCmd.Flags().StringVar(&syncInitOpts.Token, "token", "", "Access token of the sync server")
+
+	showCmd.Flags().StringVar(&showFolder, "folder", "", "Only show passwords in this folder and its subfolders")
+	searchCmd.Flags().SetInterspersed(false)
+	searchCmd.Flags().StringVarP(&searchOpts.Format, "format", "f", "", "Output format ("+strings.Join(functions.SearchFormats, ", ")+"), default list on a terminal and table otherwise")
+	searchCmd.Flags().BoolVar(&searchOpts.First, "first", false, "Copy the password of the best match to the clipboard")
+	tagCmd.Flags().BoolVarP(&tagRemove, "remove", "r", false, "Remove the given tags instead of adding them")
+	lsCmd.Flags().BoolVarP(&lsRecursive, "recursive", "r", false, "List the subfolders and their passwords as a tree")
+
+	deleteCmd.Flags().BoolVarP(&deleteOpts.Yes, "yes", "y", false, "Delete without asking for confirmation")
+	policySetCmd.Flags().IntVarP(&policyOpts.Length, "length", "l", utils.DefaultGeneratorOptions.Length, "Length of the passwords")
+	policySetCmd.Flags().BoolVar(&policyOpts.NoLower, "no-lower", false, "Leave out lowercase letters")
+	policySetCmd.Flags().BoolVar(&policyOpts.NoUpper, "no-upper", false, "Leave out uppercase letters")
+	policySetCmd.Flags().BoolVar(&policyOpts.NoDigits, "no-digits", false, "Leave out digits")
+	policySetCmd.Flags().BoolVar(&policyOpts.NoSpecial, "no-special", false, "Leave out special characters")
+	policySetCmd.Flags().StringVar(&policyOpts.Exclude, "exclude", "", "Characters that never appear in the passwords")
+	policySetCmd.Flags().BoolVar(&policyOpts.NoAmbiguous, "no-ambiguous", false, "Leave out easily confused characters ("+utils.AmbiguousChars+")")
+	policySetCmd.Flags().BoolVarP(&policyOpts.Passphrase, "passphrase", "p", false, "Generate passphrases of words instead")
+	policySetCmd.Flags().IntVarP(&policyOpts.Words, "words", "w", utils.DefaultGeneratorOptions.Words, "Number of words of passphrases")
+	policySetCmd.Flags().StringVar(&policyOpts.Separator, "separator", "-", "Separator between the words of passphrases")
+	rotationHookCmd.Flags().BoolVarP(&rotationHookRemove, "remove", "r", false, "Remove the rotation hook")
+	agentCmd.Flags().DurationVar(&agentOpts.Timeout, "timeout", 15*time.Minute, "Stop the agent after it was not used for this long")
+	rotateCmd.Flags().DurationVar(&rotateOpts.Timeout, "timeout", 2*time.Minute, "How long the rotation hook may run")
+	dueCmd.Flags().IntVarP(&dueOpts.Within, "within", "w", 0, "Also list the passwords due in the next days")
+	dueCmd.Flags().StringVarP(&dueOpts.Format, "format", "f", "list", "Output format ("+strings.Join(functions.DueFormats, ", ")+")")
+	updateCmd.Flags().BoolVar(&updateOpts.PasswordStdin, "password-stdin", false, "Read the new password from stdin")
+	trashPurgeCmd.Flags().BoolVarP(&deleteOpts.Yes, "yes", "y", false, "Purge without asking for confirmation")
+
+	logCmd.Flags().StringVar(&logOpts.Action, "action", "", "Only show events of this action, e.g. get, update, delete, import or backup")
+	logCmd.Flags().StringVar(&logOpts.Entry, "entry", "", "Only show events of entries matching source/username")
+	logCmd.Flags().StringVar(&logOpts.User, "user", "", "Only show events of this OS user")
+	logCmd.Flags().StringVar(&logOpts.Since, "since", "", "Only show events since a date (2024-01-31) or for a duration (12h, 7d)")
+	logCmd.Flags().IntVarP(&logOpts.Limit, "limit", "n", 50, "Number of most recent events to show (0 for all)")
+
+	serveCmd.Flags().StringVar(&serveOpts.Addr, "addr", ":8443", "Address to listen on")
+	serveCmd.Flags().StringVar(&serveOpts.Data, "data", "", "Database file for the stored entries (default ~/.fortpass/server.db)")
+	serveCmd.Flags().StringVar(&serveOpts.Token, "token", os.Getenv("FORTPASS_SERVER_TOKEN"), "Access token clients must send (default $FORTPASS_SERVER_TOKEN)")
+	serveCmd.Flags().StringVar(&serveOpts.TLSCert, "tls-cert", "", "TLS certificate file")
+	serveCmd.Flags().StringVar(&serveOpts.TLSKey, "tls-key", "", "TLS key file")
+
+	teamCmd.PersistentFlags().StringVarP(&teamOpts.Team, "team", "t", "", "Team to work on (default: the first team created or joined)")
+	teamInitCmd.Flags().StringVar(&teamOpts.MemberName, "name", "", "Your member name in the team (default $USER)")
+	teamAddMemberCmd.Flags().StringVar(&teamOpts.MemberName, "name", "", "Name of the new member")
+	teamAddMemberCmd.Flags().StringVar(&teamOpts.Role, "role", "viewer", "Role of the new member ("+strings.Join(utils.TeamRoles, ", ")+")")
+	teamShareCmd.Flags().StringVar(&teamOpts.Folder, "folder", "", "Folder of the entry in the team vault")
+	teamShareCmd.Flags().StringSliceVar(&teamOpts.Readers, "readers", nil, "Members allowed to read the entry besides the owners (default: the folder's readers)")
+	for _, cmd := range []*cobra.Command{getCmd, updateCmd, deleteCmd} {
+		cmd.Flags().StringVar(&teamOpts.Team, "team", "", "Work on an entry of this team vault instead of your own vault")
+	}
+
+	for _, cmd := range []*cobra.Command{getCmd, updateCmd, deleteCmd, teamCmd} {
+		cmd.RegisterFlagCompletionFunc("team", functions.CompleteTeams)
+	}
+	showCmd.RegisterFlagCompletionFunc("folder", functions.CompleteFolders)
+	logCmd.RegisterFlagCompletionFunc("entry", functions.CompleteEntries)
+	teamAddMemberCmd.RegisterFlagCompletionFunc("role", cobra.FixedCompletions(utils.TeamRoles, cobra.ShellCompDirectiveNoFileComp))
+	searchCmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(functions.SearchFormats, cobra.ShellCompDirectiveNoFileComp))
+	dueCmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(functions.DueFormats, cobra.ShellCompDirectiveNoFileComp))
+	importCmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(functions.ImporterNames(), cobra.ShellCompDirectiveNoFileComp))
+	importCmd.RegisterFlagCompletionFunc("on-conflict", cobra.FixedCompletions(functions.ConflictStrategies, cobra.ShellCompDirectiveNoFileComp))
+	exportCmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(functions.ExportFormats, cobra.ShellCompDirectiveNoFileComp))
+	exportCmd.RegisterFlagCompletionFunc("fields", cobra.FixedCompletions(append(functions.ExportFields, "all"), cobra.ShellCompDirectiveNoFileComp))
+
+	restoreBackupCmd.Flags().BoolVarP(&restoreOpts.Yes, "yes", "y", false, "Restore without asking for confirmation")
+
+	importDBCmd.Flags().BoolVar(&importDBMerge, "merge", false, "Merge the database into the current vault instead of replacing it")
+	importDBCmd.Flags().BoolVar(&mergeOpts.AskKey, "ask-key", false, "Prompt for the key or passphrase of the database to merge")
+
+	exportCmd.Flags().StringVarP(&exportOpts.Format, "format", "f", "csv", "Export format ("+strings.Join(functions.ExportFormats, ", ")+")")
+	exportCmd.Flags().StringSliceVar(&exportOpts.Fields, "fields", nil, "Fields to export ("+strings.Join(functions.ExportFields, ", ")+" or all), encrypted exports always contain all")
+	exportCmd.Flags().StringVar(&exportOpts.Source, "source", "", "Only export entries whose source contains this text")
+	exportCmd.Flags().StringVar(&exportOpts.Username, "username", "", "Only export entries whose username contains this text")
+	exportCmd.Flags().StringVar(&exportOpts.URL, "url", "", "Only export entries whose URL contains this text")
+	exportCmd.Flags().StringVar(&exportOpts.Since, "since", "", "Only export entries updated on or after this date (YYYY-MM-DD)")
 }
 
 var rootCmd = &cobra.Command{
@@ -38,95 +196,991 @@ Usage:
 Available Commands:
   generate    Generate a new password
   show        Show all stored passwords
-  search      Search for stored passwords
+  ls          List the subfolders and passwords of a folder
+  mv          Move a password or folder into another folder
+  search      Search for stored passwords, interactively or with a query
+  tag         Show, add or remove the tags of a password
+  tui         Browse, copy, edit and delete passwords in a full-screen interface
   get         Get a specific password by source/username
-  import      Import passwords from a CSV file
-  delete      Delete a specific password
+  import      Import passwords from another password manager or a CSV file
+  delete      Move a specific password to the trash
+  trash       List, restore and purge deleted passwords
+  rotation    Set how often passwords of an entry or folder have to be rotated
+  due         List the passwords overdue for rotation
+  rotate      Change a password where it is used with its rotation hook
+  policy      Set how passwords are generated for an entry or domain
   update      Update a specific password
-  backup      Backup the password database
+  backupdb    Backup the password database
   importdb    Import a password database
+  restore-backup Restore the password database from a backup
+  export      Export passwords to a file
+  backup      Manage encrypted backups in local, SFTP and S3 targets
+  sync        Sync the vault through a git repository or a sync server
+  serve       Run a sync server
+  team        Share passwords with a team through an end-to-end encrypted team vault
+  log         Show the audit log of vault accesses and changes
+  agent       Cache the vault keys for the following commands
+  lock        Stop the agent and wipe the cached keys
+  completion  Generate the shell completion script for bash, zsh or fish
 
 Flags:
   -h, --help   help for fortpass
 
 Use "fortpass [command] --help" for more information about a command.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		utils.GeneratePassword()
+		functions.GeneratePassword(generateOpts)
+	},
+}
+
+// generateOpts are the initial generator options, letters and digits of
+// 12 characters by default.
+var generateOpts = utils.GeneratorOptions{Lower: true, Upper: true, Digits: true, Separator: "-"}
+
+func addGeneratorFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&generateOpts.Length, "length", "l", 12, "Password length")
+	cmd.Flags().BoolVarP(&generateOpts.Special, "special", "s", false, "Include special characters")
+	cmd.Flags().StringVar(&generateOpts.Exclude, "exclude", "", "Characters that must not appear in the password")
+	cmd.Flags().BoolVar(&generateOpts.ExcludeAmbiguous, "no-ambiguous", false, "Leave out characters that are easily confused ("+utils.AmbiguousChars+")")
+	cmd.Flags().BoolVarP(&generateOpts.Passphrase, "passphrase", "p", false, "Generate a passphrase of words instead")
+	cmd.Flags().IntVarP(&generateOpts.Words, "words", "w", utils.DefaultGeneratorOptions.Words, "Number of words in a passphrase")
+}
+
+var generateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate a new password",
+	Long: `Generate a password in an interactive generator. Change the length, the
+character classes, excluded characters or switch to a passphrase and the
+password is regenerated with its strength shown. Store it as a new entry or
+apply it to an existing one without leaving the generator.
+
+When the output is not a terminal the password is printed, e.g.
+fortpass generate -l 20 -s | pbcopy`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.GeneratePassword(generateOpts)
 	},
 }
 
+var showFolder string
+
 var showCmd = &cobra.Command{
 	Use:   "show",
 	Short: "Show all passwords",
 	Run: func(cmd *cobra.Command, args []string) {
-		functions.ShowPasswords()
+		functions.ShowPasswords(showFolder)
+	},
+}
+
+var lsRecursive bool
+
+var lsCmd = &cobra.Command{
+	Use:               "ls [folder]",
+	Short:             "List the subfolders and passwords of a folder",
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: functions.CompleteFolders,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.ListFolder(optionalArg(args), lsRecursive)
+	},
+}
+
+var mvCmd = &cobra.Command{
+	Use:   "mv [source/username|folder] [folder]",
+	Short: "Move a password or folder into another folder",
+	Long: `Move a password into a folder, or rename a folder together with its
+subfolders. Folders are paths such as work/aws/prod; use / as the target to
+move a password back to the top level.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: functions.CompleteMove,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.MovePassword(args[0], args[1])
 	},
 }
 
+var searchOpts functions.SearchOptions
+
 var searchCmd = &cobra.Command{
-	Use:   "search",
+	Use:   "search [query]",
 	Short: "Search passwords",
+	Long: `Search passwords with fuzzy matching, best match first. Without a query the
+interactive search opens, with one the matches are printed.
+
+Words match the source, username, URL or folder. Limit a word to a field with
+source:, user:, url:, folder: or tag:, and exclude the entries containing a
+word with a leading - or !, e.g.
+
+  fortpass search aws user:alice tag:prod -tag:old
+
+When the output is not a terminal, or with --format, the matches are printed
+as a table or as JSON for scripts; passwords are never printed. --first copies
+the password of the best match instead. The exit status is 1 when nothing
+matches. Flags must come before the query.`,
+	ValidArgsFunction: functions.CompleteSearch,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.SearchPasswords(strings.Join(args, " "), searchOpts)
+	},
+}
+
+var tagRemove bool
+
+var tagCmd = &cobra.Command{
+	Use:   "tag [source/username] [tags...]",
+	Short: "Show, add or remove the tags of a password",
+	Long: `Add tags to a password, or remove them with --remove. Without tags the tags
+of the password are shown, without arguments every tag in use.`,
+	ValidArgsFunction: functions.CompleteTag,
 	Run: func(cmd *cobra.Command, args []string) {
-		functions.SearchPasswords()
+		if len(args) == 0 {
+			functions.ListTags()
+			return
+		}
+		functions.TagPassword(args[0], args[1:], tagRemove)
+	},
+}
+
+var tuiCmd = &cobra.Command{
+	Use:   "tui",
+	Short: "Browse, copy, edit and delete passwords in a full-screen interface",
+	Long: `Browse the vault in a full-screen interface with the list of passwords next
+to the details of the selected one. Copy the password (c), username (u) or
+URL (o), reveal the password for a few seconds (r), edit the entry (e), add a
+new one (n), replace the password with a generated one (g) or move the entry
+to the trash (d). Press ? for all keys.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.BrowseVault()
 	},
 }
 
 var getCmd = &cobra.Command{
-	Use:   "get [password name]",
-	Short: "Get a specific password and copy it to clipboard",
-	Args:  cobra.ExactArgs(1),
+	Use:               "get [password name]",
+	Short:             "Get a specific password and copy it to clipboard",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: functions.CompleteEntries,
 	Run: func(cmd *cobra.Command, args []string) {
+		if teamOpts.Team != "" {
+			functions.TeamGet(args[0], teamOpts)
+			return
+		}
 		functions.GetPassword(args[0])
 	},
 }
 
+var importOpts functions.ImportOptions
+
 var importCmd = &cobra.Command{
-	Use:   "import [csv_file]",
-	Short: "Import passwords from a CSV file",
-	Args:  cobra.ExactArgs(1),
+	Use:   "import [file]",
+	Short: "Import passwords from another password manager or a CSV file",
+	Long: `Import passwords from another password manager or a CSV file.
+
+The format is detected from the file contents. Use --format to override the
+detection or --map to import a CSV file with arbitrary column names, e.g.
+--map source=Title,username=Login,password=Secret,url=Website
+
+Entries whose password differs from the stored one are updated when the import
+is known to be newer. Otherwise they are conflicts, handled with --on-conflict:
+skip keeps the stored password, overwrite replaces it, keep-both stores the
+imported password as a separate entry and prompt lets you decide per entry.
+Use --dry-run to review the changes first, it lists conflicts without prompting.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		functions.ImportPasswords(args[0])
+		functions.ImportPasswords(args[0], importOpts)
 	},
 }
 
+var deleteOpts functions.DeleteOptions
+
 var deleteCmd = &cobra.Command{
-	Use:   "delete [source/username]",
-	Short: "Delete a specific password",
-	Args:  cobra.ExactArgs(1),
+	Use:               "delete [source/username]",
+	Short:             "Move a specific password to the trash",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: functions.CompleteEntries,
 	Run: func(cmd *cobra.Command, args []string) {
-		functions.DeletePassword(args[0])
+		if teamOpts.Team != "" {
+			functions.TeamDelete(args[0], teamOpts, deleteOpts)
+			return
+		}
+		functions.DeletePassword(args[0], deleteOpts)
 	},
 }
 
+var updateOpts functions.UpdateOptions
+
 var updateCmd = &cobra.Command{
 	Use:   "update [source/username]",
 	Short: "Update a specific password",
-	Args:  cobra.ExactArgs(1),
+	Long: `Replace the password of an entry with a generated one or one you type. Typed
+passwords are not shown and have to be entered twice; their estimated strength
+is shown before saving and weak ones have to be confirmed.
+
+With --password-stdin the new password is read from stdin, for example:
+
+  pass show github | fortpass update github.com/johndoe --password-stdin`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: functions.CompleteEntries,
 	Run: func(cmd *cobra.Command, args []string) {
-		functions.UpdatePassword(args[0])
+		if teamOpts.Team != "" {
+			functions.TeamUpdate(args[0], teamOpts, updateOpts)
+			return
+		}
+		functions.UpdatePassword(args[0], updateOpts)
 	},
 }
 
+var backupRetention = utils.DefaultRetention
+
 var backupDBCmd = &cobra.Command{
 	Use:   "backupdb [destination]",
 	Short: "Backup the password database",
+	Long: `Backup the password database while it is in use and verify the copy.
+
+Without a destination the backup is written to ~/.fortpass/backups. When the
+destination is a directory a timestamped backup is written into it and older
+backups there are pruned: the newest --keep backups are kept, plus the newest
+backup of each of the last --keep-daily days and --keep-weekly weeks that have
+backups. Days and weeks without a backup do not count.
+
+Automatic backups are also taken before import, importdb, sync and purging
+the trash.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		destination := ""
+		if len(args) > 0 {
+			destination = args[0]
+		}
+		functions.BackupDatabase(destination, backupRetention)
+	},
+}
+
+var (
+	remoteRetention   = utils.DefaultRetention
+	backupPruneDryRun bool
+	backupKeySet      string
+	backupPullTarget  string
+)
+
+// optionalArg returns the first argument, or "" when none was given.
+func optionalArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return ""
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Manage encrypted backups in local, SFTP and S3 targets",
+	Long: `Manage encrypted backups in local directories, over SFTP and in
+S3-compatible storage.
+
+Targets are given as URLs:
+  /path/to/dir or file:///path/to/dir
+  sftp://user@host[:port]/path     (uses your ssh configuration and keys)
+  s3://bucket/prefix[?endpoint=http://localhost:9000&region=us-east-1]
+
+S3 credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+Backups are compressed and encrypted with a backup key kept in the system
+keyring before they leave the machine. Without a target argument the
+default target set with "backup target" is used.`,
+}
+
+var backupPushCmd = &cobra.Command{
+	Use:   "push [target]",
+	Short: "Upload an encrypted backup of the vault",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.PushBackup(optionalArg(args))
+	},
+}
+
+var backupListCmd = &cobra.Command{
+	Use:   "list [target]",
+	Short: "List the backups stored in a target",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.ListRemoteBackups(optionalArg(args))
+	},
+}
+
+var backupPruneCmd = &cobra.Command{
+	Use:   "prune [target]",
+	Short: "Remove old backups from a target",
+	Long: `Remove old backups from a target. The newest --keep backups are kept,
+plus the newest backup of each of the last --keep-daily days and
+--keep-weekly weeks that have backups. Days and weeks without a backup do not
+count.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.PruneRemoteBackups(optionalArg(args), remoteRetention, backupPruneDryRun)
+	},
+}
+
+var backupPullCmd = &cobra.Command{
+	Use:   "pull [backup_name] [destination]",
+	Short: "Download and decrypt a backup so it can be restored",
+	Long: `Download and decrypt a backup into a database file that can be restored
+with restore-backup. The backup is read from the default target unless
+--target is given.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		destination := ""
+		if len(args) == 2 {
+			destination = args[1]
+		}
+		functions.PullBackup(backupPullTarget, args[0], destination)
+	},
+}
+
+var backupTargetCmd = &cobra.Command{
+	Use:   "target [url]",
+	Short: "Show or set the default backup target",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.BackupTargetCommand(optionalArg(args))
+	},
+}
+
+var backupKeyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Show or replace the backup encryption key",
+	Long: `Show the backup encryption key. Store it somewhere safe: remote backups
+cannot be decrypted without it. Use --set on another machine to restore
+backups made elsewhere.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.BackupKeyCommand(backupKeySet)
+	},
+}
+
+var syncInitOpts functions.SyncInitOptions
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Sync the vault through a git repository or a sync server",
+	Long: `Sync the vault through a git repository or a sync server.
+
+Every entry is stored as a separate file encrypted with the sync key, so the
+repository can live on any git server, or be a bare repository on a shared
+drive. A sync commits the local changes, pulls the changes of other machines
+and merges them entry by entry: changes made on one side are taken over, and
+when an entry was changed on both sides the newer version wins and the other
+password is kept in the password history.
+
+With "sync init --server <url>" the vault is synced with a server started
+with "fortpass serve" instead. The server only stores the encrypted entries
+with a revision counter each; a change is accepted when it is based on the
+latest revision, otherwise the client merges it with the newer version first.
+
+Set up the first machine with "sync init <git remote>" and the others with
+"sync init <git remote> --ask-key", entering the key printed by "sync key".
+The key is read without echo, or from stdin when stdin is not a terminal.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.SyncVault()
+	},
+}
+
+var syncInitCmd = &cobra.Command{
+	Use:   "init [git_remote|server_url]",
+	Short: "Set up syncing with a git remote or sync server and run a first sync",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.SyncInit(args[0], syncInitOpts)
+	},
+}
+
+var serveOpts functions.ServeOptions
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a sync server",
+	Long: `Run a sync server for "fortpass sync".
+
+Clients encrypt every entry with their sync key before pushing it, so the
+server only stores encrypted entries and never sees a password. Protect it
+with --token and serve it over TLS, or behind a TLS terminating proxy.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.Serve(serveOpts)
+	},
+}
+
+var syncKeyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Show the sync key needed to sync another machine",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.SyncKeyCommand()
+	},
+}
+
+var teamOpts functions.TeamOptions
+
+var teamCmd = &cobra.Command{
+	Use:   "team",
+	Short: "Share passwords with a team through an end-to-end encrypted team vault",
+	Long: `Share passwords with a team through an end-to-end encrypted team vault.
+
+A team vault is a single file that can be shared in a git repository or on a
+shared drive. Every entry is encrypted with its own key, and that key is
+encrypted for the X25519 public key of every member. Your identity key is
+kept in the system keyring; print your public key with "team pubkey" and
+send it to a team owner to be added. Removing a member rotates the keys of
+the entries they could read.
+
+Members are owners, editors or viewers. Owners manage members and access and
+can read every entry. Editors share, update and delete entries; viewers can
+only read them. "team acl" limits who can read an entry or a folder: the
+entry key is only encrypted for those members, so the others cannot decrypt
+it even with a copy of the file. Use get, update and delete with --team to
+work on team entries.
+
+The members, roles and access lists are signed by an owner and every entry
+by the owner or editor that wrote it, with an Ed25519 signing key kept next
+to the identity key. Team commands refuse a vault whose signatures do not
+check out, or whose members were signed by someone who was not an owner
+when you last opened it. Joining a team trusts its current owners.`,
+}
+
+var teamInitCmd = &cobra.Command{
+	Use:   "init [name] [file]",
+	Short: "Create a team vault",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := ""
+		if len(args) == 2 {
+			path = args[1]
+		}
+		functions.TeamInit(args[0], path, teamOpts)
+	},
+}
+
+var teamJoinCmd = &cobra.Command{
+	Use:   "join [file]",
+	Short: "Use a team vault created by someone else",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.TeamJoin(args[0])
+	},
+}
+
+var teamPubkeyCmd = &cobra.Command{
+	Use:   "pubkey",
+	Short: "Show your public key for joining teams",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.TeamPublicKey()
+	},
+}
+
+var teamAddMemberCmd = &cobra.Command{
+	Use:   "add-member [public_key]",
+	Short: "Add a member and give them access to the entries they may read",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.TeamAddMember(args[0], teamOpts)
+	},
+}
+
+var teamRemoveMemberCmd = &cobra.Command{
+	Use:               "remove-member [name|public_key]",
+	Short:             "Remove a member and rotate the keys of the entries they could read",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: functions.CompleteTeamMember,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.TeamRemoveMember(args[0], teamOpts)
+	},
+}
+
+var teamMembersCmd = &cobra.Command{
+	Use:   "members",
+	Short: "List the members of a team",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.TeamMembers(teamOpts)
+	},
+}
+
+var teamShareCmd = &cobra.Command{
+	Use:               "share [source/username]",
+	Short:             "Share a password of your vault with a team",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: functions.CompleteEntries,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.TeamShare(args[0], teamOpts)
+	},
+}
+
+var teamListCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the entries of a team",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.TeamList(teamOpts)
+	},
+}
+
+var teamGetCmd = &cobra.Command{
+	Use:   "get [source/username]",
+	Short: "Copy the password of a team entry to the clipboard",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		functions.BackupDatabase(args[0])
+		functions.TeamGet(args[0], teamOpts)
+	},
+}
+
+var teamRoleCmd = &cobra.Command{
+	Use:               "role [member] [owner|editor|viewer]",
+	Short:             "Change the role of a member",
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: functions.CompleteTeamMember,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.TeamSetRole(args[0], args[1], teamOpts)
+	},
+}
+
+var teamACLCmd = &cobra.Command{
+	Use:   "acl [source/username|folder:name] [members...]",
+	Short: "Limit who can read an entry or folder",
+	Long: `Limit who can read an entry or all entries of a folder.
+
+Only the given members and the owners get the key of the entries. Without
+members the entry or folder can be read by everyone again. An entry's own
+readers take precedence over the readers of its folder. Members who lose
+access get a new entry key, so they cannot read later versions of it.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: functions.CompleteTeamReaders,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.TeamSetACL(args[0], args[1:], teamOpts)
+	},
+}
+
+var teamSignCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Sign a team vault created by an older version as its owner",
+	Long: `Sign a team vault created before team vaults were signed.
+
+You vouch for the current members, roles and entries, so check them with
+"team members" and "team ls" first. Owners and editors without a signing
+key can only read until they are added again with the key printed by
+"team pubkey".`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.TeamSign(teamOpts)
+	},
+}
+
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "List, restore and purge deleted passwords",
+	Long: `List, restore and purge deleted passwords.
+
+Deleted passwords are moved to the trash and can be restored until they are
+purged. They are purged automatically once they have been in the trash for
+longer than the retention, 30 days unless changed with "trash retention".`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.ShowTrash()
+	},
+}
+
+var trashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the passwords in the trash",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.ShowTrash()
+	},
+}
+
+var trashRestoreCmd = &cobra.Command{
+	Use:               "restore [source/username]",
+	Short:             "Restore a password from the trash",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: functions.CompleteTrashedEntries,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.RestoreFromTrash(args[0])
+	},
+}
+
+var trashPurgeCmd = &cobra.Command{
+	Use:               "purge [source/username]",
+	Short:             "Permanently delete a password in the trash, or all of them",
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: functions.CompleteTrashedEntries,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.PurgeTrash(optionalArg(args), deleteOpts)
 	},
 }
 
+var trashRetentionCmd = &cobra.Command{
+	Use:   "retention [days]",
+	Short: "Show or set after how many days the trash is purged (0 to keep forever)",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.TrashRetentionCommand(optionalArg(args))
+	},
+}
+
+var rotationCmd = &cobra.Command{
+	Use:   "rotation",
+	Short: "Set how often passwords of an entry or folder have to be rotated",
+	Long: `Set how often passwords have to be rotated, in days.
+
+A policy applies to an entry given as source/username, or to every entry in a
+folder and its subfolders; "/" is the whole vault. The policy of an entry wins
+over those of its folders, and a folder's over those of the folders above it.
+A password is due when it has not changed for that many days. Overdue passwords
+are listed by "due" and flagged in show, ls and search.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.ListRotation()
+	},
+}
+
+var rotationSetCmd = &cobra.Command{
+	Use:               "set [source/username|folder] [days]",
+	Short:             "Rotate the passwords of an entry or folder every given number of days",
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: functions.CompleteRotationTargets,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.SetRotation(args[0], args[1])
+	},
+}
+
+var rotationUnsetCmd = &cobra.Command{
+	Use:               "unset [source/username|folder]",
+	Short:             "Remove the rotation policy of an entry or folder",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: functions.CompleteRotationTargets,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.SetRotation(args[0], "0")
+	},
+}
+
+var rotationListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the rotation policies",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.ListRotation()
+	},
+}
+
+var rotationHookRemove bool
+
+var rotationHookCmd = &cobra.Command{
+	Use:   "hook [source/username] [executable]",
+	Short: "Show, set or remove the executable that rotates a password",
+	Long: `Set the executable "rotate" runs to change the password of an entry where it
+is used, such as a database user or an API key. Without an executable the hook
+is shown, --remove removes it. Hooks are not synced, they are paths on this
+machine.
+
+The hook gets the old and the new password on stdin, one per line, and the
+entry in FORTPASS_SOURCE, FORTPASS_USERNAME and FORTPASS_URL. It has to exit
+with 0 once the new password is in place.`,
+	Args:              cobra.RangeArgs(1, 2),
+	ValidArgsFunction: functions.CompleteEntryAndFile,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.RotationHookCommand(args[0], optionalArg(args[1:]), rotationHookRemove)
+	},
+}
+
+var rotateOpts functions.RotateOptions
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate [source/username]",
+	Short: "Change a password where it is used with its rotation hook",
+	Long: `Generate a new password for an entry and run its rotation hook (see
+"rotation hook") to change it where it is used. The vault is only updated when
+the hook succeeds, the old password is kept in the history. Failed rotations
+are recorded in the audit log, print the new password in case the hook
+changed it before failing and exit with status 1.
+
+  fortpass rotation hook db/app ./rotate-postgres-user.sh
+  fortpass rotate db/app`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: functions.CompleteEntries,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.RotatePassword(args[0], rotateOpts)
+	},
+}
+
+var policyOpts functions.PolicyOptions
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Set how passwords are generated for an entry or domain",
+	Long: `Set how passwords are generated for an entry given as source/username, or for
+the entries whose URL is on a domain or one of its subdomains. Sites that
+reject special characters or limit the length keep getting passwords they
+accept: update, rotate, the tui and the generator use the policy of the entry,
+else the one of its domain, else 16 characters of every class.
+
+  fortpass policy set github.com/johndoe --length 20 --no-special
+  fortpass policy set example.com --no-ambiguous`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.ListGeneratorPolicies()
+	},
+}
+
+var policySetCmd = &cobra.Command{
+	Use:               "set [source/username|domain]",
+	Short:             "Set the generator policy of an entry or domain",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: functions.CompleteEntriesAndDomains,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := policyOpts.GeneratorOptions()
+		functions.SetGeneratorPolicy(args[0], &opts)
+	},
+}
+
+var policyUnsetCmd = &cobra.Command{
+	Use:               "unset [source/username|domain]",
+	Short:             "Remove the generator policy of an entry or domain",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: functions.CompleteEntriesAndDomains,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.SetGeneratorPolicy(args[0], nil)
+	},
+}
+
+var policyShowCmd = &cobra.Command{
+	Use:               "show [source/username|domain]",
+	Short:             "Show how passwords are generated for an entry or domain",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: functions.CompleteEntriesAndDomains,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.ShowGeneratorPolicy(args[0])
+	},
+}
+
+var policyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the generator policies",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.ListGeneratorPolicies()
+	},
+}
+
+var dueOpts functions.DueOptions
+
+var dueCmd = &cobra.Command{
+	Use:   "due",
+	Short: "List the passwords overdue for rotation",
+	Long: `List the passwords that have not been changed for longer than their rotation
+policy allows, most overdue first. --within 14 also lists those due in the next
+two weeks; --format csv or json prints them for spreadsheets and scripts.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.ShowDue(dueOpts)
+	},
+}
+
+var logOpts functions.AuditLogOptions
+
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Show the audit log of vault accesses and changes",
+	Long: `Show the audit log of vault accesses and changes.
+
+Reading a password with get, search or team get, storing, updating, deleting,
+importing and exporting entries, and backups and restores are recorded with
+the time, OS user and host. Events are append-only and every event contains
+the hash of the one before it, signed with an audit key kept in the system
+keyring; "log verify" detects events that were changed, removed or inserted.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.ShowAuditLog(logOpts)
+	},
+}
+
+var logVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the audit log for tampering",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.VerifyAuditLog()
+	},
+}
+
+var restoreOpts functions.RestoreOptions
+
+var restoreBackupCmd = &cobra.Command{
+	Use:   "restore-backup [backup_file]",
+	Short: "Restore the password database from a backup",
+	Long: `Restore the password database from a backup.
+
+The backup is checked to be an intact fortpass database and a preview of the
+differences to the current vault is shown before anything is changed. Older
+backups are migrated to the current schema. The current vault is backed up
+first, so the restore can be rolled back.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.RestoreBackup(args[0], restoreOpts)
+	},
+}
+
+var (
+	importDBMerge bool
+	mergeOpts     functions.MergeOptions
+)
+
 var importDBCmd = &cobra.Command{
 	Use:   "importdb [db_file]",
 	Short: "Import a password database",
-	Args:  cobra.ExactArgs(1),
+	Long: `Import a password database.
+
+By default the current vault is replaced by the given database. With --merge
+the entries of the given database are merged into the current vault instead:
+entries stored in both are resolved by their last update and the losing
+password is kept in the password history. Use --ask-key when the database is
+encrypted with a different key; it is read without echo, or from stdin when
+stdin is not a terminal.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		if importDBMerge {
+			functions.MergeDatabase(args[0], mergeOpts)
+			return
+		}
 		functions.ImportDatabase(args[0])
 	},
 }
 
+var exportOpts functions.ExportOptions
+
+var exportCmd = &cobra.Command{
+	Use:   "export [destination]",
+	Short: "Export passwords to CSV, JSON, Bitwarden, KeePass or an encrypted archive",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.ExportPasswords(args[0], exportOpts)
+	},
+}
+
+var agentOpts functions.AgentOptions
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Cache the vault keys for the following commands",
+	Long: `Cache the vault keys for the following commands of your user.
+
+The agent reads the vault key and the audit key from the system keyring once,
+keeps them in memory that is never swapped to disk and hands them to fortpass
+commands over a Unix socket next to the vault. Commands use a running agent
+automatically and skip the keyring and the schema checks. Connections from
+other users are refused.
+
+The agent is only a cache: the keys stay in the system keyring, and commands
+read them from there again once the agent has stopped. Lock the system
+keyring to lock the vault.
+
+The agent wipes the keys and stops after --timeout without use, with
+"fortpass lock", or when it is interrupted. Run it in the background:
+
+  fortpass agent --timeout 30m &`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.RunAgent(agentOpts)
+	},
+}
+
+var agentStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether an agent caches the vault keys",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.AgentStatus()
+	},
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Stop the agent and wipe the keys it cached",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		functions.LockAgent()
+	},
+}
+
+var completionCmd = &cobra.Command{
+	Use:   "completion [bash|zsh|fish]",
+	Short: "Generate the shell completion script for bash, zsh or fish",
+	Long: `Generate the shell completion script for bash, zsh or fish. Besides commands
+and flags it completes entries, folders, tags and team names from the vault;
+only the columns stored in plain text are read for that, never passwords.
+
+  bash:  source <(fortpass completion bash)
+         or save it to /etc/bash_completion.d/fortpass
+  zsh:   fortpass completion zsh > "${fpath[1]}/_fortpass"
+  fish:  fortpass completion fish > ~/.config/fish/completions/fortpass.fish`,
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch args[0] {
+		case "bash":
+			err = rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			err = rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			err = rootCmd.GenFishCompletion(os.Stdout, true)
+		}
+		if err != nil {
+			fmt.Println(utils.StyleError.Render("❌ Error generating completion script: " + err.Error()))
+			os.Exit(1)
+		}
+	},
+}
+
+// skipTrashPurge are the commands that do not work on the vault: they
+// manage the agent, serve other machines, print keys or replace the vault.
+var skipTrashPurge = map[*cobra.Command]bool{
+	agentCmd:         true,
+	agentStatusCmd:   true,
+	lockCmd:          true,
+	serveCmd:         true,
+	completionCmd:    true,
+	teamPubkeyCmd:    true,
+	syncKeyCmd:       true,
+	backupKeyCmd:     true,
+	restoreBackupCmd: true,
+}
+
+// purgeExpiredTrash purges the expired trash before commands that work on
+// the vault.
+func purgeExpiredTrash(cmd *cobra.Command, args []string) {
+	if skipTrashPurge[cmd] || cmd.Name() == "help" {
+		return
+	}
+	functions.PurgeExpiredTrash()
+}
+
+// completing reports whether the shell runs fortpass for a completion
+// script or for completions, whose output must not contain anything else.
+func completing() bool {
+	if len(os.Args) < 2 {
+		return false
+	}
+	switch os.Args[1] {
+	case "completion", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+		return true
+	}
+	return false
+}
+
 func main() {
-	fmt.Println(utils.StyleHeading.Render("🔑 Password Manager CLI"))
+	if completing() {
+		if err := rootCmd.Execute(); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	// Output piped into other programs starts with the result.
+	if utils.IsTerminal(os.Stdout) {
+		fmt.Println(utils.StyleHeading.Render("🔑 Password Manager CLI"))
+	}
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(utils.StyleError.Render("Error: " + err.Error()))
 		os.Exit(1)
@@ -0,0 +1,282 @@
+package utils
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Operations of the agent protocol. Every connection sends one request and
+// reads one response, both as JSON.
+const (
+	AgentOpKey    = "key"
+	AgentOpStatus = "status"
+	AgentOpLock   = "lock"
+)
+
+// ErrAgentUnsupported is returned on platforms where the agent cannot check
+// who connects to it or keep the key out of swap.
+var ErrAgentUnsupported = errors.New("the agent is not supported on this platform")
+
+type agentRequest struct {
+	Op string `json:"op"`
+}
+
+// AgentResponse is the answer of the agent. Key and the hex encoded
+// AuditKey are only sent for AgentOpKey. DBPath and SchemaVersion tell which
+// database the key belongs to and the schema the agent migrated it to when
+// it started.
+type AgentResponse struct {
+	Key           string    `json:"key,omitempty"`
+	AuditKey      string    `json:"audit_key,omitempty"`
+	DBPath        string    `json:"db_path"`
+	SchemaVersion int       `json:"schema_version"`
+	LocksAt       time.Time `json:"locks_at"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// AgentSocketPath returns the Unix socket the agent of the vault listens
+// on, next to the database in a directory only its user can enter.
+func AgentSocketPath() string {
+	return filepath.Join(filepath.Dir(DBPath), "agent.sock")
+}
+
+// CallAgent sends op to the agent of the vault and returns its response. It
+// fails quickly when no agent is running, and refuses agents run by another
+// user.
+func CallAgent(op string) (AgentResponse, error) {
+	var resp AgentResponse
+	conn, err := net.DialTimeout("unix", AgentSocketPath(), time.Second)
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if uid, err := peerUID(conn.(*net.UnixConn)); err != nil {
+		return resp, err
+	} else if uid != os.Getuid() {
+		return resp, fmt.Errorf("agent is run by uid %d", uid)
+	}
+	if err := json.NewEncoder(conn).Encode(agentRequest{Op: op}); err != nil {
+		return resp, err
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// Agent caches the key of a vault and its audit key in memory that is
+// locked against swapping and hands them to the commands of the same user,
+// until it is locked or has not been used for its timeout. It only saves
+// reading the system keyring, which holds the keys all along.
+type Agent struct {
+	dbPath   string
+	timeout  time.Duration
+	listener *net.UnixListener
+
+	mu       sync.Mutex
+	key      []byte
+	auditKey []byte
+	timer    *time.Timer
+	locksAt  time.Time
+	locked   chan struct{}
+}
+
+// NewAgent starts listening on AgentSocketPath with EncryptionKey, the key
+// of the database at DBPath, and the audit key from the system keyring. The
+// keys are moved into locked memory and EncryptionKey is wiped. Only the
+// owner can connect to the socket.
+func NewAgent(timeout time.Duration) (*Agent, error) {
+	path := AgentSocketPath()
+	if _, err := CallAgent(AgentOpStatus); err == nil {
+		return nil, errors.New("an agent is already running, stop it with: fortpass lock")
+	}
+	// A socket left behind by an agent that was killed
+	os.Remove(path)
+
+	auditKey, err := KeyringSecret(auditKeyName)
+	if err != nil {
+		return nil, fmt.Errorf("reading audit key: %w", err)
+	}
+	defer wipe(auditKey)
+
+	a := &Agent{dbPath: DBPath, timeout: timeout, key: make([]byte, len(EncryptionKey)), auditKey: make([]byte, len(auditKey)), locked: make(chan struct{})}
+	if err := lockMemory(a.key); err != nil {
+		return nil, fmt.Errorf("locking the key in memory: %w", err)
+	}
+	if err := lockMemory(a.auditKey); err != nil {
+		unlockMemory(a.key)
+		return nil, fmt.Errorf("locking the audit key in memory: %w", err)
+	}
+	copy(a.key, EncryptionKey)
+	copy(a.auditKey, auditKey)
+	wipeString(&EncryptionKey)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err == nil {
+		err = os.Chmod(path, 0600)
+	}
+	if err != nil {
+		a.wipeKey()
+		if listener != nil {
+			listener.Close()
+		}
+		return nil, err
+	}
+	a.listener = listener
+	a.locksAt = time.Now().Add(timeout)
+	a.timer = time.AfterFunc(timeout, a.Lock)
+	return a, nil
+}
+
+// Serve answers requests until the agent is locked. Connections from other
+// users are refused and reported through refused.
+func (a *Agent) Serve(refused func(err error)) error {
+	for {
+		conn, err := a.listener.AcceptUnix()
+		if err != nil {
+			select {
+			case <-a.locked:
+				return nil
+			default:
+				return err
+			}
+		}
+		go a.handle(conn, refused)
+	}
+}
+
+func (a *Agent) handle(conn *net.UnixConn, refused func(err error)) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	uid, err := peerUID(conn)
+	if err == nil && uid != os.Getuid() {
+		err = fmt.Errorf("refused a connection from uid %d", uid)
+	}
+	if err != nil {
+		refused(err)
+		return
+	}
+
+	var req agentRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	a.mu.Lock()
+	resp := AgentResponse{DBPath: a.dbPath, SchemaVersion: SchemaVersion}
+	sendKey := false
+	switch {
+	case a.key == nil:
+		resp.Error = "the agent is locked"
+	case req.Op == AgentOpKey:
+		sendKey = true
+		a.timer.Reset(a.timeout)
+		a.locksAt = time.Now().Add(a.timeout)
+	case req.Op == AgentOpStatus, req.Op == AgentOpLock:
+	default:
+		resp.Error = "unknown operation " + req.Op
+	}
+	resp.LocksAt = a.locksAt
+	if !sendKey {
+		a.mu.Unlock()
+		json.NewEncoder(conn).Encode(resp)
+		if req.Op == AgentOpLock {
+			a.Lock()
+		}
+		return
+	}
+	msg, err := keyResponse(resp, a.key, a.auditKey)
+	a.mu.Unlock()
+	if err != nil {
+		json.NewEncoder(conn).Encode(AgentResponse{Error: err.Error()})
+		return
+	}
+	conn.Write(msg)
+	wipe(msg)
+	unlockMemory(msg)
+}
+
+// keyResponse encodes resp with key as its Key and auditKey as its AuditKey.
+// The keys are copied straight into a locked buffer, which the caller wipes
+// after sending it, and never into a string or a buffer of the JSON encoder.
+func keyResponse(resp AgentResponse, key, auditKey []byte) ([]byte, error) {
+	for _, c := range key {
+		if c < 0x20 || c == '"' || c == '\\' || c >= 0x80 {
+			return nil, errors.New("the key cannot be sent without escaping")
+		}
+	}
+	rest, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	auditHex := hex.EncodedLen(len(auditKey))
+	msg := make([]byte, 0, len(`{"key":"","audit_key":"",`)+len(key)+auditHex+len(rest)-1)
+	if err := lockMemory(msg[:cap(msg)]); err != nil {
+		return nil, fmt.Errorf("locking the response in memory: %w", err)
+	}
+	msg = append(msg, `{"key":"`...)
+	msg = append(msg, key...)
+	msg = append(msg, `","audit_key":"`...)
+	hex.Encode(msg[len(msg):len(msg)+auditHex], auditKey)
+	msg = msg[:len(msg)+auditHex]
+	msg = append(msg, `",`...)
+	// rest has no key and starts with {
+	msg = append(msg, rest[1:]...)
+	return msg, nil
+}
+
+// Lock wipes the keys and stops the agent. Commands read the keys from the
+// system keyring again afterwards.
+func (a *Agent) Lock() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.key == nil {
+		return
+	}
+	a.timer.Stop()
+	a.wipeKey()
+	close(a.locked)
+	// Closing the listener removes the socket
+	a.listener.Close()
+}
+
+// Locked is closed once the agent is locked.
+func (a *Agent) Locked() <-chan struct{} {
+	return a.locked
+}
+
+func (a *Agent) wipeKey() {
+	wipe(a.key)
+	unlockMemory(a.key)
+	a.key = nil
+	wipe(a.auditKey)
+	unlockMemory(a.auditKey)
+	a.auditKey = nil
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// wipeString overwrites the bytes of *s, which must have been built at run
+// time rather than be a constant, and empties it.
+func wipeString(s *string) {
+	if len(*s) > 0 {
+		wipe(unsafe.Slice(unsafe.StringData(*s), len(*s)))
+	}
+	*s = ""
+}
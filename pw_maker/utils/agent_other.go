@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package utils
+
+import "net"
+
+func peerUID(conn *net.UnixConn) (int, error) {
+	return 0, ErrAgentUnsupported
+}
+
+func lockMemory(b []byte) error {
+	return ErrAgentUnsupported
+}
+
+func unlockMemory(b []byte) {}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestKeyResponse(t *testing.T) {
+	key := []byte(strings.Repeat("0f", 32))
+	locksAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	auditKey := []byte{0xde, 0xad, 0xbe, 0xef}
+	msg, err := keyResponse(AgentResponse{DBPath: "/tmp/passwords.db", SchemaVersion: SchemaVersion, LocksAt: locksAt}, key, auditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlockMemory(msg)
+
+	var resp AgentResponse
+	if err := json.Unmarshal(msg, &resp); err != nil {
+		t.Fatalf("decoding %s: %v", msg, err)
+	}
+	if resp.Key != string(key) || resp.AuditKey != "deadbeef" || resp.DBPath != "/tmp/passwords.db" || resp.SchemaVersion != SchemaVersion || !resp.LocksAt.Equal(locksAt) {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+func TestKeyResponseRejectsUnescapedKey(t *testing.T) {
+	if _, err := keyResponse(AgentResponse{}, []byte(`ab"cd`), nil); err == nil {
+		t.Error("a key that needs escaping was spliced into the response")
+	}
+}
+
+func TestWipeString(t *testing.T) {
+	s := strings.Clone("secret")
+	data := unsafe.Slice(unsafe.StringData(s), len(s))
+	wipeString(&s)
+	if s != "" {
+		t.Errorf("string not emptied: %q", s)
+	}
+	if string(data) != "\x00\x00\x00\x00\x00\x00" {
+		t.Errorf("bytes not wiped: %q", data)
+	}
+}
@@ -0,0 +1,262 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditKeyName is the system keyring entry of the key the audit log is
+// signed with. It is kept out of the database, so whoever can write to the
+// database still cannot rewrite the chain.
+const auditKeyName = "audit_key"
+
+// agentAuditKey is the audit key handed out by a running agent, so audited
+// commands do not read the system keyring while it runs.
+var agentAuditKey []byte
+
+// AuditEvent is one row of the audit log. Every event stores the hash of the
+// event before it, so changing, removing or inserting events breaks the chain.
+// Hash is an HMAC with the audit key; events written before the audit key
+// was introduced have a plain SHA-256 hash.
+type AuditEvent struct {
+	ID        int64
+	CreatedAt string
+	Action    string
+	EntryID   int64
+	Entry     string
+	Details   string
+	User      string
+	Host      string
+	PrevHash  string
+	Hash      string
+}
+
+// Queryer is implemented by *sql.DB and *sql.Tx.
+type Queryer interface {
+	Execer
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// AuditFilter selects events of the audit log. Zero fields match everything.
+type AuditFilter struct {
+	Action string
+	Entry  string
+	User   string
+	Since  time.Time
+	Limit  int
+}
+
+// AuditVerification is the result of checking the audit log chain.
+type AuditVerification struct {
+	Events   int
+	Head     string
+	Problems []string
+}
+
+// Audit appends an event to the audit log. Pass the transaction of the
+// change being recorded, so the event is only logged when the change is
+// committed.
+func Audit(db Queryer, action string, entryID int64, entry, details string) error {
+	key := agentAuditKey
+	var err error
+	if key == nil {
+		key, err = KeyringSecret(auditKeyName)
+		if err != nil {
+			return fmt.Errorf("reading audit key: %w", err)
+		}
+	}
+
+	var head AuditEvent
+	err = db.QueryRow("SELECT id, created_at, action, entry_id, entry, details, os_user, host, prev_hash, hash FROM audit_log ORDER BY id DESC LIMIT 1").
+		Scan(&head.ID, &head.CreatedAt, &head.Action, &head.EntryID, &head.Entry, &head.Details, &head.User, &head.Host, &head.PrevHash, &head.Hash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if head.ID != 0 && head.computeHash(nil) == head.Hash {
+		// The first signed event covers the hash of the last unsigned one.
+		head, err = appendAuditEvent(db, key, head, "audit-key", 0, "", "events are signed with the audit key in the system keyring from here on")
+		if err != nil {
+			return err
+		}
+	}
+	_, err = appendAuditEvent(db, key, head, action, entryID, entry, details)
+	return err
+}
+
+// appendAuditEvent signs an event following head with key and inserts it.
+func appendAuditEvent(db Execer, key []byte, head AuditEvent, action string, entryID int64, entry, details string) (AuditEvent, error) {
+	event := AuditEvent{
+		ID:        head.ID + 1,
+		CreatedAt: FormatDBTime(time.Now()),
+		Action:    action,
+		EntryID:   entryID,
+		Entry:     entry,
+		Details:   details,
+		User:      auditUser(),
+		PrevHash:  head.Hash,
+	}
+	event.Host, _ = os.Hostname()
+	event.Hash = event.computeHash(key)
+
+	// prev_hash is unique, so two processes logging at the same time cannot
+	// fork the chain; the second one fails instead.
+	_, err := db.Exec(`INSERT INTO audit_log (id, created_at, action, entry_id, entry, details, os_user, host, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.ID, event.CreatedAt, event.Action, event.EntryID, event.Entry, event.Details, event.User, event.Host, event.PrevHash, event.Hash)
+	if err != nil {
+		return event, fmt.Errorf("writing audit log: %w", err)
+	}
+	return event, nil
+}
+
+func auditUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// computeHash returns the HMAC of the event with key, or its SHA-256 hash
+// without a key.
+func (e AuditEvent) computeHash(key []byte) string {
+	fields := []string{
+		e.PrevHash,
+		strconv.FormatInt(e.ID, 10),
+		e.CreatedAt,
+		e.Action,
+		strconv.FormatInt(e.EntryID, 10),
+		e.Entry,
+		e.Details,
+		e.User,
+		e.Host,
+	}
+	data := []byte(strings.Join(fields, "\x00"))
+	if key == nil {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ReadAuditLog returns the events matching filter, oldest first. With a limit
+// only the most recent events are returned.
+func ReadAuditLog(filter AuditFilter) ([]AuditEvent, error) {
+	query := "SELECT id, created_at, action, entry_id, entry, details, os_user, host, prev_hash, hash FROM audit_log WHERE 1 = 1"
+	var args []any
+	if filter.Action != "" {
+		query += " AND action = ?"
+		args = append(args, filter.Action)
+	}
+	if filter.Entry != "" {
+		query += " AND entry LIKE ?"
+		args = append(args, "%"+filter.Entry+"%")
+	}
+	if filter.User != "" {
+		query += " AND os_user = ?"
+		args = append(args, filter.User)
+	}
+	if !filter.Since.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, FormatDBTime(filter.Since))
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	events, err := queryAuditLog(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// VerifyAuditLog recomputes the hash chain of the audit log and reports every
+// event that was changed, and every gap where events were removed or
+// inserted. Removing the newest events cannot be detected from the log
+// itself; compare the reported head with one noted down earlier for that.
+//
+// Unsigned events are only accepted before the first signed one, which
+// covers the hash of the last unsigned event, so they cannot be changed or
+// added later either.
+func VerifyAuditLog() (AuditVerification, error) {
+	var result AuditVerification
+	key := agentAuditKey
+	var err error
+	if key == nil {
+		key, err = LookupKeyringSecret(auditKeyName)
+		if err != nil {
+			return result, fmt.Errorf("reading audit key: %w", err)
+		}
+	}
+	events, err := queryAuditLog("SELECT id, created_at, action, entry_id, entry, details, os_user, host, prev_hash, hash FROM audit_log ORDER BY id")
+	if err != nil {
+		return result, err
+	}
+
+	prev := AuditEvent{}
+	signed, unverifiable := false, 0
+	for _, e := range events {
+		switch {
+		case e.PrevHash != prev.Hash && prev.ID == 0:
+			result.Problems = append(result.Problems, fmt.Sprintf("event %d: the events before it were removed", e.ID))
+		case e.PrevHash != prev.Hash:
+			result.Problems = append(result.Problems, fmt.Sprintf("event %d: does not follow event %d, events were removed or inserted", e.ID, prev.ID))
+		}
+		switch {
+		case key != nil && hmac.Equal([]byte(e.computeHash(key)), []byte(e.Hash)):
+			signed = true
+		case !signed && e.computeHash(nil) == e.Hash:
+			// Written before the audit key was introduced
+		case key == nil:
+			unverifiable++
+		case signed:
+			result.Problems = append(result.Problems, fmt.Sprintf("event %d: content does not match its signature, the event was changed", e.ID))
+		default:
+			result.Problems = append(result.Problems, fmt.Sprintf("event %d: content does not match its hash, the event was changed", e.ID))
+		}
+		prev = e
+	}
+	switch {
+	case unverifiable > 0:
+		result.Problems = append(result.Problems, fmt.Sprintf("%d events are signed with an audit key that is not in the system keyring", unverifiable))
+	case key != nil && !signed && len(events) > 0:
+		result.Problems = append(result.Problems, "no event is signed with the audit key, the log was rewritten")
+	}
+	result.Events = len(events)
+	result.Head = prev.Hash
+	return result, nil
+}
+
+func queryAuditLog(query string, args ...any) ([]AuditEvent, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.Action, &e.EntryID, &e.Entry, &e.Details, &e.User, &e.Host, &e.PrevHash, &e.Hash)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
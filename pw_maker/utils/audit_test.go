@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+// newTestAuditLog opens an empty vault as DB and writes the given number of
+// signed events.
+func newTestAuditLog(t *testing.T, events int) {
+	t.Helper()
+	keyring.MockInit()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "passwords.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if err := MigrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	DB = db
+	// Whoever tampers with the log can write to the database and drop the
+	// triggers that keep it append-only.
+	execAudit(t, "DROP TRIGGER audit_log_no_update")
+	execAudit(t, "DROP TRIGGER audit_log_no_delete")
+
+	for i := 0; i < events; i++ {
+		if err := Audit(DB, "get", int64(i+1), "github.com/alice", "copied"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func auditEvent(t *testing.T, id int64) AuditEvent {
+	t.Helper()
+	events, err := queryAuditLog("SELECT id, created_at, action, entry_id, entry, details, os_user, host, prev_hash, hash FROM audit_log WHERE id = ?", id)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("reading event %d: %v", id, err)
+	}
+	return events[0]
+}
+
+func insertAuditEvent(t *testing.T, e AuditEvent) {
+	t.Helper()
+	_, err := DB.Exec(`INSERT INTO audit_log (id, created_at, action, entry_id, entry, details, os_user, host, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.CreatedAt, e.Action, e.EntryID, e.Entry, e.Details, e.User, e.Host, e.PrevHash, e.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func execAudit(t *testing.T, query string, args ...any) {
+	t.Helper()
+	if _, err := DB.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+func verifyAudit(t *testing.T) AuditVerification {
+	t.Helper()
+	result, err := VerifyAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func wantAuditProblems(t *testing.T, result AuditVerification, want ...string) {
+	t.Helper()
+	if len(result.Problems) != len(want) {
+		t.Fatalf("got problems %q, want %q", result.Problems, want)
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(result.Problems[i], w) {
+			t.Errorf("problem %d is %q, want %q", i, result.Problems[i], w)
+		}
+	}
+}
+
+func TestVerifyAuditLogIntact(t *testing.T) {
+	newTestAuditLog(t, 3)
+	result := verifyAudit(t)
+	wantAuditProblems(t, result)
+	if result.Events != 3 || result.Head != auditEvent(t, 3).Hash {
+		t.Errorf("got %d events with head %s", result.Events, result.Head)
+	}
+}
+
+func TestVerifyAuditLogChangedEvent(t *testing.T) {
+	newTestAuditLog(t, 3)
+	execAudit(t, "UPDATE audit_log SET details = 'revealed' WHERE id = 2")
+	wantAuditProblems(t, verifyAudit(t), "event 2: content does not match its signature")
+}
+
+func TestVerifyAuditLogRemovedEvent(t *testing.T) {
+	newTestAuditLog(t, 3)
+	execAudit(t, "DELETE FROM audit_log WHERE id = 2")
+	wantAuditProblems(t, verifyAudit(t), "event 3: does not follow event 1")
+}
+
+func TestVerifyAuditLogInsertedEvent(t *testing.T) {
+	newTestAuditLog(t, 3)
+	// Make room after event 1 and chain a forged event into the gap. Without
+	// the audit key it can only be hashed, and the events after it no longer
+	// match their signatures.
+	execAudit(t, "UPDATE audit_log SET id = id + 100 WHERE id > 1")
+	execAudit(t, "UPDATE audit_log SET id = id - 99 WHERE id > 100")
+	forged := AuditEvent{ID: 2, CreatedAt: "2026-01-01 10:00:00", Action: "get", Entry: "mail/bob", PrevHash: auditEvent(t, 1).Hash}
+	forged.Hash = forged.computeHash(nil)
+	execAudit(t, "UPDATE audit_log SET prev_hash = ? WHERE id = 3", forged.Hash)
+	insertAuditEvent(t, forged)
+
+	wantAuditProblems(t, verifyAudit(t),
+		"event 2: content does not match its signature",
+		"event 3: content does not match its signature",
+		"event 4: content does not match its signature")
+}
+
+func TestVerifyAuditLogUnsignedAfterSigned(t *testing.T) {
+	newTestAuditLog(t, 2)
+	appended := AuditEvent{ID: 3, CreatedAt: "2026-01-01 10:00:00", Action: "get", Entry: "mail/bob", PrevHash: auditEvent(t, 2).Hash}
+	appended.Hash = appended.computeHash(nil)
+	insertAuditEvent(t, appended)
+	wantAuditProblems(t, verifyAudit(t), "event 3: content does not match its signature")
+}
+
+func TestAuditSignsAfterUnsignedEvents(t *testing.T) {
+	newTestAuditLog(t, 0)
+	prev := ""
+	for id := int64(1); id <= 2; id++ {
+		e := AuditEvent{ID: id, CreatedAt: "2025-01-01 10:00:00", Action: "add", Entry: "github.com/alice", PrevHash: prev}
+		e.Hash = e.computeHash(nil)
+		insertAuditEvent(t, e)
+		prev = e.Hash
+	}
+
+	if err := Audit(DB, "get", 1, "github.com/alice", "copied"); err != nil {
+		t.Fatal(err)
+	}
+	if marker := auditEvent(t, 3); marker.Action != "audit-key" || marker.PrevHash != prev {
+		t.Errorf("event 3 is %+v, want the audit-key marker after the unsigned events", marker)
+	}
+	if e := auditEvent(t, 4); e.Action != "get" {
+		t.Errorf("event 4 is %+v, want the get event", e)
+	}
+	wantAuditProblems(t, verifyAudit(t))
+
+	// Events before the first signed one are still checked against their hash.
+	execAudit(t, "UPDATE audit_log SET details = 'changed' WHERE id = 1")
+	wantAuditProblems(t, verifyAudit(t), "event 1: content does not match its hash")
+}
+
+func TestAuditUsesAgentKey(t *testing.T) {
+	newTestAuditLog(t, 0)
+	agentAuditKey = make([]byte, 32)
+	agentAuditKey[0] = 1
+	t.Cleanup(func() { agentAuditKey = nil })
+	// Audited commands must not need the keyring while an agent runs.
+	keyring.MockInitWithError(errors.New("keyring locked"))
+	t.Cleanup(keyring.MockInit)
+
+	if err := Audit(DB, "get", 1, "github.com/alice", "copied"); err != nil {
+		t.Fatal(err)
+	}
+	if e := auditEvent(t, 1); e.Hash != e.computeHash(agentAuditKey) {
+		t.Error("the event is not signed with the key of the agent")
+	}
+	wantAuditProblems(t, verifyAudit(t))
+}
@@ -0,0 +1,232 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const backupTimeFormat = "20060102_150405"
+
+// Retention decides which backups survive pruning: the newest Keep backups,
+// plus the newest backup of each of the last Daily days and Weekly weeks that
+// have backups. Days and weeks without a backup do not count, so a machine
+// that was switched off for a while keeps its older backups.
+type Retention struct {
+	Keep   int
+	Daily  int
+	Weekly int
+}
+
+var DefaultRetention = Retention{Keep: 10, Daily: 7, Weekly: 4}
+
+type BackupFile struct {
+	Path      string
+	CreatedAt time.Time
+	Size      int64
+}
+
+// BackupDir is where automatic backups are stored.
+func BackupDir() string {
+	return filepath.Join(filepath.Dir(DBPath), "backups")
+}
+
+// BackupName returns the timestamped base name of a backup taken at t,
+// without extension.
+func BackupName(t time.Time) string {
+	return "fortpass_" + t.Format(backupTimeFormat)
+}
+
+// NewBackupPath returns a timestamped, not yet existing backup path in dir.
+// reason is appended to tell automatic backups apart.
+func NewBackupPath(dir, reason string) string {
+	name := BackupName(time.Now())
+	if reason != "" {
+		name += "_" + reason
+	}
+
+	path := filepath.Join(dir, name+".db")
+	for n := 2; ; n++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s_%d.db", name, n))
+	}
+}
+
+// CreateBackup writes a consistent copy of the open vault to destination
+// using VACUUM INTO, so it works while the database is in use and includes
+// anything still in the journal. The copy is verified before it is moved
+// into place.
+func CreateBackup(destination string) error {
+	if err := os.MkdirAll(filepath.Dir(destination), 0700); err != nil {
+		return err
+	}
+
+	tmp := destination + ".tmp"
+	os.Remove(tmp)
+	if _, err := DB.Exec("VACUUM INTO ?", tmp); err != nil {
+		return fmt.Errorf("writing backup: %w", err)
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := VerifyDatabase(tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("verifying backup: %w", err)
+	}
+	return os.Rename(tmp, destination)
+}
+
+// VerifyDatabase opens a database file with the vault key and runs SQLite's
+// integrity check on it.
+func VerifyDatabase(path string) error {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&_pragma_key=%s", path, EncryptionKey))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM passwords").Scan(&count); err != nil {
+		return fmt.Errorf("not a fortpass database: %w", err)
+	}
+	return nil
+}
+
+// AutoBackup takes a timestamped backup into BackupDir before a destructive
+// operation and prunes old backups with the default retention.
+func AutoBackup(reason string) (string, error) {
+	destination := NewBackupPath(BackupDir(), reason)
+	if err := CreateBackup(destination); err != nil {
+		return "", err
+	}
+	if _, err := PruneBackups(BackupDir(), DefaultRetention); err != nil {
+		return destination, err
+	}
+	return destination, nil
+}
+
+// ParseBackupTime reads the timestamp from a backup file name created by
+// NewBackupPath, falling back to fallback for other names.
+func ParseBackupTime(name string, fallback time.Time) time.Time {
+	stamp := strings.TrimPrefix(filepath.Base(name), "fortpass_")
+	if len(stamp) >= len(backupTimeFormat) {
+		if t, err := time.ParseInLocation(backupTimeFormat, stamp[:len(backupTimeFormat)], time.Local); err == nil {
+			return t
+		}
+	}
+	return fallback
+}
+
+// ListBackups returns the fortpass backups in dir, newest first.
+func ListBackups(dir string) ([]BackupFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var backups []BackupFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "fortpass_") || !strings.HasSuffix(name, ".db") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		createdAt := ParseBackupTime(name, info.ModTime())
+		backups = append(backups, BackupFile{Path: filepath.Join(dir, name), CreatedAt: createdAt, Size: info.Size()})
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// SelectExpiredBackups applies the retention policy to backups sorted newest
+// first and returns the ones that should be removed.
+func SelectExpiredBackups(backups []BackupFile, retention Retention) []BackupFile {
+	keep := make(map[int]bool)
+	for i := 0; i < retention.Keep && i < len(backups); i++ {
+		keep[i] = true
+	}
+
+	keepPeriods := func(limit int, period func(time.Time) string) {
+		seen := make(map[string]bool)
+		for i, b := range backups {
+			if len(seen) >= limit {
+				break
+			}
+			p := period(b.CreatedAt)
+			if !seen[p] {
+				seen[p] = true
+				keep[i] = true
+			}
+		}
+	}
+	keepPeriods(retention.Daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepPeriods(retention.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%02d", year, week)
+	})
+
+	var expired []BackupFile
+	for i, b := range backups {
+		if !keep[i] {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
+
+// PruneBackups removes the backups in dir that fall outside of retention.
+func PruneBackups(dir string, retention Retention) ([]BackupFile, error) {
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := SelectExpiredBackups(backups, retention)
+	for _, b := range expired {
+		if err := os.Remove(b.Path); err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+// ReplaceVault closes the open vault, moves the database file at path into
+// its place and opens it again. path should be on the same filesystem as the
+// vault so the move is atomic.
+func ReplaceVault(path string) error {
+	DB.Close()
+
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		os.Remove(DBPath + suffix)
+	}
+	if err := os.Rename(path, DBPath); err != nil {
+		InitDB()
+		return err
+	}
+
+	InitDB()
+	return nil
+}
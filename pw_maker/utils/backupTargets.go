@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupTarget stores encrypted backups in a remote location.
+type BackupTarget interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	List() ([]BackupFile, error)
+	Delete(name string) error
+}
+
+// OpenBackupTarget returns the target for a backup URL. Supported are local
+// directories (a path or file:///path), sftp://user@host/path and
+// s3://bucket/prefix with optional endpoint and region query parameters for
+// S3-compatible storage.
+func OpenBackupTarget(rawURL string) (BackupTarget, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("no backup target given and no default target configured")
+	}
+	if !strings.Contains(rawURL, "://") {
+		return localTarget{dir: rawURL}, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup target %q: %w", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return localTarget{dir: u.Path}, nil
+	case "sftp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("sftp target needs a host, e.g. sftp://user@host/backups")
+		}
+		return sftpTarget{url: u}, nil
+	case "s3":
+		return newS3Target(u)
+	default:
+		return nil, fmt.Errorf("unsupported backup target scheme %q (use a directory, file://, sftp:// or s3://)", u.Scheme)
+	}
+}
+
+func sortBackups(backups []BackupFile) []BackupFile {
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].CreatedAt.Equal(backups[j].CreatedAt) {
+			return backups[i].Path > backups[j].Path
+		}
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups
+}
+
+func isRemoteBackupName(name string) bool {
+	return strings.HasPrefix(name, "fortpass_") && strings.HasSuffix(name, ".db.enc")
+}
+
+type localTarget struct {
+	dir string
+}
+
+func (t localTarget) Put(name string, data []byte) error {
+	if err := os.MkdirAll(t.dir, 0700); err != nil {
+		return err
+	}
+	tmp := filepath.Join(t.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(t.dir, name))
+}
+
+func (t localTarget) Get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(t.dir, filepath.Base(name)))
+}
+
+func (t localTarget) List() ([]BackupFile, error) {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var backups []BackupFile
+	for _, entry := range entries {
+		if entry.IsDir() || !isRemoteBackupName(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, BackupFile{
+			Path:      entry.Name(),
+			CreatedAt: ParseBackupTime(entry.Name(), info.ModTime()),
+			Size:      info.Size(),
+		})
+	}
+	return sortBackups(backups), nil
+}
+
+func (t localTarget) Delete(name string) error {
+	return os.Remove(filepath.Join(t.dir, filepath.Base(name)))
+}
+
+// sftpTarget drives the OpenSSH sftp client in batch mode, so the user's ssh
+// configuration, keys and agent are used for authentication.
+type sftpTarget struct {
+	url *url.URL
+}
+
+func (t sftpTarget) remoteDir() string {
+	dir := t.url.Path
+	if dir == "" {
+		dir = "."
+	}
+	return strings.TrimPrefix(dir, "/~/")
+}
+
+func (t sftpTarget) run(commands string) (string, error) {
+	host := t.url.Hostname()
+	if t.url.User != nil {
+		host = t.url.User.Username() + "@" + host
+	}
+	args := []string{"-b", "-", "-q"}
+	if port := t.url.Port(); port != "" {
+		args = append(args, "-P", port)
+	}
+	args = append(args, host)
+
+	cmd := exec.Command("sftp", args...)
+	cmd.Stdin = strings.NewReader(commands)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("sftp: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func sftpQuote(p string) string {
+	return `"` + strings.ReplaceAll(p, `"`, `\"`) + `"`
+}
+
+func (t sftpTarget) Put(name string, data []byte) error {
+	tmp, err := os.CreateTemp("", "fortpass-backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+
+	remote := path.Join(t.remoteDir(), name)
+	_, err = t.run(fmt.Sprintf("-mkdir %s\nput %s %s\n", sftpQuote(t.remoteDir()), sftpQuote(tmp.Name()), sftpQuote(remote)))
+	return err
+}
+
+func (t sftpTarget) Get(name string) ([]byte, error) {
+	tmp, err := os.CreateTemp("", "fortpass-backup-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	remote := path.Join(t.remoteDir(), path.Base(name))
+	if _, err := t.run(fmt.Sprintf("get %s %s\n", sftpQuote(remote), sftpQuote(tmp.Name()))); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(tmp.Name())
+}
+
+func (t sftpTarget) List() ([]BackupFile, error) {
+	out, err := t.run(fmt.Sprintf("ls -1 %s\n", sftpQuote(t.remoteDir())))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []BackupFile
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		name := path.Base(strings.TrimSpace(scanner.Text()))
+		if !isRemoteBackupName(name) {
+			continue
+		}
+		backups = append(backups, BackupFile{Path: name, CreatedAt: ParseBackupTime(name, time.Time{})})
+	}
+	return sortBackups(backups), scanner.Err()
+}
+
+func (t sftpTarget) Delete(name string) error {
+	_, err := t.run(fmt.Sprintf("rm %s\n", sftpQuote(path.Join(t.remoteDir(), path.Base(name)))))
+	return err
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSelectExpiredBackups(t *testing.T) {
+	at := func(day, hour int) BackupFile {
+		created := time.Date(2026, 1, day, hour, 0, 0, 0, time.UTC)
+		return BackupFile{Path: created.Format("Jan 2 15h"), CreatedAt: created}
+	}
+	// Newest first. January 5 2026 is a Monday, so the ISO weeks start on
+	// the 5th, 12th and 19th.
+	backups := []BackupFile{
+		at(20, 18), at(20, 9), at(19, 9), at(14, 9), at(12, 9), at(11, 9), at(6, 9), at(2, 9),
+	}
+
+	tests := []struct {
+		name      string
+		retention Retention
+		expired   []string
+	}{
+		{
+			name:      "newest only",
+			retention: Retention{Keep: 3},
+			expired:   []string{"Jan 14 09h", "Jan 12 09h", "Jan 11 09h", "Jan 6 09h", "Jan 2 09h"},
+		},
+		{
+			// Days without backups, like the 15th to the 18th, do not count.
+			name:      "days with backups",
+			retention: Retention{Daily: 3},
+			expired:   []string{"Jan 20 09h", "Jan 12 09h", "Jan 11 09h", "Jan 6 09h", "Jan 2 09h"},
+		},
+		{
+			name:      "weeks with backups",
+			retention: Retention{Weekly: 3},
+			expired:   []string{"Jan 20 09h", "Jan 19 09h", "Jan 12 09h", "Jan 6 09h", "Jan 2 09h"},
+		},
+		{
+			name:      "combined",
+			retention: Retention{Keep: 1, Daily: 2, Weekly: 4},
+			expired:   []string{"Jan 20 09h", "Jan 12 09h", "Jan 6 09h"},
+		},
+		{
+			name:      "more periods than backups",
+			retention: Retention{Keep: 1, Daily: 30, Weekly: 10},
+			expired:   []string{"Jan 20 09h"},
+		},
+		{
+			name:      "nothing kept",
+			retention: Retention{},
+			expired:   []string{"Jan 20 18h", "Jan 20 09h", "Jan 19 09h", "Jan 14 09h", "Jan 12 09h", "Jan 11 09h", "Jan 6 09h", "Jan 2 09h"},
+		},
+	}
+	for _, tt := range tests {
+		var expired []string
+		for _, b := range SelectExpiredBackups(backups, tt.retention) {
+			expired = append(expired, b.Path)
+		}
+		if !reflect.DeepEqual(expired, tt.expired) {
+			t.Errorf("%s: expired %v, want %v", tt.name, expired, tt.expired)
+		}
+	}
+}
@@ -0,0 +1,569 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// BrowserRevealTimeout is how long a revealed password stays visible.
+	BrowserRevealTimeout = 10 * time.Second
+	// BrowserClipboardTimeout is how long a copied password stays in the
+	// clipboard.
+	BrowserClipboardTimeout = 45 * time.Second
+)
+
+// BrowserActions are the vault operations of the browser. They are passed in
+// by the caller, the model itself only handles the interface.
+type BrowserActions struct {
+	Load func() ([]Credential, error)
+	// Save stores a changed entry, or a new one when its ID is 0, and
+	// returns its ID.
+	Save     func(c Credential) (int64, error)
+	Delete   func(c Credential) error
+	Generate func(c Credential) (string, error)
+	// Accessed records that the password of an entry was copied or revealed.
+	Accessed func(c Credential, how string) error
+}
+
+type browserMode int
+
+const (
+	browseMode browserMode = iota
+	editMode
+	confirmDeleteMode
+	confirmGenerateMode
+	helpMode
+)
+
+// The fields of the edit form, in order.
+const (
+	fieldSource = iota
+	fieldUsername
+	fieldPassword
+	fieldURL
+	fieldFolder
+	fieldCount
+)
+
+var fieldLabels = []string{"Source", "Username", "Password", "URL", "Folder"}
+
+type hideRevealMsg struct{ seq int }
+
+type clearClipboardMsg struct{ seq int }
+
+type credentialItem struct{ Credential }
+
+func (i credentialItem) Title() string {
+	return i.Source + "/" + i.Username
+}
+
+func (i credentialItem) Description() string {
+	if i.Folder == "" {
+		return i.URL
+	}
+	return "📁 " + i.Folder + "  " + i.URL
+}
+
+func (i credentialItem) FilterValue() string {
+	return i.Folder + " " + i.Source + " " + i.Username + " " + i.URL
+}
+
+// BrowserModel is the full-screen vault browser: a list of entries next to
+// the details of the selected one.
+type BrowserModel struct {
+	actions BrowserActions
+	list    list.Model
+	mode    browserMode
+
+	// The edit form, editing.ID is 0 for a new entry.
+	inputs  []textinput.Model
+	editing Credential
+	focus   int
+
+	revealed  int64
+	revealSeq int
+
+	clipboardSecret string
+	clipboardSeq    int
+
+	status    string
+	statusErr bool
+	width     int
+	height    int
+}
+
+// NewBrowserModel loads the vault and returns the browser for it.
+func NewBrowserModel(actions BrowserActions) (BrowserModel, error) {
+	delegate := list.NewDefaultDelegate()
+	delegate.Styles.SelectedTitle = delegate.Styles.SelectedTitle.
+		Foreground(lipgloss.Color("#FF00FF")).
+		BorderForeground(lipgloss.Color("#FF00FF"))
+	delegate.Styles.SelectedDesc = delegate.Styles.SelectedDesc.
+		Foreground(lipgloss.Color("#FF00FF")).
+		BorderForeground(lipgloss.Color("#FF00FF"))
+
+	m := BrowserModel{actions: actions}
+	m.list = list.New(nil, delegate, 0, 0)
+	m.list.Title = "Passwords"
+	m.list.SetStatusBarItemName("password", "passwords")
+	m.list.SetShowHelp(false)
+	m.list.DisableQuitKeybindings()
+	m.list.Styles.Title = m.list.Styles.Title.
+		Background(lipgloss.Color("#25A065")).
+		Foreground(lipgloss.Color("#FFFFFF"))
+	m.list.SetSize(50, 20)
+
+	if err := m.reload(0); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+func (m BrowserModel) Init() tea.Cmd {
+	return nil
+}
+
+// reload reads the entries again and selects the one with the given ID, or
+// keeps the current position.
+func (m *BrowserModel) reload(selectID int64) error {
+	credentials, err := m.actions.Load()
+	if err != nil {
+		return err
+	}
+	credentials = SortCredentialsByFolder(credentials)
+
+	index := m.list.Index()
+	items := make([]list.Item, len(credentials))
+	for i, c := range credentials {
+		items[i] = credentialItem{c}
+		if selectID != 0 && c.ID == selectID {
+			index = i
+		}
+	}
+	m.list.SetItems(items)
+	if index >= len(items) {
+		index = len(items) - 1
+	}
+	m.list.Select(max(index, 0))
+	return nil
+}
+
+func (m BrowserModel) selected() (Credential, bool) {
+	item, ok := m.list.SelectedItem().(credentialItem)
+	return item.Credential, ok
+}
+
+func (m *BrowserModel) setStatus(status string, isErr bool) {
+	m.status = status
+	m.statusErr = isErr
+}
+
+func (m BrowserModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.list.SetSize(m.listWidth(), max(msg.Height-3, 5))
+		return m, nil
+	case hideRevealMsg:
+		if msg.seq == m.revealSeq {
+			m.revealed = 0
+		}
+		return m, nil
+	case clearClipboardMsg:
+		if msg.seq == m.clipboardSeq {
+			m.clearClipboard()
+		}
+		return m, nil
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			m.clearClipboard()
+			return m, tea.Quit
+		}
+		switch m.mode {
+		case helpMode:
+			m.mode = browseMode
+			return m, nil
+		case editMode:
+			return m.updateEdit(msg)
+		case confirmDeleteMode, confirmGenerateMode:
+			return m.updateConfirm(msg)
+		}
+		if m.list.FilterState() != list.Filtering {
+			m.setStatus("", false)
+			if model, cmd, handled := m.handleBrowseKey(msg); handled {
+				return model, cmd
+			}
+		}
+	}
+
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(msg)
+	return m, cmd
+}
+
+// handleBrowseKey handles the keys of the browser that are not list
+// navigation.
+func (m BrowserModel) handleBrowseKey(msg tea.KeyMsg) (tea.Model, tea.Cmd, bool) {
+	switch msg.String() {
+	case "q":
+		m.clearClipboard()
+		return m, tea.Quit, true
+	case "?":
+		m.mode = helpMode
+		return m, nil, true
+	case "n":
+		m.startEdit(Credential{})
+		return m, textinput.Blink, true
+	}
+
+	c, ok := m.selected()
+	if !ok {
+		return m, nil, false
+	}
+	switch msg.String() {
+	case "c":
+		return m.copyPassword(c)
+	case "u":
+		m.copyValue(c.Username, "Username")
+		return m, nil, true
+	case "o":
+		if c.URL == "" {
+			m.setStatus("No URL stored for "+c.Source+"/"+c.Username, true)
+			return m, nil, true
+		}
+		m.copyValue(c.URL, "URL")
+		return m, nil, true
+	case "r", "enter":
+		if m.revealed == c.ID {
+			m.revealed = 0
+			return m, nil, true
+		}
+		if err := m.actions.Accessed(c, "revealed"); err != nil {
+			m.setStatus("Error writing audit log: "+err.Error(), true)
+			return m, nil, true
+		}
+		m.revealed = c.ID
+		m.revealSeq++
+		seq := m.revealSeq
+		return m, tea.Tick(BrowserRevealTimeout, func(time.Time) tea.Msg { return hideRevealMsg{seq} }), true
+	case "e":
+		m.startEdit(c)
+		return m, textinput.Blink, true
+	case "g":
+		m.mode = confirmGenerateMode
+		return m, nil, true
+	case "d":
+		m.mode = confirmDeleteMode
+		return m, nil, true
+	}
+	return m, nil, false
+}
+
+func (m BrowserModel) copyPassword(c Credential) (tea.Model, tea.Cmd, bool) {
+	if err := clipboard.WriteAll(c.Password); err != nil {
+		m.setStatus("Failed to copy password to clipboard: "+err.Error(), true)
+		return m, nil, true
+	}
+	m.clipboardSecret = c.Password
+	m.clipboardSeq++
+	seq := m.clipboardSeq
+	clear := tea.Tick(BrowserClipboardTimeout, func(time.Time) tea.Msg { return clearClipboardMsg{seq} })
+	if err := m.actions.Accessed(c, "copied"); err != nil {
+		m.setStatus("Error writing audit log: "+err.Error(), true)
+		return m, clear, true
+	}
+	m.setStatus(fmt.Sprintf("📋 Password for %s/%s copied, cleared in %d seconds or when you quit", c.Source, c.Username, int(BrowserClipboardTimeout.Seconds())), false)
+	return m, clear, true
+}
+
+func (m *BrowserModel) copyValue(value, label string) {
+	if err := clipboard.WriteAll(value); err != nil {
+		m.setStatus("Failed to copy "+strings.ToLower(label)+" to clipboard: "+err.Error(), true)
+		return
+	}
+	// The copied password is no longer in the clipboard.
+	m.clipboardSecret = ""
+	m.setStatus("📋 "+label+" copied to clipboard", false)
+}
+
+// clearClipboard clears the clipboard if it still holds a copied password.
+func (m *BrowserModel) clearClipboard() {
+	if m.clipboardSecret == "" {
+		return
+	}
+	if current, err := clipboard.ReadAll(); err == nil && current == m.clipboardSecret {
+		clipboard.WriteAll("")
+	}
+	m.clipboardSecret = ""
+}
+
+func (m *BrowserModel) startEdit(c Credential) {
+	m.mode = editMode
+	m.editing = c
+	m.focus = 0
+	m.inputs = make([]textinput.Model, fieldCount)
+	values := []string{c.Source, c.Username, c.Password, c.URL, c.Folder}
+	for i := range m.inputs {
+		t := textinput.New()
+		t.Prompt = ""
+		t.CharLimit = 256
+		t.SetValue(values[i])
+		if i == fieldPassword {
+			t.EchoMode = textinput.EchoPassword
+			t.Placeholder = "ctrl+g to generate"
+		}
+		m.inputs[i] = t
+	}
+	m.inputs[0].Focus()
+}
+
+func (m BrowserModel) updateEdit(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.mode = browseMode
+		m.setStatus("", false)
+		return m, nil
+	case "tab", "down", "shift+tab", "up":
+		if msg.String() == "tab" || msg.String() == "down" {
+			m.focus = (m.focus + 1) % fieldCount
+		} else {
+			m.focus = (m.focus + fieldCount - 1) % fieldCount
+		}
+		cmds := make([]tea.Cmd, fieldCount)
+		for i := range m.inputs {
+			if i == m.focus {
+				cmds[i] = m.inputs[i].Focus()
+				continue
+			}
+			m.inputs[i].Blur()
+		}
+		return m, tea.Batch(cmds...)
+	case "ctrl+g":
+		password, err := m.actions.Generate(m.editedCredential())
+		if err != nil {
+			m.setStatus("Error generating password: "+err.Error(), true)
+			return m, nil
+		}
+		m.inputs[fieldPassword].SetValue(password)
+		return m, nil
+	case "ctrl+r":
+		if m.inputs[fieldPassword].EchoMode == textinput.EchoPassword {
+			m.inputs[fieldPassword].EchoMode = textinput.EchoNormal
+		} else {
+			m.inputs[fieldPassword].EchoMode = textinput.EchoPassword
+		}
+		return m, nil
+	case "enter":
+		return m.saveEdit()
+	}
+
+	var cmd tea.Cmd
+	m.inputs[m.focus], cmd = m.inputs[m.focus].Update(msg)
+	return m, cmd
+}
+
+// editedCredential returns the entry as it is in the edit form.
+func (m BrowserModel) editedCredential() Credential {
+	c := m.editing
+	c.Source = strings.TrimSpace(m.inputs[fieldSource].Value())
+	c.Username = strings.TrimSpace(m.inputs[fieldUsername].Value())
+	c.Password = m.inputs[fieldPassword].Value()
+	c.URL = BeautifyURL(strings.TrimSpace(m.inputs[fieldURL].Value()))
+	c.Folder = m.inputs[fieldFolder].Value()
+	return c
+}
+
+func (m BrowserModel) saveEdit() (tea.Model, tea.Cmd) {
+	c := m.editedCredential()
+	if c.Source == "" || c.Username == "" || c.Password == "" {
+		m.setStatus("Source, username and password are required", true)
+		return m, nil
+	}
+
+	id, err := m.actions.Save(c)
+	if err != nil {
+		m.setStatus("Error saving password: "+err.Error(), true)
+		return m, nil
+	}
+	m.mode = browseMode
+	if err := m.reload(id); err != nil {
+		m.setStatus("Error reading passwords: "+err.Error(), true)
+		return m, nil
+	}
+	if m.editing.ID == 0 {
+		m.setStatus("✅ Password for "+c.Source+"/"+c.Username+" stored", false)
+	} else {
+		m.setStatus("✅ Password for "+c.Source+"/"+c.Username+" updated", false)
+	}
+	return m, nil
+}
+
+func (m BrowserModel) updateConfirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	mode := m.mode
+	m.mode = browseMode
+	c, ok := m.selected()
+	if !ok || (msg.String() != "y" && msg.String() != "Y") {
+		m.setStatus("", false)
+		return m, nil
+	}
+
+	name := c.Source + "/" + c.Username
+	if mode == confirmDeleteMode {
+		if err := m.actions.Delete(c); err != nil {
+			m.setStatus("Error deleting password: "+err.Error(), true)
+			return m, nil
+		}
+		m.setStatus("✅ Password for "+name+" moved to the trash", false)
+	} else {
+		password, err := m.actions.Generate(c)
+		if err != nil {
+			m.setStatus("Error generating password: "+err.Error(), true)
+			return m, nil
+		}
+		c.Password = password
+		if _, err := m.actions.Save(c); err != nil {
+			m.setStatus("Error updating password: "+err.Error(), true)
+			return m, nil
+		}
+		m.setStatus("✅ New password generated for "+name+", c to copy it", false)
+	}
+	if err := m.reload(c.ID); err != nil {
+		m.setStatus("Error reading passwords: "+err.Error(), true)
+	}
+	return m, nil
+}
+
+func (m BrowserModel) listWidth() int {
+	return max(m.width*2/5, 30)
+}
+
+func (m BrowserModel) View() string {
+	if m.mode == helpMode {
+		return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, browserHelp())
+	}
+
+	detailStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color("#25A065")).
+		Padding(0, 1).
+		Width(max(m.width-m.listWidth()-4, 30)).
+		Height(max(m.height-5, 5))
+
+	var detail string
+	if m.mode == editMode {
+		detail = m.editView()
+	} else {
+		detail = m.detailView()
+	}
+	list := lipgloss.NewStyle().Width(m.listWidth()).Render(m.list.View())
+	panes := lipgloss.JoinHorizontal(lipgloss.Top, list, detailStyle.Render(detail))
+	return panes + "\n" + m.statusLine()
+}
+
+func (m BrowserModel) detailView() string {
+	c, ok := m.selected()
+	if !ok {
+		return StylePrompt.Render("No passwords yet, press n to add one.")
+	}
+
+	password := strings.Repeat("•", min(len(c.Password), 16)) + StylePrompt.Render("  r to reveal")
+	if m.revealed == c.ID {
+		password = StylePassword.Render(c.Password)
+	}
+	folder := c.Folder
+	if folder == "" {
+		folder = "/"
+	}
+
+	var b strings.Builder
+	b.WriteString(StyleHeading.Render(c.Source+"/"+c.Username) + "\n\n")
+	rows := [][2]string{
+		{"Source", c.Source},
+		{"Username", c.Username},
+		{"Password", password},
+		{"URL", c.URL},
+		{"Folder", folder},
+		{"Tags", strings.Join(c.Tags, ", ")},
+		{"Notes", c.Notes},
+		{"Created", c.CreatedAt.Local().Format("2006-01-02 15:04:05")},
+		{"Updated", c.UpdatedAt.Local().Format("2006-01-02 15:04:05")},
+	}
+	for _, row := range rows {
+		b.WriteString(fmt.Sprintf("%-10s %s\n", row[0], row[1]))
+	}
+	return b.String()
+}
+
+func (m BrowserModel) editView() string {
+	var b strings.Builder
+	if m.editing.ID == 0 {
+		b.WriteString(StyleHeading.Render("New password") + "\n\n")
+	} else {
+		b.WriteString(StyleHeading.Render("Edit "+m.editing.Source+"/"+m.editing.Username) + "\n\n")
+	}
+	for i, input := range m.inputs {
+		label := fmt.Sprintf("%-10s ", fieldLabels[i])
+		if i == m.focus {
+			label = StyleSuccess.Render(label)
+		}
+		b.WriteString(label + input.View() + "\n")
+	}
+	b.WriteString("\n" + StylePrompt.Render("enter save • esc cancel • tab next field • ctrl+g generate • ctrl+r show password"))
+	return b.String()
+}
+
+func (m BrowserModel) statusLine() string {
+	switch m.mode {
+	case confirmDeleteMode, confirmGenerateMode:
+		c, _ := m.selected()
+		prompt := "Move the password for " + c.Source + "/" + c.Username + " to the trash? (y/n)"
+		if m.mode == confirmGenerateMode {
+			prompt = "Replace the password for " + c.Source + "/" + c.Username + " with a generated one? (y/n)"
+		}
+		return StyleError.Render(prompt)
+	}
+	if m.status != "" {
+		if m.statusErr {
+			return StyleError.Render("❌ " + m.status)
+		}
+		return StyleSuccess.Render(m.status)
+	}
+	return StylePrompt.Render("c copy password • u username • o URL • r reveal • e edit • n new • g generate • d delete • / filter • ? help • q quit")
+}
+
+func browserHelp() string {
+	keys := [][2]string{
+		{"↑/↓, j/k", "move through the list"},
+		{"/", "filter the list"},
+		{"c", "copy the password, cleared after 45 seconds"},
+		{"u", "copy the username"},
+		{"o", "copy the URL"},
+		{"r, enter", fmt.Sprintf("reveal the password for %d seconds", int(BrowserRevealTimeout.Seconds()))},
+		{"e", "edit the entry"},
+		{"n", "add a new entry"},
+		{"g", "replace the password with a generated one"},
+		{"d", "move the entry to the trash"},
+		{"?", "show this help"},
+		{"q, ctrl+c", "quit"},
+	}
+
+	var b strings.Builder
+	b.WriteString(StyleHeading.Render("Keys") + "\n\n")
+	for _, k := range keys {
+		b.WriteString(fmt.Sprintf("%s %s\n", StyleSuccess.Render(fmt.Sprintf("%-10s", k[0])), k[1]))
+	}
+	b.WriteString("\n" + StylePrompt.Render("Press any key to close"))
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color("#25A065")).
+		Padding(1, 2).
+		Render(b.String())
+}
@@ -0,0 +1,262 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	ArchiveFormat  = "fortpass-archive"
+	ArchiveVersion = 1
+)
+
+// ArchiveKDF describes how the archive key was derived from the passphrase,
+// so that an archive can be opened without any out-of-band knowledge.
+type ArchiveKDF struct {
+	Name    string `json:"name"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// Archive is the self-describing envelope of a passphrase-protected payload.
+type Archive struct {
+	Format  string     `json:"format"`
+	Version int        `json:"version"`
+	Content string     `json:"content"`
+	KDF     ArchiveKDF `json:"kdf"`
+	Cipher  string     `json:"cipher"`
+	Nonce   []byte     `json:"nonce"`
+	Payload []byte     `json:"payload"`
+}
+
+// SealWithPassphrase encrypts plaintext with a key derived from passphrase
+// and returns the JSON encoded archive. content names what the payload holds.
+func SealWithPassphrase(plaintext []byte, passphrase, content string) ([]byte, error) {
+	kdf := ArchiveKDF{
+		Name:    "argon2id",
+		Salt:    make([]byte, 16),
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+	}
+	if _, err := rand.Read(kdf.Salt); err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(passphrase), kdf.Salt, kdf.Time, kdf.Memory, kdf.Threads, 32)
+	nonce, payload, err := sealAESGCM(key, plaintext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(Archive{
+		Format:  ArchiveFormat,
+		Version: ArchiveVersion,
+		Content: content,
+		KDF:     kdf,
+		Cipher:  "aes-256-gcm",
+		Nonce:   nonce,
+		Payload: payload,
+	}, "", "  ")
+}
+
+// OpenWithPassphrase decrypts an archive produced by SealWithPassphrase.
+func OpenWithPassphrase(data []byte, passphrase string) (*Archive, []byte, error) {
+	var archive Archive
+	if err := json.Unmarshal(data, &archive); err != nil {
+		return nil, nil, fmt.Errorf("not a fortpass archive: %w", err)
+	}
+	if archive.Format != ArchiveFormat {
+		return nil, nil, errors.New("not a fortpass archive")
+	}
+	if archive.Version > ArchiveVersion {
+		return nil, nil, fmt.Errorf("archive version %d is newer than supported version %d", archive.Version, ArchiveVersion)
+	}
+	if archive.KDF.Name != "argon2id" || archive.Cipher != "aes-256-gcm" {
+		return nil, nil, fmt.Errorf("unsupported archive encryption %s/%s", archive.KDF.Name, archive.Cipher)
+	}
+
+	key := argon2.IDKey([]byte(passphrase), archive.KDF.Salt, archive.KDF.Time, archive.KDF.Memory, archive.KDF.Threads, 32)
+	plaintext, err := openAESGCM(key, archive.Nonce, archive.Payload, nil)
+	if err != nil {
+		return nil, nil, errors.New("wrong passphrase or corrupted archive")
+	}
+	return &archive, plaintext, nil
+}
+
+// IsArchive reports whether data looks like a fortpass archive.
+func IsArchive(data []byte) bool {
+	var header struct {
+		Format string `json:"format"`
+	}
+	return json.Unmarshal(data, &header) == nil && header.Format == ArchiveFormat
+}
+
+// EncryptWithKey encrypts plaintext with a 256 bit key and returns the nonce
+// followed by the ciphertext.
+func EncryptWithKey(key, plaintext []byte) ([]byte, error) {
+	return EncryptBound(key, plaintext, nil)
+}
+
+// DecryptWithKey reverses EncryptWithKey.
+func DecryptWithKey(key, data []byte) ([]byte, error) {
+	return DecryptBound(key, data, nil)
+}
+
+// EncryptBound encrypts plaintext like EncryptWithKey and binds the
+// ciphertext to additionalData, which is not encrypted: it only decrypts
+// with the same additionalData.
+func EncryptBound(key, plaintext, additionalData []byte) ([]byte, error) {
+	nonce, ciphertext, err := sealAESGCM(key, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+// DecryptBound reverses EncryptBound and fails when additionalData differs.
+func DecryptBound(key, data, additionalData []byte) ([]byte, error) {
+	const nonceSize = 12
+	if len(data) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	return openAESGCM(key, data[:nonceSize], data[nonceSize:], additionalData)
+}
+
+// KeyringSecret returns the hex encoded 256 bit key stored in the system
+// keyring under name, generating and storing one on first use.
+func KeyringSecret(name string) ([]byte, error) {
+	stored, err := keyring.Get("fortpass", name)
+	if err == nil {
+		return hex.DecodeString(stored)
+	}
+	if err != keyring.ErrNotFound {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := keyring.Set("fortpass", name, hex.EncodeToString(key)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// LookupKeyringSecret returns the key stored in the system keyring under
+// name like KeyringSecret, but nil instead of creating a missing key.
+func LookupKeyringSecret(name string) ([]byte, error) {
+	stored, err := keyring.Get("fortpass", name)
+	if err == keyring.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(stored)
+}
+
+// SetKeyringSecret replaces the key stored in the system keyring under name.
+func SetKeyringSecret(name string, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+	return keyring.Set("fortpass", name, hex.EncodeToString(key))
+}
+
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, additionalData), nil
+}
+
+func openAESGCM(key, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce size")
+	}
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// ReadPassphrase prompts for a secret without echoing it. When stdin is not a
+// terminal the passphrase is read from the first line of stdin instead.
+func ReadPassphrase(prompt string) (string, error) {
+	fmt.Print(StylePrompt.Render(prompt))
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		line, err := stdinReader.ReadString('\n')
+		fmt.Println()
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	secret, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// ReadNewPassphrase asks for a passphrase twice and makes sure both match.
+func ReadNewPassphrase(prompt string) (string, error) {
+	return ReadNewSecret(prompt, "passphrase")
+}
+
+// ReadNewSecret asks for a new secret, such as a password or passphrase,
+// twice and makes sure both match. name is used in the messages.
+func ReadNewSecret(prompt, name string) (string, error) {
+	secret, err := ReadPassphrase(prompt)
+	if err != nil {
+		return "", err
+	}
+	if secret == "" {
+		return "", fmt.Errorf("%s must not be empty", name)
+	}
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		return secret, nil
+	}
+	confirm, err := ReadPassphrase("Repeat " + name + ": ")
+	if err != nil {
+		return "", err
+	}
+	if confirm != secret {
+		return "", fmt.Errorf("%ss do not match", name)
+	}
+	return secret, nil
+}
@@ -7,18 +7,22 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/zalando/go-keyring"
 )
 
+// SchemaVersion is the database version created and expected by this build.
+const SchemaVersion = 15
+
 var (
-	IncludeSpecial bool
-	Length         int
-	DB             *sql.DB
-	DBPath         string
-	EncryptionKey  string
+	DB     *sql.DB
+	DBPath string
+	// EncryptionKey is always a copy made at run time, so the agent can wipe
+	// it once the key is in locked memory.
+	EncryptionKey string
 )
 
 func InitDB() {
@@ -35,9 +39,16 @@ func InitDB() {
 		os.Exit(1)
 	}
 
-	// Retrieve encryption key from system keyring
-	EncryptionKey, err = keyring.Get("fortpass", "db_encryption_key")
-	if err != nil {
+	// Use the keys cached by a running agent, else retrieve them from the
+	// system keyring
+	agent, err := CallAgent(AgentOpKey)
+	fromAgent := err == nil && agent.DBPath == DBPath
+	if fromAgent {
+		EncryptionKey = agent.Key
+		if key, err := hex.DecodeString(agent.AuditKey); err == nil && len(key) == 32 {
+			agentAuditKey = key
+		}
+	} else if EncryptionKey, err = keyring.Get("fortpass", "db_encryption_key"); err != nil {
 		// Generate a new encryption key if not found
 		EncryptionKey = GenerateEncryptionKey()
 		err = keyring.Set("fortpass", "db_encryption_key", EncryptionKey)
@@ -46,6 +57,7 @@ func InitDB() {
 			os.Exit(1)
 		}
 	}
+	EncryptionKey = strings.Clone(EncryptionKey)
 
 	// Open the encrypted database
 	DB, err = sql.Open("sqlite3", fmt.Sprintf("%s?_pragma_key=%s", DBPath, EncryptionKey))
@@ -54,6 +66,13 @@ func InitDB() {
 		os.Exit(1)
 	}
 
+	// The agent migrated the database when it started, only a database
+	// replaced since then, e.g. by restore-backup, has to be migrated again
+	if fromAgent && agent.SchemaVersion == SchemaVersion {
+		if version, err := ReadSchemaVersion(DB); err == nil && version == SchemaVersion {
+			return
+		}
+	}
 	CreateTable()
 }
 
@@ -69,7 +88,17 @@ func GenerateEncryptionKey() string {
 }
 
 func CreateTable() {
-	_, err := DB.Exec(`
+	err := MigrateDatabase(DB)
+	if err != nil {
+		fmt.Println(StyleError.Render(err.Error()))
+		os.Exit(1)
+	}
+}
+
+// MigrateDatabase creates the tables of a new vault and migrates older
+// vaults to SchemaVersion.
+func MigrateDatabase(db *sql.DB) error {
+	_, err := db.Exec(`
         CREATE TABLE IF NOT EXISTS version (
             version INTEGER PRIMARY KEY
         );
@@ -85,35 +114,35 @@ func CreateTable() {
         )
     `)
 	if err != nil {
-		fmt.Println(StyleError.Render("Error creating tables: " + err.Error()))
-		os.Exit(1)
+		return fmt.Errorf("Error creating tables: %w", err)
 	}
 
 	// Check and update database version
-	CheckAndMigrateDatabase()
+	return CheckAndMigrateDatabase(db)
 }
 
-func CheckAndMigrateDatabase() {
+// CheckAndMigrateDatabase migrates db step by step to SchemaVersion. The
+// notices go to stderr, so they never end up among shell completions or in
+// output piped into other programs.
+func CheckAndMigrateDatabase(db *sql.DB) error {
 	var version int
-	err := DB.QueryRow("SELECT version FROM version").Scan(&version)
+	err := db.QueryRow("SELECT version FROM version").Scan(&version)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// Initialize version
-			_, err = DB.Exec("INSERT INTO version (version) VALUES (1)")
+			_, err = db.Exec("INSERT INTO version (version) VALUES (1)")
 			if err != nil {
-				fmt.Println(StyleError.Render("Error initializing database version: " + err.Error()))
-				os.Exit(1)
+				return fmt.Errorf("Error initializing database version: %w", err)
 			}
 			version = 1
 		} else {
-			fmt.Println(StyleError.Render("Error checking database version: " + err.Error()))
-			os.Exit(1)
+			return fmt.Errorf("Error checking database version: %w", err)
 		}
 	}
 
 	if version < 2 {
 		// Perform migration to version 2
-		_, err = DB.Exec(`
+		_, err = db.Exec(`
             BEGIN TRANSACTION;
             
             CREATE TABLE passwords_new (
@@ -140,14 +169,422 @@ func CheckAndMigrateDatabase() {
             COMMIT;
         `)
 		if err != nil {
-			fmt.Println(StyleError.Render("Error migrating database to version 2: " + err.Error()))
-			os.Exit(1)
+			return fmt.Errorf("Error migrating database to version 2: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 2"))
+	}
+
+	if version < 3 {
+		// Perform migration to version 3
+		_, err = db.Exec(`
+            BEGIN TRANSACTION;
+
+            CREATE TABLE IF NOT EXISTS password_history (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                password_id INTEGER NOT NULL,
+                password TEXT,
+                created_at DATETIME,
+                archived_at DATETIME DEFAULT CURRENT_TIMESTAMP
+            );
+
+            CREATE INDEX IF NOT EXISTS idx_password_history_password_id ON password_history(password_id);
+
+            UPDATE version SET version = 3;
+
+            COMMIT;
+        `)
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 3: %w", err)
 		}
-		fmt.Println(StyleSuccess.Render("Database migrated to version 2"))
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 3"))
 	}
+
+	if version < 4 {
+		// Perform migration to version 4
+		_, err = db.Exec(`
+            BEGIN TRANSACTION;
+
+            CREATE TABLE IF NOT EXISTS settings (
+                key TEXT PRIMARY KEY,
+                value TEXT
+            );
+
+            UPDATE version SET version = 4;
+
+            COMMIT;
+        `)
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 4: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 4"))
+	}
+
+	if version < 5 {
+		// Perform migration to version 5: stable ids for syncing entries
+		_, err = db.Exec(`
+            BEGIN TRANSACTION;
+
+            ALTER TABLE passwords ADD COLUMN uuid TEXT;
+
+            UPDATE passwords SET uuid = lower(hex(randomblob(16))) WHERE uuid IS NULL;
+
+            CREATE UNIQUE INDEX IF NOT EXISTS idx_passwords_uuid ON passwords(uuid);
+
+            CREATE TRIGGER IF NOT EXISTS passwords_uuid AFTER INSERT ON passwords
+            WHEN NEW.uuid IS NULL
+            BEGIN
+                UPDATE passwords SET uuid = lower(hex(randomblob(16))) WHERE id = NEW.id;
+            END;
+
+            UPDATE version SET version = 5;
+
+            COMMIT;
+        `)
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 5: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 5"))
+	}
+
+	if version < 6 {
+		// Perform migration to version 6: revision metadata for the sync server
+		_, err = db.Exec(`
+            BEGIN TRANSACTION;
+
+            ALTER TABLE passwords ADD COLUMN revision INTEGER NOT NULL DEFAULT 0;
+            ALTER TABLE passwords ADD COLUMN synced_hash TEXT;
+
+            CREATE TABLE IF NOT EXISTS sync_tombstones (
+                uuid TEXT PRIMARY KEY,
+                revision INTEGER NOT NULL
+            );
+
+            CREATE TRIGGER IF NOT EXISTS passwords_tombstone AFTER DELETE ON passwords
+            WHEN OLD.revision > 0
+            BEGIN
+                INSERT OR REPLACE INTO sync_tombstones (uuid, revision) VALUES (OLD.uuid, OLD.revision);
+            END;
+
+            UPDATE version SET version = 6;
+
+            COMMIT;
+        `)
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 6: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 6"))
+	}
+
+	if version < 7 {
+		// Perform migration to version 7: hash-chained audit log
+		_, err = db.Exec(`
+            BEGIN TRANSACTION;
+
+            CREATE TABLE IF NOT EXISTS audit_log (
+                id INTEGER PRIMARY KEY,
+                created_at TEXT NOT NULL,
+                action TEXT NOT NULL,
+                entry_id INTEGER NOT NULL DEFAULT 0,
+                entry TEXT NOT NULL DEFAULT '',
+                details TEXT NOT NULL DEFAULT '',
+                os_user TEXT NOT NULL,
+                host TEXT NOT NULL,
+                prev_hash TEXT NOT NULL UNIQUE,
+                hash TEXT NOT NULL
+            );
+
+            CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+            BEGIN
+                SELECT RAISE(ABORT, 'the audit log is append-only');
+            END;
+
+            CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+            BEGIN
+                SELECT RAISE(ABORT, 'the audit log is append-only');
+            END;
+
+            UPDATE version SET version = 7;
+
+            COMMIT;
+        `)
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 7: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 7"))
+	}
+
+	if version < 8 {
+		// Perform migration to version 8: trash. Entries with deleted_at set
+		// are in the trash; moving an entry there deletes it for sync, so the
+		// tombstone is written then and not again when it is purged.
+		_, err = db.Exec(`
+            BEGIN TRANSACTION;
+
+            ALTER TABLE passwords ADD COLUMN deleted_at DATETIME;
+
+            CREATE INDEX IF NOT EXISTS idx_passwords_deleted_at ON passwords(deleted_at);
+
+            DROP TRIGGER IF EXISTS passwords_tombstone;
+
+            CREATE TRIGGER passwords_tombstone AFTER DELETE ON passwords
+            WHEN OLD.revision > 0 AND OLD.deleted_at IS NULL
+            BEGIN
+                INSERT OR REPLACE INTO sync_tombstones (uuid, revision) VALUES (OLD.uuid, OLD.revision);
+            END;
+
+            CREATE TRIGGER IF NOT EXISTS passwords_trash_tombstone AFTER UPDATE OF deleted_at ON passwords
+            WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL AND OLD.revision > 0
+            BEGIN
+                INSERT OR REPLACE INTO sync_tombstones (uuid, revision) VALUES (OLD.uuid, OLD.revision);
+            END;
+
+            UPDATE version SET version = 8;
+
+            COMMIT;
+        `)
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 8: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 8"))
+	}
+
+	if version < 9 {
+		// Perform migration to version 9: folders, stored as slash separated
+		// paths, '' being the top level
+		_, err = db.Exec(`
+            BEGIN TRANSACTION;
+
+            ALTER TABLE passwords ADD COLUMN folder TEXT NOT NULL DEFAULT '';
+
+            CREATE INDEX IF NOT EXISTS idx_passwords_folder ON passwords(folder);
+
+            UPDATE version SET version = 9;
+
+            COMMIT;
+        `)
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 9: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 9"))
+	}
+
+	if version < 10 {
+		// Perform migration to version 10: tags, stored sorted and comma
+		// separated
+		_, err = db.Exec(`
+            BEGIN TRANSACTION;
+
+            ALTER TABLE passwords ADD COLUMN tags TEXT NOT NULL DEFAULT '';
+
+            UPDATE version SET version = 10;
+
+            COMMIT;
+        `)
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 10: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 10"))
+	}
+
+	if version < 11 {
+		// Perform migration to version 11: free-form notes
+		_, err = db.Exec(`
+            BEGIN TRANSACTION;
+
+            ALTER TABLE passwords ADD COLUMN notes TEXT NOT NULL DEFAULT '';
+
+            UPDATE version SET version = 11;
+
+            COMMIT;
+        `)
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 11: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 11"))
+	}
+
+	if version < 12 {
+		// Perform migration to version 12: rotation policies in days, per
+		// entry or per folder
+		_, err = db.Exec(`
+            BEGIN TRANSACTION;
+
+            ALTER TABLE passwords ADD COLUMN rotate_days INTEGER NOT NULL DEFAULT 0;
+
+            CREATE TABLE IF NOT EXISTS rotation_policies (
+                folder TEXT PRIMARY KEY,
+                days INTEGER NOT NULL
+            );
+
+            UPDATE version SET version = 12;
+
+            COMMIT;
+        `)
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 12: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 12"))
+	}
+
+	if version < 13 {
+		// Perform migration to version 13: the executable that changes the
+		// password of an entry where it is used
+		_, err = db.Exec(`
+            BEGIN TRANSACTION;
+
+            ALTER TABLE passwords ADD COLUMN rotate_hook TEXT NOT NULL DEFAULT '';
+
+            UPDATE version SET version = 13;
+
+            COMMIT;
+        `)
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 13: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 13"))
+	}
+
+	if version < 14 {
+		// Perform migration to version 14: generator policies as JSON, per
+		// entry or per domain
+		_, err = db.Exec(`
+            BEGIN TRANSACTION;
+
+            ALTER TABLE passwords ADD COLUMN generator_policy TEXT NOT NULL DEFAULT '';
+
+            CREATE TABLE IF NOT EXISTS generator_policies (
+                domain TEXT PRIMARY KEY,
+                options TEXT NOT NULL
+            );
+
+            UPDATE version SET version = 14;
+
+            COMMIT;
+        `)
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 14: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 14"))
+	}
+
+	if version < 15 {
+		// Perform migration to version 15: the audit log is signed with a
+		// key from the system keyring. Audit marks the switch with a signed
+		// event covering the hash of the last unsigned one, so opening the
+		// vault does not need the keyring.
+		_, err = db.Exec("UPDATE version SET version = 15")
+		if err != nil {
+			return fmt.Errorf("Error migrating database to version 15: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, StyleSuccess.Render("Database migrated to version 15"))
+	}
+	return nil
+}
+
+// ReadSchemaVersion returns the schema version of db. Databases created
+// before the version table was introduced are reported as version 1.
+func ReadSchemaVersion(db *sql.DB) (int, error) {
+	var hasVersion bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'version')").Scan(&hasVersion)
+	if err != nil || !hasVersion {
+		return 1, err
+	}
+
+	var version int
+	err = db.QueryRow("SELECT version FROM version").Scan(&version)
+	if err == sql.ErrNoRows {
+		return 1, nil
+	}
+	return version, err
+}
+
+// GetSetting returns the stored value of a setting, or fallback when it is
+// not set.
+func GetSetting(key, fallback string) string {
+	var value string
+	err := DB.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func SetSetting(key, value string) error {
+	_, err := DB.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", key, value)
+	return err
+}
+
+// ListSettings returns the settings whose key starts with prefix, keyed by
+// the rest of the key.
+func ListSettings(prefix string) (map[string]string, error) {
+	rows, err := DB.Query("SELECT key, value FROM settings WHERE substr(key, 1, length(?)) = ?", prefix, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		settings[strings.TrimPrefix(key, prefix)] = value
+	}
+	return settings, rows.Err()
+}
+
+// FormatDBTime formats t the way SQLite's CURRENT_TIMESTAMP does, so stored
+// timestamps stay comparable.
+func FormatDBTime(t time.Time) string {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// Execer is implemented by *sql.DB and *sql.Tx.
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// ArchivePassword copies the current password of an entry into its history
+// before it gets replaced.
+func ArchivePassword(db Execer, id int64) error {
+	_, err := db.Exec(`
+		INSERT INTO password_history (password_id, password, created_at, archived_at)
+		SELECT id, password, updated_at, CURRENT_TIMESTAMP FROM passwords WHERE id = ?
+	`, id)
+	return err
+}
+
+// InsertEntry stores a new entry and returns its ID. An entry for the same
+// account in the trash is brought back with the new password instead, since
+// the account can only be stored once.
+func InsertEntry(db Queryer, source, username, password, url, createdAt, updatedAt string) (int64, error) {
+	id, err := ReviveTrashed(db, "", source, username, url)
+	if err != nil {
+		return 0, err
+	}
+	if id != 0 {
+		_, err = db.Exec("UPDATE passwords SET password = ?, created_at = ?, updated_at = ? WHERE id = ?", password, createdAt, updatedAt, id)
+		return id, err
+	}
+	result, err := db.Exec(`INSERT INTO passwords (source, username, password, url, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, source, username, password, url, createdAt, updatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
 }
+
 func GetPasswordEntries() []PasswordEntry {
-	rows, err := DB.Query("SELECT source, username, url, created_at, updated_at FROM passwords")
+	policies, err := FolderRotationPolicies(DB)
+	if err != nil {
+		fmt.Println(StyleError.Render("Error fetching rotation policies: " + err.Error()))
+		return nil
+	}
+	rows, err := DB.Query("SELECT id, source, username, url, folder, tags, notes, rotate_days, created_at, updated_at FROM passwords WHERE deleted_at IS NULL ORDER BY folder, source, username")
 	if err != nil {
 		fmt.Println(StyleError.Render("Error fetching passwords: " + err.Error()))
 		return nil
@@ -157,13 +594,60 @@ func GetPasswordEntries() []PasswordEntry {
 	var entries []PasswordEntry
 	for rows.Next() {
 		var entry PasswordEntry
-		err := rows.Scan(&entry.Source, &entry.Username, &entry.URL, &entry.CreatedAt, &entry.UpdatedAt)
+		var tags string
+		err := rows.Scan(&entry.ID, &entry.Source, &entry.Username, &entry.URL, &entry.Folder, &tags, &entry.Notes, &entry.RotateDays, &entry.CreatedAt, &entry.UpdatedAt)
 		if err != nil {
 			fmt.Println(StyleError.Render("Error scanning row: " + err.Error()))
 			continue
 		}
+		entry.Tags = ParseTags(tags)
+		entry.RotationDue = RotationDue(entry.UpdatedAt, RotationDays(entry.RotateDays, entry.Folder, policies))
 		entries = append(entries, entry)
 	}
 
 	return entries
 }
+
+// GetCredentials returns every stored entry together with its password.
+func GetCredentials() ([]Credential, error) {
+	return ReadCredentials(DB)
+}
+
+// ReadCredentials returns every entry of db together with its password,
+// leaving out the entries in the trash.
+func ReadCredentials(db *sql.DB) ([]Credential, error) {
+	folder, tags, notes, where := "''", "''", "''", ""
+	if version, err := ReadSchemaVersion(db); err == nil {
+		if version >= 8 {
+			where = " WHERE deleted_at IS NULL"
+		}
+		if version >= 9 {
+			folder = "folder"
+		}
+		if version >= 10 {
+			tags = "tags"
+		}
+		if version >= 11 {
+			notes = "notes"
+		}
+	}
+	rows, err := db.Query("SELECT id, source, username, password, url, " + folder + ", " + tags + ", " + notes + ", created_at, updated_at FROM passwords" + where + " ORDER BY source, username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []Credential
+	for rows.Next() {
+		var c Credential
+		var tags string
+		err := rows.Scan(&c.ID, &c.Source, &c.Username, &c.Password, &c.URL, &c.Folder, &tags, &c.Notes, &c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		c.Tags = ParseTags(tags)
+		credentials = append(credentials, c)
+	}
+
+	return credentials, rows.Err()
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NormalizeFolder cleans up a folder path such as "/work//aws/" to
+// "work/aws". The top level is "".
+func NormalizeFolder(folder string) (string, error) {
+	var parts []string
+	for _, part := range strings.Split(folder, "/") {
+		part = strings.TrimSpace(part)
+		switch part {
+		case "":
+			continue
+		case ".", "..":
+			return "", fmt.Errorf("invalid folder %q, folders cannot contain . or ..", folder)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// FolderName returns the last element of a folder path.
+func FolderName(folder string) string {
+	return folder[strings.LastIndex(folder, "/")+1:]
+}
+
+// FolderAncestors returns a folder path and all of its parents, outermost
+// first: "work/aws" gives "work" and "work/aws".
+func FolderAncestors(folder string) []string {
+	if folder == "" {
+		return nil
+	}
+	parts := strings.Split(folder, "/")
+	paths := make([]string, len(parts))
+	for i := range parts {
+		paths[i] = strings.Join(parts[:i+1], "/")
+	}
+	return paths
+}
+
+// InFolder reports whether an entry in entryFolder is inside folder or one
+// of its subfolders. Every entry is inside the top level "".
+func InFolder(entryFolder, folder string) bool {
+	return folder == "" || entryFolder == folder || strings.HasPrefix(entryFolder, folder+"/")
+}
+
+// SortByFolder returns the entries ordered the way they appear in a tree:
+// by folder, comparing the path element by element, then by source and
+// username.
+func SortByFolder(entries []PasswordEntry) []PasswordEntry {
+	sorted := append([]PasswordEntry{}, entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		return treeLess(a.Folder, a.Source, a.Username, b.Folder, b.Source, b.Username)
+	})
+	return sorted
+}
+
+// SortCredentialsByFolder orders credentials like SortByFolder.
+func SortCredentialsByFolder(credentials []Credential) []Credential {
+	sorted := append([]Credential{}, credentials...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		return treeLess(a.Folder, a.Source, a.Username, b.Folder, b.Source, b.Username)
+	})
+	return sorted
+}
+
+func treeLess(folderA, sourceA, usernameA, folderB, sourceB, usernameB string) bool {
+	// Comparing with "/" as the lowest character keeps subfolders right
+	// below their parent.
+	keyA, keyB := strings.ReplaceAll(folderA, "/", "\x00"), strings.ReplaceAll(folderB, "/", "\x00")
+	if keyA != keyB {
+		return keyA < keyB
+	}
+	if sourceA != sourceB {
+		return sourceA < sourceB
+	}
+	return usernameA < usernameB
+}
@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	lowerChars   = "abcdefghijklmnopqrstuvwxyz"
+	upperChars   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars   = "0123456789"
+	specialChars = "!@#$%^&*()_+-=[]{}|;:,.<>?"
+	// AmbiguousChars are easily confused and can be left out.
+	AmbiguousChars = "Il1O0o"
+)
+
+//go:embed wordlist.txt
+var wordlistData string
+
+// Wordlist is the list of words passphrases are made of.
+var Wordlist = strings.Fields(wordlistData)
+
+// GeneratorOptions describe the passwords to generate.
+type GeneratorOptions struct {
+	Length  int
+	Lower   bool
+	Upper   bool
+	Digits  bool
+	Special bool
+	// Exclude lists characters that never appear in passwords.
+	Exclude          string
+	ExcludeAmbiguous bool
+
+	// Passphrase generates Words words joined by Separator instead.
+	Passphrase bool
+	Words      int
+	Separator  string
+}
+
+// classes returns the character sets of the enabled character classes with
+// the excluded characters removed.
+func (o GeneratorOptions) classes() []string {
+	exclude := o.Exclude
+	if o.ExcludeAmbiguous {
+		exclude += AmbiguousChars
+	}
+	var classes []string
+	for _, class := range []struct {
+		enabled bool
+		chars   string
+	}{{o.Lower, lowerChars}, {o.Upper, upperChars}, {o.Digits, digitChars}, {o.Special, specialChars}} {
+		if !class.enabled {
+			continue
+		}
+		chars := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(exclude, r) {
+				return -1
+			}
+			return r
+		}, class.chars)
+		if chars != "" {
+			classes = append(classes, chars)
+		}
+	}
+	return classes
+}
+
+// Validate reports options no password can be generated for.
+func (o GeneratorOptions) Validate() error {
+	if o.Passphrase {
+		if o.Words < 1 {
+			return fmt.Errorf("a passphrase needs at least one word")
+		}
+		return nil
+	}
+	if o.Length < 1 {
+		return fmt.Errorf("the length must be at least 1")
+	}
+	classes := o.classes()
+	if len(classes) == 0 {
+		return fmt.Errorf("no characters left to generate from, enable a character class or exclude fewer characters")
+	}
+	if len(classes) > o.Length {
+		return fmt.Errorf("a password of %d characters cannot contain all %d character classes", o.Length, len(classes))
+	}
+	return nil
+}
+
+// Generate returns a random password or passphrase. Passwords contain at
+// least one character of every enabled class.
+func (o GeneratorOptions) Generate() (string, error) {
+	if err := o.Validate(); err != nil {
+		return "", err
+	}
+
+	if o.Passphrase {
+		words := make([]string, o.Words)
+		for i := range words {
+			n, err := randomInt(len(Wordlist))
+			if err != nil {
+				return "", err
+			}
+			words[i] = Wordlist[n]
+		}
+		return strings.Join(words, o.Separator), nil
+	}
+
+	classes := o.classes()
+	charset := strings.Join(classes, "")
+	password := make([]byte, o.Length)
+	for {
+		for i := range password {
+			n, err := randomInt(len(charset))
+			if err != nil {
+				return "", err
+			}
+			password[i] = charset[n]
+		}
+		if containsAllClasses(string(password), classes) {
+			return string(password), nil
+		}
+	}
+}
+
+func containsAllClasses(password string, classes []string) bool {
+	for _, class := range classes {
+		if !strings.ContainsAny(password, class) {
+			return false
+		}
+	}
+	return true
+}
+
+func randomInt(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
+
+// Entropy returns the strength of the generated passwords in bits.
+func (o GeneratorOptions) Entropy() float64 {
+	if o.Passphrase {
+		return float64(o.Words) * math.Log2(float64(len(Wordlist)))
+	}
+	size := len(strings.Join(o.classes(), ""))
+	if size == 0 {
+		return 0
+	}
+	return float64(o.Length) * math.Log2(float64(size))
+}
+
+// PasswordEntropy estimates the strength of a password in bits from its
+// length and the character classes it uses. Passwords made of words or
+// patterns are weaker than estimated.
+func PasswordEntropy(password string) float64 {
+	pool := 0
+	for _, class := range []string{lowerChars, upperChars, digitChars} {
+		if strings.ContainsAny(password, class) {
+			pool += len(class)
+		}
+	}
+	if strings.IndexFunc(password, func(r rune) bool {
+		return !strings.ContainsRune(lowerChars+upperChars+digitChars, r)
+	}) >= 0 {
+		// The printable ASCII characters that are not letters or digits
+		pool += 33
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(utf8.RuneCountInString(password)) * math.Log2(float64(pool))
+}
+
+// EntropyRating describes a strength in bits in words.
+func EntropyRating(bits float64) string {
+	switch {
+	case bits < 40:
+		return "very weak"
+	case bits < 60:
+		return "weak"
+	case bits < 80:
+		return "fair"
+	case bits < 100:
+		return "strong"
+	}
+	return "very strong"
+}
+
+// EntropyMeter renders a strength in bits as a bar of width cells, full at
+// 128 bits.
+func EntropyMeter(bits float64, width int) string {
+	filled := min(int(bits/128*float64(width)), width)
+	style := StyleError
+	if bits >= 80 {
+		style = StyleSuccess
+	} else if bits >= 60 {
+		style = StyleMatch
+	}
+	bar := style.Render(strings.Repeat("█", filled)) + strings.Repeat("░", width-filled)
+	return fmt.Sprintf("%s %.0f bits, %s", bar, bits, EntropyRating(bits))
+}
@@ -0,0 +1,319 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// GeneratorAction is what the user chose to do with the generated password.
+type GeneratorAction int
+
+const (
+	GeneratorCancelled GeneratorAction = iota
+	// GeneratorSave stores the password as a new entry, described by Store.
+	GeneratorSave
+	// GeneratorApply replaces the password of the entry Target.
+	GeneratorApply
+)
+
+type generatorStep int
+
+const (
+	generatorTweak generatorStep = iota
+	generatorExclude
+	generatorStore
+	generatorTarget
+)
+
+// GeneratorModel generates passwords while the options are tweaked and lets
+// the user store the result.
+type GeneratorModel struct {
+	Options  GeneratorOptions
+	Password string
+	Action   GeneratorAction
+	// Store holds the entry to store, Target the source/username of the entry
+	// to update.
+	Store  StorePasswordModel
+	Target string
+	// Policy returns the generator policy of an entry and its name, "" when
+	// there is none. PolicyName is the policy the password was generated
+	// with, if any.
+	Policy     func(source, username, url string) (GeneratorOptions, string)
+	PolicyName string
+
+	step    generatorStep
+	entries []PasswordEntry
+	exclude textinput.Model
+	target  textinput.Model
+	status  string
+	err     error
+}
+
+// NewGeneratorModel returns the generator. entries are offered when
+// applying the password to an existing entry.
+func NewGeneratorModel(opts GeneratorOptions, entries []PasswordEntry) GeneratorModel {
+	m := GeneratorModel{Options: opts, entries: entries}
+
+	m.exclude = textinput.New()
+	m.exclude.Prompt = "Exclude: "
+	m.exclude.Placeholder = "characters that must not appear"
+
+	m.target = textinput.New()
+	m.target.Prompt = "Entry: "
+	m.target.Placeholder = "source/username"
+	m.target.ShowSuggestions = true
+	suggestions := make([]string, len(entries))
+	for i, e := range entries {
+		suggestions[i] = e.Source + "/" + e.Username
+	}
+	m.target.SetSuggestions(suggestions)
+
+	m.regenerate()
+	return m
+}
+
+func (m *GeneratorModel) regenerate() {
+	m.Password, m.err = m.Options.Generate()
+}
+
+func (m GeneratorModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m GeneratorModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		if m.step == generatorStore {
+			return m.updateStore(msg)
+		}
+		return m, nil
+	}
+	if keyMsg.String() == "ctrl+c" {
+		m.Action = GeneratorCancelled
+		return m, tea.Quit
+	}
+
+	switch m.step {
+	case generatorStore:
+		return m.updateStore(msg)
+	case generatorExclude:
+		return m.updateExclude(keyMsg)
+	case generatorTarget:
+		return m.updateTarget(keyMsg)
+	}
+
+	m.status = ""
+	switch keyMsg.String() {
+	case "q", "esc":
+		return m, tea.Quit
+	case "r", " ":
+	case "right", "+", "=":
+		if m.Options.Passphrase {
+			m.Options.Words++
+		} else {
+			m.Options.Length++
+		}
+	case "left", "-":
+		if m.Options.Passphrase {
+			m.Options.Words = max(m.Options.Words-1, 1)
+		} else {
+			m.Options.Length = max(m.Options.Length-1, 1)
+		}
+	case "l":
+		m.Options.Lower = !m.Options.Lower
+	case "u":
+		m.Options.Upper = !m.Options.Upper
+	case "d":
+		m.Options.Digits = !m.Options.Digits
+	case "s":
+		m.Options.Special = !m.Options.Special
+	case "a":
+		m.Options.ExcludeAmbiguous = !m.Options.ExcludeAmbiguous
+	case "p":
+		m.Options.Passphrase = !m.Options.Passphrase
+	case "x":
+		m.step = generatorExclude
+		m.exclude.SetValue(m.Options.Exclude)
+		return m, m.exclude.Focus()
+	case "c":
+		if m.err != nil {
+			return m, nil
+		}
+		if err := clipboard.WriteAll(m.Password); err != nil {
+			m.status = StyleError.Render("❌ Failed to copy password to clipboard: " + err.Error())
+		} else {
+			m.status = StyleSuccess.Render("📋 Password copied to clipboard.")
+		}
+		return m, nil
+	case "n", "enter":
+		if m.err != nil {
+			return m, nil
+		}
+		m.step = generatorStore
+		m.Store = initialStorePasswordModel(m.Password, m.entries)
+		return m, m.Store.Init()
+	case "e":
+		if m.err != nil {
+			return m, nil
+		}
+		if len(m.entries) == 0 {
+			m.status = StyleError.Render("❌ There are no entries to apply the password to")
+			return m, nil
+		}
+		m.step = generatorTarget
+		return m, m.target.Focus()
+	default:
+		return m, nil
+	}
+	m.regenerate()
+	return m, nil
+}
+
+// applyPolicy switches to the generator policy of an entry, once it is
+// known, and generates a new password with it. It reports whether the
+// password changed.
+func (m *GeneratorModel) applyPolicy(source, username, url string) bool {
+	if m.Policy == nil {
+		return false
+	}
+	opts, name := m.Policy(source, username, url)
+	if name == "" || name == m.PolicyName {
+		return false
+	}
+	m.Options, m.PolicyName = opts, name
+	m.regenerate()
+	m.Store.password = m.Password
+	m.status = StyleInfo.Render("ℹ️ Using the generator policy of " + name + ": " + opts.String())
+	return true
+}
+
+// updateStore passes messages to the embedded store form. The form quits
+// the program when it is done; here it returns to the generator or
+// finishes with the entry instead.
+func (m GeneratorModel) updateStore(msg tea.Msg) (tea.Model, tea.Cmd) {
+	model, cmd := m.Store.Update(msg)
+	m.Store = model.(StorePasswordModel)
+	c := m.Store.Credential()
+	m.applyPolicy(c.Source, c.Username, c.URL)
+	switch {
+	case m.Store.Cancelled:
+		m.step = generatorTweak
+		return m, nil
+	case m.Store.Submitted:
+		m.Action = GeneratorSave
+		return m, tea.Quit
+	}
+	return m, cmd
+}
+
+func (m GeneratorModel) updateExclude(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "enter":
+		m.Options.Exclude = m.exclude.Value()
+		m.regenerate()
+		fallthrough
+	case "esc":
+		m.exclude.Blur()
+		m.step = generatorTweak
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.exclude, cmd = m.exclude.Update(msg)
+	return m, cmd
+}
+
+func (m GeneratorModel) updateTarget(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.target.Blur()
+		m.step = generatorTweak
+		return m, nil
+	case "enter":
+		target := strings.TrimSpace(m.target.Value())
+		for _, e := range m.entries {
+			if e.Source+"/"+e.Username != target {
+				continue
+			}
+			// A policy generates another password, which has to be seen
+			// before it replaces the stored one.
+			if m.applyPolicy(e.Source, e.Username, e.URL) {
+				if m.err == nil {
+					m.status += "\n" + StylePrompt.Render("Press enter again to replace the password of "+target+" with this one")
+				}
+				return m, nil
+			}
+			if m.err != nil {
+				m.status = StyleError.Render("❌ No password to apply, change the options first")
+				return m, nil
+			}
+			m.Target = target
+			m.Action = GeneratorApply
+			return m, tea.Quit
+		}
+		m.status = StyleError.Render("❌ No password found for " + target)
+		return m, nil
+	}
+	m.status = ""
+	var cmd tea.Cmd
+	m.target, cmd = m.target.Update(msg)
+	return m, cmd
+}
+
+func (m GeneratorModel) View() string {
+	var b strings.Builder
+	b.WriteString(StyleHeading.Render("🔐 Password Generator") + "\n\n")
+	if m.err != nil {
+		b.WriteString(StyleError.Render("❌ "+m.err.Error()) + "\n\n")
+	} else {
+		b.WriteString(StylePassword.Render(m.Password) + "\n\n")
+		b.WriteString("Strength " + EntropyMeter(m.Options.Entropy(), 32) + "\n\n")
+	}
+
+	if m.step == generatorStore {
+		b.WriteString(StylePrompt.Render("Store the password (esc to go back):") + "\n")
+		b.WriteString(m.Store.View())
+		if m.status != "" {
+			b.WriteString("\n\n" + m.status)
+		}
+		return DocStyle.Render(b.String())
+	}
+
+	check := func(on bool) string {
+		if on {
+			return StyleSuccess.Render("[x]")
+		}
+		return "[ ]"
+	}
+	o := m.Options
+	if o.Passphrase {
+		b.WriteString(fmt.Sprintf("Words     %d  (←/→)\n", o.Words))
+	} else {
+		b.WriteString(fmt.Sprintf("Length    %d  (←/→)\n", o.Length))
+		b.WriteString(fmt.Sprintf("%s l lowercase  %s u uppercase  %s d digits  %s s special\n",
+			check(o.Lower), check(o.Upper), check(o.Digits), check(o.Special)))
+		b.WriteString(fmt.Sprintf("%s a exclude ambiguous characters (%s)\n", check(o.ExcludeAmbiguous), AmbiguousChars))
+		exclude := o.Exclude
+		if exclude == "" {
+			exclude = "none"
+		}
+		b.WriteString(fmt.Sprintf("    x excluded characters: %s\n", exclude))
+	}
+	b.WriteString(fmt.Sprintf("%s p passphrase\n\n", check(o.Passphrase)))
+
+	switch m.step {
+	case generatorExclude:
+		b.WriteString(m.exclude.View() + "\n" + StylePrompt.Render("enter apply • esc cancel") + "\n")
+	case generatorTarget:
+		b.WriteString(m.target.View() + "\n" + StylePrompt.Render("tab complete • enter replace the password of this entry • esc cancel") + "\n")
+	default:
+		b.WriteString(StylePrompt.Render("r regenerate • c copy • n save as new entry • e apply to existing entry • q quit") + "\n")
+	}
+	if m.status != "" {
+		b.WriteString("\n" + m.status)
+	}
+	return DocStyle.Render(b.String())
+}
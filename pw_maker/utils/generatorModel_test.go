@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestGeneratorConfirmsPolicyBeforeApplying(t *testing.T) {
+	entries := []PasswordEntry{{ID: 1, Source: "bank", Username: "alice"}}
+	m := NewGeneratorModel(GeneratorOptions{Length: 12, Lower: true}, entries)
+	m.Policy = func(source, username, url string) (GeneratorOptions, string) {
+		return GeneratorOptions{Length: 8, Digits: true}, "bank"
+	}
+	key := func(m GeneratorModel, keys ...string) (GeneratorModel, tea.Cmd) {
+		var cmd tea.Cmd
+		for _, k := range keys {
+			msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+			if k == "enter" {
+				msg = tea.KeyMsg{Type: tea.KeyEnter}
+			}
+			var model tea.Model
+			model, cmd = m.Update(msg)
+			m = model.(GeneratorModel)
+		}
+		return m, cmd
+	}
+
+	m, _ = key(m, "e", "b", "a", "n", "k", "/", "a", "l", "i", "c", "e", "enter")
+	if m.Action != GeneratorCancelled || m.PolicyName != "bank" {
+		t.Fatalf("the policy password was applied without being shown: action %v, policy %q", m.Action, m.PolicyName)
+	}
+	if len(m.Password) != 8 {
+		t.Fatalf("got password %q, want one generated with the policy", m.Password)
+	}
+	shown := m.Password
+
+	m, cmd := key(m, "enter")
+	if m.Action != GeneratorApply || m.Target != "bank/alice" || m.Password != shown || cmd == nil {
+		t.Errorf("confirming: got action %v, target %q, password %q, want the shown %q applied", m.Action, m.Target, m.Password, shown)
+	}
+}
+
+func TestGeneratorRefusesToApplyWithoutPassword(t *testing.T) {
+	entries := []PasswordEntry{{ID: 1, Source: "bank", Username: "alice"}}
+	m := NewGeneratorModel(GeneratorOptions{Length: 12, Lower: true}, entries)
+	m.Policy = func(source, username, url string) (GeneratorOptions, string) {
+		// No character class is left, so no password can be generated.
+		return GeneratorOptions{Length: 8, Digits: true, Exclude: "0123456789"}, "bank"
+	}
+	m.step = generatorTarget
+	m.target.SetValue("bank/alice")
+
+	for i := 0; i < 2; i++ {
+		model, _ := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+		m = model.(GeneratorModel)
+	}
+	if m.err == nil || m.Action != GeneratorCancelled {
+		t.Errorf("got action %v with error %v, want nothing applied", m.Action, m.err)
+	}
+}
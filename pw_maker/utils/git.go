@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// GitRepo runs the git command line client in a working directory.
+type GitRepo struct {
+	Dir string
+}
+
+// Git runs git with args in the repository and returns its trimmed output.
+func (r GitRepo) Git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", r.Dir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// RevParse resolves rev to a commit id, returning "" when it does not exist.
+func (r GitRepo) RevParse(rev string) string {
+	out, err := r.Git("rev-parse", "--verify", "-q", rev+"^{commit}")
+	if err != nil {
+		return ""
+	}
+	return out
+}
+
+// ReadTree returns the contents of the files below dir in commit, keyed by
+// their path relative to dir.
+func (r GitRepo) ReadTree(commit, dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if commit == "" {
+		return files, nil
+	}
+
+	out, err := r.Git("ls-tree", "-r", commit, "--", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names, blobs []string
+	for _, line := range strings.Split(out, "\n") {
+		// <mode> blob <object>\t<path>
+		meta, name, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		names = append(names, strings.TrimPrefix(name, dir+"/"))
+		blobs = append(blobs, fields[2])
+	}
+	if len(blobs) == 0 {
+		return files, nil
+	}
+
+	cmd := exec.Command("git", "-C", r.Dir, "cat-file", "--batch")
+	cmd.Stdin = strings.NewReader(strings.Join(blobs, "\n") + "\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git cat-file: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	// Each object is "<object> <type> <size>\n<content>\n".
+	reader := bufio.NewReader(&stdout)
+	for _, name := range names {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("git cat-file: %w", err)
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("git cat-file: unexpected output %q", header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("git cat-file: unexpected output %q", header)
+		}
+		content := make([]byte, size+1)
+		if _, err := io.ReadFull(reader, content); err != nil {
+			return nil, fmt.Errorf("git cat-file: %w", err)
+		}
+		files[name] = content[:size]
+	}
+	return files, nil
+}
@@ -2,9 +2,13 @@ package utils
 
 import (
 	"fmt"
+	"net/url"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
@@ -12,31 +16,108 @@ import (
 )
 
 type PasswordEntry struct {
+	ID        int64
 	Source    string
 	Username  string
 	URL       string
+	Folder    string
+	Tags      []string
+	Notes     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// RotateDays is the rotation policy of the entry itself, RotationDue
+	// when the password has to be rotated by the policy that applies, if
+	// any.
+	RotateDays  int
+	RotationDue time.Time
+}
+
+// Credential is a full vault entry including its secret.
+type Credential struct {
+	ID        int64
+	Source    string
+	Username  string
+	Password  string
+	URL       string
+	Folder    string
+	Tags      []string
+	Notes     string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
 type ListItem struct {
+	ID        int64
 	Source    string
 	Username  string
 	URL       string
+	Folder    string
+	Tags      []string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	// RotationDue is when the password has to be rotated, if ever.
+	RotationDue time.Time
+	// Indent is the depth of the entry in the tree view.
+	Indent int
+	// Matched are the characters of each field matched by the search.
+	Matched map[string][]int
 }
 
 func (i ListItem) Title() string {
-	return fmt.Sprintf("Source: %s | Username: %s", i.Source, i.Username)
+	return treeIndent(i.Indent) + fmt.Sprintf("Source: %s | Username: %s",
+		Highlight(i.Source, i.Matched[SearchFieldSource], StyleMatch),
+		Highlight(i.Username, i.Matched[SearchFieldUsername], StyleMatch))
 }
 
 func (i ListItem) Description() string {
-	return fmt.Sprintf("URL: %s | Created: %s | Updated: %s", i.URL, i.CreatedAt.Format("2006-01-02 15:04:05"), i.UpdatedAt.Format("2006-01-02 15:04:05"))
+	description := fmt.Sprintf("URL: %s | Created: %s | Updated: %s", Highlight(i.URL, i.Matched[SearchFieldURL], StyleMatch),
+		i.CreatedAt.Format("2006-01-02 15:04:05"), i.UpdatedAt.Format("2006-01-02 15:04:05"))
+	// The tree view shows the folder already.
+	if i.Folder != "" && i.Indent == 0 {
+		description = "Folder: " + Highlight(i.Folder, i.Matched[SearchFieldFolder], StyleMatch) + " | " + description
+	}
+	if len(i.Tags) > 0 {
+		description += " | #" + strings.Join(i.Tags, " #")
+	}
+	if !i.RotationDue.IsZero() && i.RotationDue.Before(time.Now()) {
+		description += " | " + StyleError.Render("⏰ rotation overdue since "+i.RotationDue.Local().Format("2006-01-02"))
+	}
+	return treeIndent(i.Indent) + description
 }
 
 func (i ListItem) FilterValue() string {
-	return i.Source + i.Username + i.URL
+	return i.Folder + i.Source + i.Username + i.URL
+}
+
+// FolderItem is a folder in the tree view of the search list.
+type FolderItem struct {
+	Path      string
+	Count     int
+	Collapsed bool
+}
+
+func (f FolderItem) depth() int {
+	return strings.Count(f.Path, "/")
+}
+
+func (f FolderItem) Title() string {
+	icon := "📂 "
+	if f.Collapsed {
+		icon = "📁 "
+	}
+	return treeIndent(f.depth()) + icon + FolderName(f.Path)
+}
+
+func (f FolderItem) Description() string {
+	return treeIndent(f.depth()) + fmt.Sprintf("%d passwords", f.Count)
+}
+
+func (f FolderItem) FilterValue() string {
+	return f.Path
+}
+
+func treeIndent(depth int) string {
+	return strings.Repeat("   ", depth)
 }
 
 var docStyle = lipgloss.NewStyle().Margin(1, 2)
@@ -44,7 +125,70 @@ var docStyle = lipgloss.NewStyle().Margin(1, 2)
 func ConvertToListItems(entries []PasswordEntry) []list.Item {
 	items := make([]list.Item, len(entries))
 	for i, entry := range entries {
-		items[i] = ListItem(entry)
+		items[i] = newListItem(entry, 0)
+	}
+	return items
+}
+
+func newListItem(entry PasswordEntry, indent int) ListItem {
+	return ListItem{
+		ID:          entry.ID,
+		Source:      entry.Source,
+		Username:    entry.Username,
+		URL:         entry.URL,
+		Folder:      entry.Folder,
+		Tags:        entry.Tags,
+		CreatedAt:   entry.CreatedAt,
+		UpdatedAt:   entry.UpdatedAt,
+		RotationDue: entry.RotationDue,
+		Indent:      indent,
+	}
+}
+
+// ConvertToMatchItems lists search matches in their ranked order with the
+// matched characters highlighted.
+func ConvertToMatchItems(matches []SearchMatch) []list.Item {
+	items := make([]list.Item, len(matches))
+	for i, match := range matches {
+		item := newListItem(match.Entry, 0)
+		item.Matched = match.Matched
+		items[i] = item
+	}
+	return items
+}
+
+// ConvertToTreeItems lists the entries below their folders. The entries of
+// collapsed folders are left out.
+func ConvertToTreeItems(entries []PasswordEntry, collapsed map[string]bool) []list.Item {
+	sorted := SortByFolder(entries)
+	counts := make(map[string]int)
+	for _, e := range sorted {
+		for _, path := range FolderAncestors(e.Folder) {
+			counts[path]++
+		}
+	}
+
+	var items []list.Item
+	shown := make(map[string]bool)
+	for _, e := range sorted {
+		hidden := false
+		for _, path := range FolderAncestors(e.Folder) {
+			if !shown[path] {
+				shown[path] = true
+				items = append(items, FolderItem{Path: path, Count: counts[path], Collapsed: collapsed[path]})
+			}
+			if collapsed[path] {
+				hidden = true
+				break
+			}
+		}
+		if !hidden {
+			depth := 0
+			if e.Folder != "" {
+				depth = strings.Count(e.Folder, "/") + 1
+			}
+			items = append(items, newListItem(e, depth))
+		}
 	}
 	return items
 }
@@ -55,6 +199,8 @@ type SearchModel struct {
 	list         list.Model
 	SelectedItem list.Item
 	focused      string // "input" or "list"
+	tree         bool
+	collapsed    map[string]bool
 }
 
 // Add this method to the searchModel struct
@@ -62,32 +208,83 @@ func (m SearchModel) Init() tea.Cmd {
 	return textinput.Blink
 }
 
+// The fields of the store form.
+const (
+	storeUsername = iota
+	storeSource
+	storeURL
+	storeFolder
+	storeTags
+	storeNotes
+)
+
+var storeLabels = []string{"Username", "Source", "URL", "Folder", "Tags", "Notes"}
+
+// StorePasswordModel is the form that stores a password. It validates the
+// fields as they are left, completes sources, usernames and folders from
+// entries and shows what changes when an entry is overwritten.
 type StorePasswordModel struct {
 	textInputs []textinput.Model
 	password   string
 	focusIndex int
+	entries    []PasswordEntry
+	// touched fields show their validation errors.
+	touched []bool
+	// existing is the entry with the entered source, username and URL,
+	// filled from once per entry.
+	existing  *PasswordEntry
+	prefilled string
+	confirm   bool
+	// Submitted is set when the form was sent with Store, Cancelled when it
+	// was left with esc.
+	Submitted bool
+	Cancelled bool
 }
 
-func initialStorePasswordModel(password string) StorePasswordModel {
+func initialStorePasswordModel(password string, entries []PasswordEntry) StorePasswordModel {
 	m := StorePasswordModel{
-		textInputs: make([]textinput.Model, 3),
+		textInputs: make([]textinput.Model, len(storeLabels)),
 		password:   password,
 		focusIndex: 0,
+		entries:    entries,
+		touched:    make([]bool, len(storeLabels)),
+	}
+
+	var sources, usernames, folders []string
+	for _, e := range entries {
+		sources = append(sources, e.Source)
+		usernames = append(usernames, e.Username)
+		folders = append(folders, FolderAncestors(e.Folder)...)
 	}
 
 	var t textinput.Model
 	for i := range m.textInputs {
 		t = textinput.New()
-		t.CharLimit = 100
+		t.Prompt = fmt.Sprintf("%-9s ", storeLabels[i])
+		t.KeyMap.AcceptSuggestion = key.NewBinding(key.WithKeys("right"))
+		t.KeyMap.NextSuggestion = key.NewBinding(key.WithKeys("ctrl+n"))
+		t.KeyMap.PrevSuggestion = key.NewBinding(key.WithKeys("ctrl+p"))
 
 		switch i {
-		case 0:
+		case storeUsername:
 			t.Placeholder = "Enter username"
+			t.ShowSuggestions = true
+			t.SetSuggestions(uniqueSorted(usernames))
 			t.Focus()
-		case 1:
+		case storeSource:
 			t.Placeholder = "Enter source (e.g., website name, database name)"
-		case 2:
+			t.ShowSuggestions = true
+			t.SetSuggestions(uniqueSorted(sources))
+		case storeURL:
 			t.Placeholder = "Enter URL"
+		case storeFolder:
+			t.Placeholder = "work/aws"
+			t.ShowSuggestions = true
+			t.SetSuggestions(uniqueSorted(folders))
+		case storeTags:
+			t.Placeholder = "comma or space separated"
+		case storeNotes:
+			t.Placeholder = "anything else worth remembering"
 		}
 
 		m.textInputs[i] = t
@@ -96,6 +293,11 @@ func initialStorePasswordModel(password string) StorePasswordModel {
 	return m
 }
 
+func uniqueSorted(values []string) []string {
+	sort.Strings(values)
+	return slices.Compact(values)
+}
+
 func (m StorePasswordModel) Init() tea.Cmd {
 	return textinput.Blink
 }
@@ -103,14 +305,20 @@ func (m StorePasswordModel) Init() tea.Cmd {
 func (m StorePasswordModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		switch msg.String() {
+		s := msg.String()
+		if s != "enter" {
+			m.confirm = false
+		}
+		switch s {
 		case "ctrl+c", "esc":
+			m.Cancelled = true
 			return m, tea.Quit
 		case "tab", "shift+tab", "enter", "up", "down":
-			s := msg.String()
-
 			if s == "enter" && m.focusIndex == len(m.textInputs) {
-				return m, tea.Quit
+				return m.submit()
+			}
+			if m.focusIndex < len(m.textInputs) {
+				m.touched[m.focusIndex] = true
 			}
 
 			if s == "up" || s == "shift+tab" {
@@ -124,24 +332,49 @@ func (m StorePasswordModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			} else if m.focusIndex < 0 {
 				m.focusIndex = len(m.textInputs)
 			}
-
-			cmds := make([]tea.Cmd, len(m.textInputs))
-			for i := 0; i <= len(m.textInputs)-1; i++ {
-				if i == m.focusIndex {
-					cmds[i] = m.textInputs[i].Focus()
-					continue
-				}
-				m.textInputs[i].Blur()
-			}
-
-			return m, tea.Batch(cmds...)
+			m.prefill()
+			return m, m.focus()
 		}
 	}
 
 	cmd := m.updateInputs(msg)
+	m.existing = m.findExisting()
 
 	return m, cmd
 }
+
+// submit sends the form when it is valid. Overwriting an existing entry
+// has to be confirmed by pressing enter again.
+func (m StorePasswordModel) submit() (tea.Model, tea.Cmd) {
+	for i := range m.touched {
+		m.touched[i] = true
+	}
+	for i := range m.textInputs {
+		if m.fieldError(i) != "" {
+			m.focusIndex = i
+			return m, m.focus()
+		}
+	}
+	if m.existing != nil && !m.confirm {
+		m.confirm = true
+		return m, nil
+	}
+	m.Submitted = true
+	return m, tea.Quit
+}
+
+func (m *StorePasswordModel) focus() tea.Cmd {
+	cmds := make([]tea.Cmd, len(m.textInputs))
+	for i := 0; i <= len(m.textInputs)-1; i++ {
+		if i == m.focusIndex {
+			cmds[i] = m.textInputs[i].Focus()
+			continue
+		}
+		m.textInputs[i].Blur()
+	}
+	return tea.Batch(cmds...)
+}
+
 func (m *StorePasswordModel) updateInputs(msg tea.Msg) tea.Cmd {
 	cmds := make([]tea.Cmd, len(m.textInputs))
 
@@ -152,37 +385,144 @@ func (m *StorePasswordModel) updateInputs(msg tea.Msg) tea.Cmd {
 	return tea.Batch(cmds...)
 }
 
+func (m StorePasswordModel) findExisting() *PasswordEntry {
+	c := m.Credential()
+	for i, e := range m.entries {
+		if e.Source == c.Source && e.Username == c.Username && e.URL == c.URL {
+			return &m.entries[i]
+		}
+	}
+	return nil
+}
+
+// prefill fills the empty fields from an existing entry once it is known,
+// so that overwriting it only changes what was entered.
+func (m *StorePasswordModel) prefill() {
+	if m.existing == nil || m.focusIndex <= storeURL {
+		return
+	}
+	name := m.existing.Source + "/" + m.existing.Username + " " + m.existing.URL
+	if m.prefilled == name {
+		return
+	}
+	m.prefilled = name
+	for i, value := range []string{storeFolder: m.existing.Folder,
+		storeTags: strings.Join(m.existing.Tags, ", "), storeNotes: m.existing.Notes} {
+		if i >= storeFolder && m.textInputs[i].Value() == "" {
+			m.textInputs[i].SetValue(value)
+		}
+	}
+}
+
+// fieldError returns why the value of field i cannot be stored.
+func (m StorePasswordModel) fieldError(i int) string {
+	value := strings.TrimSpace(m.textInputs[i].Value())
+	switch i {
+	case storeUsername, storeSource:
+		if value == "" {
+			return strings.ToLower(storeLabels[i]) + " is required"
+		}
+		if strings.Contains(value, "/") {
+			return strings.ToLower(storeLabels[i]) + " cannot contain /"
+		}
+	case storeURL:
+		if value == "" {
+			return ""
+		}
+		u, err := url.Parse(BeautifyURL(value))
+		if err != nil || u.Host == "" || strings.ContainsAny(value, " \t") {
+			return "not a valid URL"
+		}
+	case storeFolder:
+		if _, err := NormalizeFolder(value); err != nil {
+			return "folders cannot contain . or .."
+		}
+	}
+	return ""
+}
+
+// Credential returns the entry entered in the form, without its ID.
+func (m StorePasswordModel) Credential() Credential {
+	value := func(i int) string {
+		return strings.TrimSpace(m.textInputs[i].Value())
+	}
+	folder, _ := NormalizeFolder(value(storeFolder))
+	return Credential{
+		Source:   value(storeSource),
+		Username: value(storeUsername),
+		Password: m.password,
+		URL:      BeautifyURL(value(storeURL)),
+		Folder:   folder,
+		Tags:     ParseTags(value(storeTags)),
+		Notes:    value(storeNotes),
+	}
+}
+
+// changes lists how storing the form changes the existing entry.
+func (m StorePasswordModel) changes() []string {
+	e, c := m.existing, m.Credential()
+	changes := []string{"password: replaced, the current one is kept in the history"}
+	for _, field := range []struct{ name, old, new string }{
+		{"folder", e.Folder, c.Folder},
+		{"tags", strings.Join(e.Tags, ", "), strings.Join(c.Tags, ", ")},
+		{"notes", e.Notes, c.Notes},
+	} {
+		if field.old != field.new {
+			changes = append(changes, fmt.Sprintf("%s: %q → %q", field.name, field.old, field.new))
+		}
+	}
+	return changes
+}
+
 func (m StorePasswordModel) View() string {
 	var b strings.Builder
 
 	for i := range m.textInputs {
 		b.WriteString(m.textInputs[i].View())
-		if i < len(m.textInputs)-1 {
-			b.WriteRune('\n')
+		if err := m.fieldError(i); err != "" && m.touched[i] {
+			b.WriteString("\n" + StyleError.Render("          ❌ "+err))
 		}
+		b.WriteRune('\n')
 	}
 
-	button := "\n[ Store ]"
+	label := "Store"
+	if m.existing != nil {
+		label = "Overwrite"
+		b.WriteString("\n" + StyleError.Render("⚠️  "+m.existing.Source+"/"+m.existing.Username+" already exists, storing changes:") + "\n")
+		for _, change := range m.changes() {
+			b.WriteString("   " + change + "\n")
+		}
+	}
+	button := "\n[ " + label + " ]"
 	if m.focusIndex == len(m.textInputs) {
-		button = "\n[ " + StyleSuccess.Render("Store") + " ]"
+		button = "\n[ " + StyleSuccess.Render(label) + " ]"
 	}
 	b.WriteString(button)
+	if m.confirm {
+		b.WriteString("  " + StyleError.Render("press enter again to overwrite"))
+	}
+	b.WriteString("\n\n" + StylePrompt.Render("tab/↓ next • shift+tab/↑ previous • → accept suggestion • ctrl+n/ctrl+p other suggestions • esc cancel"))
 
 	return b.String()
 }
 
 func InitialSearchModel(entries []PasswordEntry) SearchModel {
 	m := SearchModel{
-		entries: entries,
-		focused: "input",
+		entries:   entries,
+		focused:   "input",
+		collapsed: make(map[string]bool),
+	}
+	for _, e := range entries {
+		if e.Folder != "" {
+			m.tree = true
+			break
+		}
 	}
 
 	m.searchInput = textinput.New()
-	m.searchInput.Placeholder = "Search passwords..."
+	m.searchInput.Placeholder = "Search passwords... (user:, url:, folder:, tag:, -tag:)"
 	m.searchInput.Focus()
 
-	items := ConvertToListItems(entries)
-
 	delegate := list.NewDefaultDelegate()
 	delegate.Styles.SelectedTitle = delegate.Styles.SelectedTitle.
 		Foreground(lipgloss.Color("#FF00FF")).
@@ -195,7 +535,8 @@ func InitialSearchModel(entries []PasswordEntry) SearchModel {
 
 	delegate.SetHeight(3) // Increase height to accommodate two lines
 
-	m.list = list.New(items, delegate, 0, 0)
+	m.list = list.New(nil, delegate, 0, 0)
+	m.setItems(entries)
 	m.list.Title = "Passwords"
 	m.list.SetStatusBarItemName("password", "passwords")
 	m.list.SetSize(100, 20) // Adjust width and height as needed
@@ -217,9 +558,16 @@ func (m SearchModel) View() string {
 		b.WriteString(m.searchInput.View())
 	}
 	b.WriteString("\n\n")
+	if n := CountOverdue(m.entries, time.Now()); n > 0 {
+		b.WriteString(OverdueBanner(n) + "\n\n")
+	}
 
 	if m.focused == "list" {
-		b.WriteString(StyleSuccess.Render("(Use arrow keys to navigate, Enter to select)\n"))
+		help := "(Use arrow keys to navigate, Enter to select, Ctrl+T for the tree view)\n"
+		if m.tree {
+			help = "(Use arrow keys to navigate, Enter to select or fold a folder, Ctrl+T for the flat list)\n"
+		}
+		b.WriteString(StyleSuccess.Render(help))
 	}
 
 	b.WriteString(m.list.View())
@@ -243,6 +591,11 @@ func (m SearchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			if m.focused == "list" {
 				selectedItem := m.list.SelectedItem()
+				if folder, ok := selectedItem.(FolderItem); ok {
+					m.collapsed[folder.Path] = !m.collapsed[folder.Path]
+					m.filterList()
+					return m, nil
+				}
 				if selectedItem != nil {
 					m.SelectedItem = selectedItem
 					return m, tea.Quit
@@ -255,6 +608,10 @@ func (m SearchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			} else {
 				return m, tea.Quit
 			}
+		case "ctrl+t":
+			m.tree = !m.tree
+			m.filterList()
+			return m, nil
 		case "tab":
 			if m.focused == "input" {
 				m.focused = "list"
@@ -285,20 +642,23 @@ func (m SearchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+// filterList shows the entries matching the query, best match first, or
+// every entry when there is no query.
 func (m *SearchModel) filterList() {
-	if m.searchInput.Value() == "" {
-		m.list.SetItems(ConvertToListItems(m.entries))
+	query := ParseSearchQuery(m.searchInput.Value())
+	if len(query) == 0 {
+		m.setItems(m.entries)
 		return
 	}
+	m.list.SetItems(ConvertToMatchItems(SearchEntries(m.entries, query)))
+	m.list.Select(0)
+}
 
-	pattern := strings.ToLower(m.searchInput.Value())
-	var filtered []list.Item
-	for _, entry := range m.entries {
-		if strings.Contains(strings.ToLower(entry.Source), pattern) ||
-			strings.Contains(strings.ToLower(entry.Username), pattern) ||
-			strings.Contains(strings.ToLower(entry.URL), pattern) {
-			filtered = append(filtered, ListItem(entry))
-		}
+// setItems shows entries as a flat list or as a tree.
+func (m *SearchModel) setItems(entries []PasswordEntry) {
+	if !m.tree {
+		m.list.SetItems(ConvertToListItems(entries))
+		return
 	}
-	m.list.SetItems(filtered)
+	m.list.SetItems(ConvertToTreeItems(entries, m.collapsed))
 }
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DefaultGeneratorOptions generate the passwords of entries without a
+// generator policy: 16 characters of every class. Passphrases have 8 words,
+// about 82 bits with the embedded word list.
+var DefaultGeneratorOptions = GeneratorOptions{Length: 16, Lower: true, Upper: true, Digits: true, Special: true, Words: 8, Separator: "-"}
+
+// String describes the passwords the options generate.
+func (o GeneratorOptions) String() string {
+	if o.Passphrase {
+		return fmt.Sprintf("passphrase of %d words separated by %q", o.Words, o.Separator)
+	}
+	var classes []string
+	for _, class := range []struct {
+		enabled bool
+		name    string
+	}{{o.Lower, "lowercase"}, {o.Upper, "uppercase"}, {o.Digits, "digits"}, {o.Special, "special"}} {
+		if class.enabled {
+			classes = append(classes, class.name)
+		}
+	}
+	s := fmt.Sprintf("%d characters, %s", o.Length, strings.Join(classes, ", "))
+	if o.ExcludeAmbiguous {
+		s += ", no ambiguous characters"
+	}
+	if o.Exclude != "" {
+		s += fmt.Sprintf(", excluding %q", o.Exclude)
+	}
+	return s
+}
+
+// EncodeGeneratorPolicy returns options the way they are stored.
+func EncodeGeneratorPolicy(o GeneratorOptions) string {
+	data, _ := json.Marshal(o)
+	return string(data)
+}
+
+// DecodeGeneratorPolicy reads options stored by EncodeGeneratorPolicy.
+func DecodeGeneratorPolicy(s string) (GeneratorOptions, error) {
+	var o GeneratorOptions
+	err := json.Unmarshal([]byte(s), &o)
+	return o, err
+}
+
+// PolicyDomain returns the lowercased host name of a URL or domain, or ""
+// when it has none.
+func PolicyDomain(rawURL string) string {
+	u, err := url.Parse(BeautifyURL(strings.TrimSpace(rawURL)))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+}
+
+// GeneratorPolicy returns the options to generate the password of an entry
+// with: its own policy, else the policy of the domain of url or of a parent
+// domain, else DefaultGeneratorOptions. name tells which policy applies and
+// is "" for the default. An empty source skips the entry policy.
+func GeneratorPolicy(db Queryer, source, username, rawURL string) (GeneratorOptions, string, error) {
+	var stored string
+	if source != "" {
+		err := db.QueryRow("SELECT generator_policy FROM passwords WHERE source = ? AND username = ? AND deleted_at IS NULL",
+			source, username).Scan(&stored)
+		if err != nil && err != sql.ErrNoRows {
+			return GeneratorOptions{}, "", err
+		}
+		if stored != "" {
+			o, err := DecodeGeneratorPolicy(stored)
+			return o, source + "/" + username, err
+		}
+	}
+
+	domain := PolicyDomain(rawURL)
+	for domain != "" {
+		err := db.QueryRow("SELECT options FROM generator_policies WHERE domain = ?", domain).Scan(&stored)
+		if err == nil {
+			o, err := DecodeGeneratorPolicy(stored)
+			return o, domain, err
+		}
+		if err != sql.ErrNoRows {
+			return GeneratorOptions{}, "", err
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok || !strings.Contains(parent, ".") {
+			break
+		}
+		domain = parent
+	}
+	return DefaultGeneratorOptions, "", nil
+}
+
+// SetDomainGeneratorPolicy stores the policy of a domain and its
+// subdomains, nil removes it.
+func SetDomainGeneratorPolicy(db Execer, domain string, o *GeneratorOptions) error {
+	if o == nil {
+		_, err := db.Exec("DELETE FROM generator_policies WHERE domain = ?", domain)
+		return err
+	}
+	_, err := db.Exec(`INSERT INTO generator_policies (domain, options) VALUES (?, ?)
+		ON CONFLICT(domain) DO UPDATE SET options = excluded.options`, domain, EncodeGeneratorPolicy(*o))
+	return err
+}
+
+// DomainGeneratorPolicies returns the policies of domains, sorted by
+// domain.
+func DomainGeneratorPolicies(db *sql.DB) ([]string, map[string]GeneratorOptions, error) {
+	rows, err := db.Query("SELECT domain, options FROM generator_policies")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var domains []string
+	policies := make(map[string]GeneratorOptions)
+	for rows.Next() {
+		var domain, stored string
+		if err := rows.Scan(&domain, &stored); err != nil {
+			return nil, nil, err
+		}
+		o, err := DecodeGeneratorPolicy(stored)
+		if err != nil {
+			return nil, nil, fmt.Errorf("policy of %s: %w", domain, err)
+		}
+		domains = append(domains, domain)
+		policies[domain] = o
+	}
+	sort.Strings(domains)
+	return domains, policies, rows.Err()
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	ResolutionSkip      = "skip"
+	ResolutionOverwrite = "overwrite"
+	ResolutionKeepBoth  = "keep-both"
+)
+
+// Conflict is an incoming entry whose password differs from the stored one
+// without being known to be newer.
+type Conflict struct {
+	Source           string
+	Username         string
+	URL              string
+	LocalPassword    string
+	IncomingPassword string
+	LocalUpdated     time.Time
+	IncomingUpdated  time.Time
+	Resolution       string
+}
+
+// ConflictReviewModel lets the user resolve conflicts one by one.
+type ConflictReviewModel struct {
+	Conflicts []Conflict
+	Aborted   bool
+	cursor    int
+	reveal    bool
+}
+
+func NewConflictReviewModel(conflicts []Conflict) ConflictReviewModel {
+	return ConflictReviewModel{Conflicts: conflicts}
+}
+
+func (m ConflictReviewModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m ConflictReviewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+
+	switch keyMsg.String() {
+	case "ctrl+c", "q", "esc":
+		m.Aborted = true
+		return m, tea.Quit
+	case "left", "h", "shift+tab":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case "right", "l", "tab":
+		if m.cursor < len(m.Conflicts)-1 {
+			m.cursor++
+		}
+	case "r":
+		m.reveal = !m.reveal
+	case "o":
+		return m.resolve(ResolutionOverwrite, false)
+	case "s":
+		return m.resolve(ResolutionSkip, false)
+	case "b":
+		return m.resolve(ResolutionKeepBoth, false)
+	case "O":
+		return m.resolve(ResolutionOverwrite, true)
+	case "S":
+		return m.resolve(ResolutionSkip, true)
+	case "B":
+		return m.resolve(ResolutionKeepBoth, true)
+	case "enter":
+		if m.unresolved() == -1 {
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+// resolve applies resolution to the current conflict, or to it and every
+// remaining unresolved conflict, and moves on to the next open one.
+func (m ConflictReviewModel) resolve(resolution string, remaining bool) (tea.Model, tea.Cmd) {
+	m.Conflicts[m.cursor].Resolution = resolution
+	if remaining {
+		for i := m.cursor; i < len(m.Conflicts); i++ {
+			if m.Conflicts[i].Resolution == "" {
+				m.Conflicts[i].Resolution = resolution
+			}
+		}
+	}
+
+	next := m.unresolved()
+	if next == -1 {
+		return m, tea.Quit
+	}
+	m.cursor = next
+	return m, nil
+}
+
+func (m ConflictReviewModel) unresolved() int {
+	for i, c := range m.Conflicts {
+		if c.Resolution == "" {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m ConflictReviewModel) View() string {
+	if len(m.Conflicts) == 0 {
+		return ""
+	}
+
+	c := m.Conflicts[m.cursor]
+	mask := func(password string) string {
+		if m.reveal {
+			return password
+		}
+		return strings.Repeat("•", min(len(password), 16))
+	}
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "unknown"
+		}
+		return t.Local().Format("2006-01-02 15:04:05")
+	}
+
+	var b strings.Builder
+	b.WriteString(StyleHeading.Render(fmt.Sprintf("Conflict %d of %d", m.cursor+1, len(m.Conflicts))))
+	b.WriteString("\n\n")
+	b.WriteString(fmt.Sprintf("Source:   %s\nUsername: %s\nURL:      %s\n\n", c.Source, c.Username, c.URL))
+	b.WriteString(fmt.Sprintf("  Stored:   %s  (updated %s)\n", StylePassword.Render(mask(c.LocalPassword)), formatTime(c.LocalUpdated)))
+	b.WriteString(fmt.Sprintf("  Incoming: %s  (updated %s)\n\n", StylePassword.Render(mask(c.IncomingPassword)), formatTime(c.IncomingUpdated)))
+
+	if c.Resolution != "" {
+		b.WriteString(StyleSuccess.Render("Resolution: "+c.Resolution) + "\n\n")
+	}
+
+	b.WriteString(StylePrompt.Render("o overwrite • s skip • b keep both • O/S/B apply to all remaining"))
+	b.WriteString("\n")
+	b.WriteString(StylePrompt.Render("←/→ previous/next • r reveal passwords • q abort import"))
+
+	return DocStyle.Render(b.String())
+}
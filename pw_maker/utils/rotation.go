@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// FolderRotationPolicies returns the rotation policies of folders in days,
+// keyed by folder. The top level is "".
+func FolderRotationPolicies(db *sql.DB) (map[string]int, error) {
+	rows, err := db.Query("SELECT folder, days FROM rotation_policies")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := make(map[string]int)
+	for rows.Next() {
+		var folder string
+		var days int
+		if err := rows.Scan(&folder, &days); err != nil {
+			return nil, err
+		}
+		policies[folder] = days
+	}
+	return policies, rows.Err()
+}
+
+// SetFolderRotation sets the number of days after which the passwords in
+// folder and its subfolders have to be rotated. 0 removes the policy.
+func SetFolderRotation(db Execer, folder string, days int) error {
+	if days == 0 {
+		_, err := db.Exec("DELETE FROM rotation_policies WHERE folder = ?", folder)
+		return err
+	}
+	_, err := db.Exec(`INSERT INTO rotation_policies (folder, days) VALUES (?, ?)
+		ON CONFLICT(folder) DO UPDATE SET days = excluded.days`, folder, days)
+	return err
+}
+
+// RotationDays returns the rotation policy that applies to an entry: its
+// own, or else the one of the closest folder above it. 0 means the entry
+// never has to be rotated.
+func RotationDays(entryDays int, folder string, folders map[string]int) int {
+	if entryDays > 0 {
+		return entryDays
+	}
+	ancestors := append([]string{""}, FolderAncestors(folder)...)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if days, ok := folders[ancestors[i]]; ok {
+			return days
+		}
+	}
+	return 0
+}
+
+// RotationDue returns when the password of an entry last changed at
+// updatedAt has to be rotated, or the zero time without a policy.
+func RotationDue(updatedAt time.Time, days int) time.Time {
+	if days <= 0 {
+		return time.Time{}
+	}
+	return updatedAt.AddDate(0, 0, days)
+}
+
+// Overdue reports whether the password of the entry should have been
+// rotated before now.
+func (e PasswordEntry) Overdue(now time.Time) bool {
+	return !e.RotationDue.IsZero() && e.RotationDue.Before(now)
+}
+
+// CountOverdue returns how many of entries are overdue for rotation.
+func CountOverdue(entries []PasswordEntry, now time.Time) int {
+	n := 0
+	for _, e := range entries {
+		if e.Overdue(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// OverdueBanner tells that n passwords are overdue for rotation.
+func OverdueBanner(n int) string {
+	noun := "passwords are"
+	if n == 1 {
+		noun = "password is"
+	}
+	return StyleError.Render(fmt.Sprintf("⏰ %d %s overdue for rotation, run fortpass due to list them", n, noun))
+}
+
+// RotationHook is an executable that changes the password of an entry where
+// it is used, such as a database user or an API key.
+type RotationHook struct {
+	Path        string
+	Source      string
+	Username    string
+	URL         string
+	OldPassword string
+	NewPassword string
+}
+
+// Run runs the hook with the old and the new password on stdin, one per
+// line, and the entry in FORTPASS_SOURCE, FORTPASS_USERNAME and
+// FORTPASS_URL. The passwords are kept out of the environment, which child
+// processes of the hook inherit. The hook succeeded when it exits with 0
+// before timeout.
+func (h RotationHook) Run(timeout time.Duration, stdout, stderr io.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.Path)
+	cmd.Stdin = strings.NewReader(h.OldPassword + "\n" + h.NewPassword + "\n")
+	cmd.Env = append(os.Environ(),
+		"FORTPASS_SOURCE="+h.Source,
+		"FORTPASS_USERNAME="+h.Username,
+		"FORTPASS_URL="+h.URL,
+	)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("hook did not finish within %s", timeout)
+	}
+	return err
+}
+
+// CheckRotationHook makes sure path is an executable file.
+func CheckRotationHook(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() || info.Mode()&0o111 == 0 {
+		return fmt.Errorf("%s is not an executable file", path)
+	}
+	return nil
+}
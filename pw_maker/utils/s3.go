@@ -0,0 +1,275 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Target talks to AWS S3 or any S3-compatible storage such as MinIO using
+// signature version 4. Credentials are read from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and the optional AWS_SESSION_TOKEN.
+type s3Target struct {
+	endpoint     *url.URL
+	bucket       string
+	prefix       string
+	region       string
+	pathStyle    bool
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// newS3Target parses s3://bucket/prefix?endpoint=http://localhost:9000&region=us-east-1.
+// Custom endpoints use path-style addressing unless path-style=false is given.
+func newS3Target(u *url.URL) (*s3Target, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("s3 target needs a bucket, e.g. s3://bucket/prefix")
+	}
+
+	query := u.Query()
+	t := &s3Target{
+		bucket:       u.Host,
+		prefix:       strings.Trim(u.Path, "/"),
+		region:       query.Get("region"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: 5 * time.Minute},
+	}
+	if t.region == "" {
+		t.region = os.Getenv("AWS_REGION")
+	}
+	if t.region == "" {
+		t.region = "us-east-1"
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, fmt.Errorf("set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to use s3 backup targets")
+	}
+
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", t.region)
+		t.pathStyle = query.Get("path-style") == "true"
+	} else {
+		t.pathStyle = query.Get("path-style") != "false"
+	}
+	var err error
+	t.endpoint, err = url.Parse(endpoint)
+	if err != nil || t.endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", endpoint)
+	}
+	return t, nil
+}
+
+func (t *s3Target) objectKey(name string) string {
+	if t.prefix == "" {
+		return name
+	}
+	return t.prefix + "/" + name
+}
+
+// objectURL returns the URL of key, or of the bucket when key is empty.
+func (t *s3Target) objectURL(key string, query url.Values) *url.URL {
+	u := *t.endpoint
+	if t.pathStyle {
+		u.Path = "/" + t.bucket
+		if key != "" {
+			u.Path += "/" + key
+		}
+	} else {
+		u.Host = t.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+func (t *s3Target) do(method string, u *url.URL, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	t.sign(req, body, time.Now().UTC())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("s3 %s %s: %s: %s", method, u.Path, s3Err.Code, s3Err.Message)
+		}
+		return nil, fmt.Errorf("s3 %s %s: %s", method, u.Path, resp.Status)
+	}
+	return data, nil
+}
+
+// sign adds an AWS signature version 4 Authorization header to req.
+func (t *s3Target) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if t.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", t.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + t.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+t.secretKey), date)
+	key = hmacSHA256(key, t.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.accessKey, scope, signedHeaders, signature))
+}
+
+func (t *s3Target) Put(name string, data []byte) error {
+	_, err := t.do(http.MethodPut, t.objectURL(t.objectKey(name), nil), data)
+	return err
+}
+
+func (t *s3Target) Get(name string) ([]byte, error) {
+	return t.do(http.MethodGet, t.objectURL(t.objectKey(path.Base(name)), nil), nil)
+}
+
+func (t *s3Target) Delete(name string) error {
+	_, err := t.do(http.MethodDelete, t.objectURL(t.objectKey(path.Base(name)), nil), nil)
+	return err
+}
+
+func (t *s3Target) List() ([]BackupFile, error) {
+	var backups []BackupFile
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if t.prefix != "" {
+			query.Set("prefix", t.prefix+"/")
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		data, err := t.do(http.MethodGet, t.objectURL("", query), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				LastModified time.Time `xml:"LastModified"`
+				Size         int64     `xml:"Size"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("s3 list: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			name := path.Base(object.Key)
+			if !isRemoteBackupName(name) {
+				continue
+			}
+			backups = append(backups, BackupFile{Path: name, CreatedAt: ParseBackupTime(name, object.LastModified), Size: object.Size})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	return sortBackups(backups), nil
+}
+
+// canonicalQuery encodes query parameters sorted by key with the escaping
+// required by signature version 4.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Escape(s string) string {
+	escaped := strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+	return strings.ReplaceAll(escaped, "%7E", "~")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
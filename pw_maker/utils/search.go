@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sahilm/fuzzy"
+)
+
+// The fields a search term can be limited to with field:value.
+const (
+	SearchFieldSource   = "source"
+	SearchFieldUsername = "username"
+	SearchFieldURL      = "url"
+	SearchFieldFolder   = "folder"
+	SearchFieldTag      = "tag"
+)
+
+var searchFieldAliases = map[string]string{
+	"source":   SearchFieldSource,
+	"src":      SearchFieldSource,
+	"user":     SearchFieldUsername,
+	"username": SearchFieldUsername,
+	"url":      SearchFieldURL,
+	"folder":   SearchFieldFolder,
+	"in":       SearchFieldFolder,
+	"tag":      SearchFieldTag,
+}
+
+// SearchTerm is one word of a search query. Field is empty for terms that
+// match the source, username, URL or folder.
+type SearchTerm struct {
+	Field  string
+	Value  string
+	Negate bool
+}
+
+// SearchQuery is a parsed query such as `aws user:alice tag:prod -tag:old`.
+type SearchQuery []SearchTerm
+
+// SearchMatch is an entry matching a query with its rank and the byte
+// offsets of the matched characters of each field, for highlighting.
+type SearchMatch struct {
+	Entry   PasswordEntry
+	Score   int
+	Matched map[string][]int
+}
+
+// ParseSearchQuery splits a query into terms. Terms are separated by spaces
+// unless quoted, field:value limits a term to a field and a leading - or !
+// excludes the entries containing it. Words with an unknown field, such as
+// https://example.com, are matched as a whole.
+func ParseSearchQuery(query string) SearchQuery {
+	var terms SearchQuery
+	for _, word := range splitQuery(query) {
+		var term SearchTerm
+		if len(word) > 1 && (word[0] == '-' || word[0] == '!') {
+			term.Negate = true
+			word = word[1:]
+		}
+		if name, value, ok := strings.Cut(word, ":"); ok && value != "" {
+			if field, known := searchFieldAliases[strings.ToLower(name)]; known {
+				term.Field = field
+				word = value
+			}
+		}
+		term.Value = word
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+func splitQuery(query string) []string {
+	var words []string
+	var word strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case (r == ' ' || r == '\t') && !quoted:
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		default:
+			word.WriteRune(r)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// SearchEntries returns the entries matching every term of the query, best
+// match first. Entries that rank the same keep their order.
+func SearchEntries(entries []PasswordEntry, query SearchQuery) []SearchMatch {
+	var matches []SearchMatch
+	for _, entry := range entries {
+		match := SearchMatch{Entry: entry, Matched: make(map[string][]int)}
+		ok := true
+		for _, term := range query {
+			found, score, field, indexes := term.match(entry)
+			if found == term.Negate {
+				ok = false
+				break
+			}
+			if !term.Negate {
+				match.Score += score
+				match.Matched[field] = append(match.Matched[field], indexes...)
+			}
+		}
+		if ok {
+			matches = append(matches, match)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
+
+// match reports whether the term matches entry, with the score, field and
+// matched characters of the best match.
+func (t SearchTerm) match(entry PasswordEntry) (bool, int, string, []int) {
+	switch t.Field {
+	case SearchFieldTag:
+		return HasTag(entry.Tags, t.Value), 0, SearchFieldTag, nil
+	case SearchFieldFolder:
+		folder, err := NormalizeFolder(t.Value)
+		if err != nil || !InFolder(strings.ToLower(entry.Folder), strings.ToLower(folder)) {
+			return false, 0, "", nil
+		}
+		indexes := make([]int, len(folder))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return true, 0, SearchFieldFolder, indexes
+	}
+
+	fields := []string{SearchFieldSource, SearchFieldUsername, SearchFieldURL, SearchFieldFolder}
+	if t.Field != "" {
+		fields = []string{t.Field}
+	}
+	// A fuzzy match of a short word hits almost everything, so exclusions
+	// only drop entries that contain the word.
+	if t.Negate {
+		value := strings.ToLower(t.Value)
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(entry.field(field)), value) {
+				return true, 0, field, nil
+			}
+		}
+		return false, 0, "", nil
+	}
+	found := false
+	var best fuzzy.Match
+	var bestField string
+	for _, field := range fields {
+		m := fuzzy.Find(t.Value, []string{entry.field(field)})
+		if len(m) > 0 && (!found || m[0].Score > best.Score) {
+			found, best, bestField = true, m[0], field
+		}
+	}
+	return found, best.Score, bestField, best.MatchedIndexes
+}
+
+func (e PasswordEntry) field(name string) string {
+	switch name {
+	case SearchFieldSource:
+		return e.Source
+	case SearchFieldUsername:
+		return e.Username
+	case SearchFieldURL:
+		return e.URL
+	case SearchFieldFolder:
+		return e.Folder
+	}
+	return ""
+}
+
+// Highlight renders the characters of s at the given byte offsets with
+// style.
+func Highlight(s string, indexes []int, style lipgloss.Style) string {
+	if len(indexes) == 0 {
+		return s
+	}
+	matched := make(map[int]bool, len(indexes))
+	for _, i := range indexes {
+		matched[i] = true
+	}
+
+	var b strings.Builder
+	for i, r := range s {
+		if matched[i] {
+			b.WriteString(style.Render(string(r)))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  SearchQuery
+	}{
+		{"", nil},
+		{"aws  alice", SearchQuery{{Value: "aws"}, {Value: "alice"}}},
+		{`"my bank" x`, SearchQuery{{Value: "my bank"}, {Value: "x"}}},
+		{`"-x" y`, SearchQuery{{Value: "x", Negate: true}, {Value: "y"}}},
+		{`url:"my site"`, SearchQuery{{Field: SearchFieldURL, Value: "my site"}}},
+		{"src:aws user:alice USERNAME:bob in:work tag:prod", SearchQuery{
+			{Field: SearchFieldSource, Value: "aws"},
+			{Field: SearchFieldUsername, Value: "alice"},
+			{Field: SearchFieldUsername, Value: "bob"},
+			{Field: SearchFieldFolder, Value: "work"},
+			{Field: SearchFieldTag, Value: "prod"},
+		}},
+		{"-tag:old !legacy", SearchQuery{
+			{Field: SearchFieldTag, Value: "old", Negate: true},
+			{Value: "legacy", Negate: true},
+		}},
+		// A lone dash is a word, not an empty exclusion.
+		{"-", SearchQuery{{Value: "-"}}},
+		// Unknown fields and empty values are matched as a whole.
+		{"https://example.com", SearchQuery{{Value: "https://example.com"}}},
+		{"tag:", SearchQuery{{Value: "tag:"}}},
+	}
+	for _, tt := range tests {
+		if got := ParseSearchQuery(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSearchQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchEntries(t *testing.T) {
+	entries := []PasswordEntry{
+		{Source: "github.com", Username: "alice", URL: "https://github.com", Folder: "work", Tags: []string{"prod"}},
+		{Source: "gitlab", Username: "bob", Folder: "work/archive", Tags: []string{"old"}},
+		{Source: "aws", Username: "alice", URL: "https://console.aws.amazon.com", Folder: "work/cloud", Tags: []string{"prod", "admin"}},
+		{Source: "mail", Username: "Gabriel", Folder: "home"},
+		{Source: "legit-shop", Username: "carol"},
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"github.com", "gitlab", "aws", "mail", "legit-shop"}},
+		// Matches inside a word rank after the ones at its start.
+		{"git", []string{"gitlab", "github.com", "legit-shop"}},
+		{"user:alice", []string{"github.com", "aws"}},
+		{"alice tag:PROD", []string{"github.com", "aws"}},
+		{"in:work", []string{"github.com", "gitlab", "aws"}},
+		{"folder:work/archive", []string{"gitlab"}},
+		{"-tag:prod", []string{"gitlab", "mail", "legit-shop"}},
+		{"-in:work", []string{"mail", "legit-shop"}},
+		// Exclusions only drop entries containing the word, ignoring case,
+		// not everything the letters of "gab" fuzzily match.
+		{"-gab", []string{"github.com", "gitlab", "aws", "legit-shop"}},
+		{"!GIT", []string{"aws", "mail"}},
+		{"-user:alice", []string{"gitlab", "mail", "legit-shop"}},
+		{`"-url:aws.amazon"`, []string{"github.com", "gitlab", "mail", "legit-shop"}},
+		{"git -archive", []string{"github.com", "legit-shop"}},
+		{"nothing", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, m := range SearchEntries(entries, ParseSearchQuery(tt.query)) {
+			got = append(got, m.Entry.Source)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchEntries(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchEntriesHighlightsMatches(t *testing.T) {
+	entries := []PasswordEntry{{Source: "github.com", Username: "alice"}}
+	matches := SearchEntries(entries, ParseSearchQuery("user:ali -bob"))
+	if len(matches) != 1 {
+		t.Fatalf("got %d matches, want 1", len(matches))
+	}
+	want := map[string][]int{SearchFieldUsername: {0, 1, 2}}
+	if !reflect.DeepEqual(matches[0].Matched, want) {
+		t.Errorf("matched %v, want %v", matches[0].Matched, want)
+	}
+}
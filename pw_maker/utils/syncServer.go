@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxSyncRequestSize = 32 << 20
+
+// SyncBlob is an encrypted entry as stored by the sync server. The server
+// only sees the uuid and revision metadata, never the decrypted entry.
+type SyncBlob struct {
+	UUID     string `json:"uuid"`
+	Revision int64  `json:"revision"`
+	Deleted  bool   `json:"deleted,omitempty"`
+	Data     []byte `json:"data,omitempty"`
+	Seq      int64  `json:"seq,omitempty"`
+}
+
+// SyncPush is a change sent by a client. It is only accepted when
+// BaseRevision matches the revision stored on the server.
+type SyncPush struct {
+	UUID         string `json:"uuid"`
+	BaseRevision int64  `json:"base_revision"`
+	Deleted      bool   `json:"deleted,omitempty"`
+	Data         []byte `json:"data,omitempty"`
+}
+
+type SyncPullResponse struct {
+	Seq     int64      `json:"seq"`
+	Entries []SyncBlob `json:"entries"`
+}
+
+type SyncPushRequest struct {
+	Entries []SyncPush `json:"entries"`
+}
+
+// SyncPushResponse lists the accepted changes with their new revision and,
+// for rejected changes, the current version stored on the server.
+type SyncPushResponse struct {
+	Accepted  []SyncBlob `json:"accepted"`
+	Conflicts []SyncBlob `json:"conflicts"`
+}
+
+// SyncServer serves the sync protocol from a database of encrypted blobs:
+//
+//	GET  /v1/entries?since=<seq>  changes after seq
+//	POST /v1/entries              push changes
+type SyncServer struct {
+	db    *sql.DB
+	token string
+}
+
+// NewSyncServer returns a sync server storing its blobs in db. Requests must
+// carry token as bearer token unless it is empty.
+func NewSyncServer(db *sql.DB, token string) (*SyncServer, error) {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS blobs (
+            uuid TEXT PRIMARY KEY,
+            revision INTEGER NOT NULL,
+            deleted INTEGER NOT NULL DEFAULT 0,
+            data BLOB,
+            seq INTEGER NOT NULL,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX IF NOT EXISTS idx_blobs_seq ON blobs(seq);
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating sync server tables: %w", err)
+	}
+	return &SyncServer{db: db, token: token}, nil
+}
+
+func (s *SyncServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if r.URL.Path != "/v1/entries" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		resp, err := s.pull(since)
+		writeSyncResponse(w, resp, err)
+	case http.MethodPost:
+		var req SyncPushRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSyncRequestSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := s.push(req)
+		writeSyncResponse(w, resp, err)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeSyncResponse(w http.ResponseWriter, resp any, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *SyncServer) pull(since int64) (SyncPullResponse, error) {
+	resp := SyncPullResponse{Seq: since, Entries: []SyncBlob{}}
+	rows, err := s.db.Query("SELECT uuid, revision, deleted, data, seq FROM blobs WHERE seq > ? ORDER BY seq", since)
+	if err != nil {
+		return resp, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b SyncBlob
+		if err := rows.Scan(&b.UUID, &b.Revision, &b.Deleted, &b.Data, &b.Seq); err != nil {
+			return resp, err
+		}
+		resp.Entries = append(resp.Entries, b)
+		resp.Seq = b.Seq
+	}
+	return resp, rows.Err()
+}
+
+func (s *SyncServer) push(req SyncPushRequest) (SyncPushResponse, error) {
+	resp := SyncPushResponse{Accepted: []SyncBlob{}, Conflicts: []SyncBlob{}}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return resp, err
+	}
+	defer tx.Rollback()
+
+	var seq int64
+	if err := tx.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM blobs").Scan(&seq); err != nil {
+		return resp, err
+	}
+
+	for _, p := range req.Entries {
+		current := SyncBlob{UUID: p.UUID}
+		err := tx.QueryRow("SELECT revision, deleted, data, seq FROM blobs WHERE uuid = ?", p.UUID).
+			Scan(&current.Revision, &current.Deleted, &current.Data, &current.Seq)
+		if err != nil && err != sql.ErrNoRows {
+			return resp, err
+		}
+		if current.Revision != p.BaseRevision {
+			resp.Conflicts = append(resp.Conflicts, current)
+			continue
+		}
+
+		seq++
+		accepted := SyncBlob{UUID: p.UUID, Revision: p.BaseRevision + 1, Deleted: p.Deleted, Seq: seq}
+		if !p.Deleted {
+			accepted.Data = p.Data
+		}
+		_, err = tx.Exec("INSERT OR REPLACE INTO blobs (uuid, revision, deleted, data, seq, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+			accepted.UUID, accepted.Revision, accepted.Deleted, accepted.Data, accepted.Seq, FormatDBTime(time.Now()))
+		if err != nil {
+			return resp, err
+		}
+		accepted.Data = nil
+		resp.Accepted = append(resp.Accepted, accepted)
+	}
+	return resp, tx.Commit()
+}
+
+// SyncClient talks to a sync server.
+type SyncClient struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+func NewSyncClient(baseURL, token string) *SyncClient {
+	return &SyncClient{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Token:   token,
+		HTTP:    &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Pull returns the changes stored on the server after seq.
+func (c *SyncClient) Pull(since int64) (SyncPullResponse, error) {
+	var resp SyncPullResponse
+	err := c.do(http.MethodGet, fmt.Sprintf("/v1/entries?since=%d", since), nil, &resp)
+	return resp, err
+}
+
+// Push sends local changes to the server.
+func (c *SyncClient) Push(req SyncPushRequest) (SyncPushResponse, error) {
+	var resp SyncPushResponse
+	body, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	err = c.do(http.MethodPost, "/v1/entries", body, &resp)
+	return resp, err
+}
+
+func (c *SyncClient) do(method, path string, body []byte, result any) error {
+	req, err := http.NewRequest(method, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sync server: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
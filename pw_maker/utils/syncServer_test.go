@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestSyncServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "server.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	server, err := NewSyncServer(db, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestSyncServerRoundTrip(t *testing.T) {
+	ts := newTestSyncServer(t, "secret")
+	client := NewSyncClient(ts.URL, "secret")
+
+	resp, err := client.Push(SyncPushRequest{Entries: []SyncPush{
+		{UUID: "a", Data: []byte("a1")},
+		{UUID: "b", Data: []byte("b1")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Accepted) != 2 || len(resp.Conflicts) != 0 {
+		t.Fatalf("first push: got %d accepted, %d conflicts", len(resp.Accepted), len(resp.Conflicts))
+	}
+	for _, blob := range resp.Accepted {
+		if blob.Revision != 1 {
+			t.Errorf("%s: revision %d, want 1", blob.UUID, blob.Revision)
+		}
+	}
+
+	pulled, err := client.Pull(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled.Entries) != 2 || pulled.Seq != 2 {
+		t.Fatalf("pull: got %d entries up to seq %d, want 2 up to 2", len(pulled.Entries), pulled.Seq)
+	}
+	if pulled.Entries[0].UUID != "a" || !bytes.Equal(pulled.Entries[0].Data, []byte("a1")) {
+		t.Errorf("pull: got %s %q, want a \"a1\"", pulled.Entries[0].UUID, pulled.Entries[0].Data)
+	}
+}
+
+func TestSyncServerConflict(t *testing.T) {
+	ts := newTestSyncServer(t, "")
+	client := NewSyncClient(ts.URL, "")
+
+	if _, err := client.Push(SyncPushRequest{Entries: []SyncPush{{UUID: "a", Data: []byte("a1")}}}); err != nil {
+		t.Fatal(err)
+	}
+	// A second client that has not pulled revision 1 yet
+	resp, err := client.Push(SyncPushRequest{Entries: []SyncPush{{UUID: "a", BaseRevision: 0, Data: []byte("other")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Accepted) != 0 || len(resp.Conflicts) != 1 {
+		t.Fatalf("got %d accepted, %d conflicts, want the push rejected", len(resp.Accepted), len(resp.Conflicts))
+	}
+	conflict := resp.Conflicts[0]
+	if conflict.Revision != 1 || !bytes.Equal(conflict.Data, []byte("a1")) {
+		t.Errorf("conflict: got revision %d %q, want the stored revision 1 \"a1\"", conflict.Revision, conflict.Data)
+	}
+
+	resp, err = client.Push(SyncPushRequest{Entries: []SyncPush{{UUID: "a", BaseRevision: 1, Data: []byte("a2")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Accepted) != 1 || resp.Accepted[0].Revision != 2 {
+		t.Fatalf("push on the current revision: got %+v", resp)
+	}
+}
+
+func TestSyncServerTombstone(t *testing.T) {
+	ts := newTestSyncServer(t, "")
+	client := NewSyncClient(ts.URL, "")
+
+	if _, err := client.Push(SyncPushRequest{Entries: []SyncPush{{UUID: "a", Data: []byte("a1")}}}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Push(SyncPushRequest{Entries: []SyncPush{{UUID: "a", BaseRevision: 1, Deleted: true, Data: []byte("ignored")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Accepted) != 1 || !resp.Accepted[0].Deleted || resp.Accepted[0].Revision != 2 {
+		t.Fatalf("delete: got %+v", resp)
+	}
+
+	pulled, err := client.Pull(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled.Entries) != 1 {
+		t.Fatalf("pull after delete: got %d entries, want 1", len(pulled.Entries))
+	}
+	if blob := pulled.Entries[0]; !blob.Deleted || blob.Data != nil || blob.Revision != 2 {
+		t.Errorf("pull after delete: got %+v, want a tombstone of revision 2 without data", blob)
+	}
+}
+
+func TestSyncServerRejectsBadToken(t *testing.T) {
+	ts := newTestSyncServer(t, "secret")
+
+	for _, token := range []string{"", "wrong"} {
+		client := NewSyncClient(ts.URL, token)
+		if _, err := client.Pull(0); err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("pull with token %q: got %v, want 401", token, err)
+		}
+		_, err := client.Push(SyncPushRequest{Entries: []SyncPush{{UUID: "a", Data: []byte("a1")}}})
+		if err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("push with token %q: got %v, want 401", token, err)
+		}
+	}
+
+	pulled, err := NewSyncClient(ts.URL, "secret").Pull(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled.Entries) != 0 {
+		t.Errorf("got %d entries stored by unauthorized pushes", len(pulled.Entries))
+	}
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+)
+
+// ParseTags splits a comma or space separated list of tags. Tags are
+// lowercased, sorted and deduplicated; no tags gives nil.
+func ParseTags(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	if len(fields) == 0 {
+		return nil
+	}
+	sort.Strings(fields)
+	tags := fields[:1]
+	for _, tag := range fields[1:] {
+		if tag != tags[len(tags)-1] {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// FormatTags joins tags the way they are stored in the database.
+func FormatTags(tags []string) string {
+	return strings.Join(ParseTags(strings.Join(tags, ",")), ",")
+}
+
+// HasTag reports whether tags contains tag, ignoring case.
+func HasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
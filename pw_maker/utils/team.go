@@ -0,0 +1,515 @@
+package utils
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	TeamVaultFormat  = "fortpass-team"
+	publicKeyPrefix  = "fortpass-pub-"
+	signingKeyPrefix = "fortpass-sig-"
+	identityKeyName  = "identity_key"
+	signingKeyName   = "signing_key"
+	teamKeyWrapLabel = "fortpass team key wrap"
+
+	teamMembersLabel = "fortpass team members\x00"
+	teamEntryLabel   = "fortpass team entry\x00"
+
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// TeamRoles are the member roles: owners manage members and access, editors
+// share, update and delete entries, viewers can only read.
+var TeamRoles = []string{RoleOwner, RoleEditor, RoleViewer}
+
+// TeamVault is a vault shared by a team. Every entry is encrypted with its
+// own key, which is wrapped for the X25519 public key of each member, so the
+// file can be shared over any untrusted channel.
+//
+// FolderACLs limits the members that can read the entries of a folder, unless
+// an entry has its own Readers. Owners can always read every entry.
+//
+// The members with their roles and the folder ACLs are signed by an owner,
+// and every entry by the owner or editor that last wrote it, so members who
+// can write the file cannot change their role or who may read an entry.
+type TeamVault struct {
+	Format     string              `json:"format"`
+	Version    int                 `json:"version"`
+	Name       string              `json:"name"`
+	Members    []TeamMember        `json:"members"`
+	FolderACLs map[string][]string `json:"folder_acls,omitempty"`
+	Signature  TeamSignature       `json:"signature"`
+	Entries    []TeamEntry         `json:"entries"`
+}
+
+// TeamMember is a member of a team vault. SigningKey is the Ed25519 key the
+// member signs changes with; members added before signing existed have none
+// and can only read.
+type TeamMember struct {
+	Name       string `json:"name"`
+	PublicKey  string `json:"public_key"`
+	SigningKey string `json:"signing_key,omitempty"`
+	Role       string `json:"role,omitempty"`
+	AddedAt    string `json:"added_at"`
+}
+
+// TeamSignature is an Ed25519 signature and the signing key of the member
+// that made it.
+type TeamSignature struct {
+	Signer string `json:"signer,omitempty"`
+	Value  []byte `json:"value,omitempty"`
+}
+
+// CanWrite reports whether the member may change entries.
+func (m TeamMember) CanWrite() bool {
+	return m.Role == RoleOwner || m.Role == RoleEditor
+}
+
+// TeamEntry holds an encrypted TeamSecret and its key wrapped for each
+// member that may read it, keyed by the member's public key.
+type TeamEntry struct {
+	UUID      string                `json:"uuid"`
+	Folder    string                `json:"folder,omitempty"`
+	Readers   []string              `json:"readers,omitempty"`
+	Payload   []byte                `json:"payload"`
+	Keys      map[string]WrappedKey `json:"keys"`
+	Signature TeamSignature         `json:"signature"`
+}
+
+// TeamSecret is the decrypted content of a team entry.
+type TeamSecret struct {
+	Source    string `json:"source"`
+	Username  string `json:"username"`
+	URL       string `json:"url"`
+	Password  string `json:"password"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+	SharedBy  string `json:"shared_by"`
+}
+
+// WrappedKey is an entry key encrypted for one recipient with a key derived
+// from an ephemeral X25519 key exchange.
+type WrappedKey struct {
+	Ephemeral []byte `json:"ephemeral"`
+	Key       []byte `json:"key"`
+}
+
+// IdentityKey returns the X25519 identity key of this user, stored in the
+// system keyring next to the database key.
+func IdentityKey() (*ecdh.PrivateKey, error) {
+	secret, err := KeyringSecret(identityKeyName)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPrivateKey(secret)
+}
+
+// SigningKey returns the Ed25519 key this user signs changes to team vaults
+// with, stored in the system keyring next to the identity key.
+func SigningKey() (ed25519.PrivateKey, error) {
+	seed, err := KeyringSecret(signingKeyName)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// EncodePublicKey formats the public key members are identified by.
+func EncodePublicKey(key *ecdh.PublicKey) string {
+	return publicKeyPrefix + base64.RawURLEncoding.EncodeToString(key.Bytes())
+}
+
+// EncodeSigningKey formats the public key a member signs with.
+func EncodeSigningKey(key ed25519.PublicKey) string {
+	return signingKeyPrefix + base64.RawURLEncoding.EncodeToString(key)
+}
+
+// EncodeMemberKey formats the public and signing keys of a user as the one
+// key they send to team owners to be added.
+func EncodeMemberKey(identity *ecdh.PublicKey, signing ed25519.PublicKey) string {
+	return publicKeyPrefix + base64.RawURLEncoding.EncodeToString(append(identity.Bytes(), signing...))
+}
+
+// ParseMemberKey splits a key printed by "team pubkey" into the public key
+// and the signing key of the member. Keys of older versions only contain
+// the public key, and the signing key is returned empty.
+func ParseMemberKey(s string) (string, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), publicKeyPrefix))
+	if err != nil || !strings.HasPrefix(strings.TrimSpace(s), publicKeyPrefix) || (len(raw) != 32 && len(raw) != 64) {
+		return "", "", fmt.Errorf("invalid public key %q, expected %s...", s, publicKeyPrefix)
+	}
+	pub, err := ecdh.X25519().NewPublicKey(raw[:32])
+	if err != nil {
+		return "", "", err
+	}
+	if len(raw) == 32 {
+		return EncodePublicKey(pub), "", nil
+	}
+	return EncodePublicKey(pub), EncodeSigningKey(raw[32:]), nil
+}
+
+func parseSigningKey(s string) (ed25519.PublicKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, signingKeyPrefix))
+	if err != nil || !strings.HasPrefix(s, signingKeyPrefix) || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid signing key %q", s)
+	}
+	return raw, nil
+}
+
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), publicKeyPrefix))
+	if err != nil || !strings.HasPrefix(strings.TrimSpace(s), publicKeyPrefix) {
+		return nil, fmt.Errorf("invalid public key %q, expected %s...", s, publicKeyPrefix)
+	}
+	return ecdh.X25519().NewPublicKey(raw)
+}
+
+// WrapKey encrypts key for the owner of recipient.
+func WrapKey(key []byte, recipient string) (WrappedKey, error) {
+	pub, err := ParsePublicKey(recipient)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	wrapKey, err := deriveWrapKey(ephemeral, pub, ephemeral.PublicKey(), pub)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	wrapped, err := EncryptWithKey(wrapKey, key)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	return WrappedKey{Ephemeral: ephemeral.PublicKey().Bytes(), Key: wrapped}, nil
+}
+
+// UnwrapKey decrypts a key wrapped for identity.
+func UnwrapKey(w WrappedKey, identity *ecdh.PrivateKey) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().NewPublicKey(w.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	wrapKey, err := deriveWrapKey(identity, ephemeral, ephemeral, identity.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	return DecryptWithKey(wrapKey, w.Key)
+}
+
+func deriveWrapKey(private *ecdh.PrivateKey, peer, ephemeral, recipient *ecdh.PublicKey) ([]byte, error) {
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(append([]byte{}, ephemeral.Bytes()...), recipient.Bytes()...)
+	key := make([]byte, 32)
+	_, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(teamKeyWrapLabel)), key)
+	return key, err
+}
+
+// NewTeamVault creates a team vault whose only member is its creator.
+func NewTeamVault(name string, owner TeamMember) *TeamVault {
+	if owner.AddedAt == "" {
+		owner.AddedAt = FormatDBTime(time.Now())
+	}
+	owner.Role = RoleOwner
+	return &TeamVault{Format: TeamVaultFormat, Version: 2, Name: name, Members: []TeamMember{owner}, Entries: []TeamEntry{}}
+}
+
+func LoadTeamVault(path string) (*TeamVault, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var vault TeamVault
+	if err := json.Unmarshal(data, &vault); err != nil || vault.Format != TeamVaultFormat {
+		return nil, fmt.Errorf("%s is not a fortpass team vault", path)
+	}
+	if vault.Version > 2 {
+		return nil, fmt.Errorf("team vault version %d is not supported by this version of fortpass", vault.Version)
+	}
+	// Vaults created before roles existed: the creator owns it.
+	for i := range vault.Members {
+		if vault.Members[i].Role == "" {
+			vault.Members[i].Role = RoleEditor
+			if i == 0 {
+				vault.Members[i].Role = RoleOwner
+			}
+		}
+	}
+	return &vault, nil
+}
+
+// Save writes the team vault atomically.
+func (v *TeamVault) Save(path string) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Member returns the member with the given name or public key.
+func (v *TeamVault) Member(nameOrKey string) (*TeamMember, bool) {
+	for i := range v.Members {
+		if v.Members[i].Name == nameOrKey || v.Members[i].PublicKey == nameOrKey {
+			return &v.Members[i], true
+		}
+	}
+	return nil, false
+}
+
+// MemberBySigningKey returns the member that signs with signingKey.
+func (v *TeamVault) MemberBySigningKey(signingKey string) (*TeamMember, bool) {
+	for i := range v.Members {
+		if signingKey != "" && v.Members[i].SigningKey == signingKey {
+			return &v.Members[i], true
+		}
+	}
+	return nil, false
+}
+
+// OwnerSigningKeys returns the signing keys of the owners, sorted.
+func (v *TeamVault) OwnerSigningKeys() []string {
+	var keys []string
+	for _, m := range v.Members {
+		if m.Role == RoleOwner && m.SigningKey != "" {
+			keys = append(keys, m.SigningKey)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SignMembers signs the name, members and folder ACLs of the vault. key must
+// belong to an owner.
+func (v *TeamVault) SignMembers(key ed25519.PrivateKey) error {
+	signer, err := v.signer(key, RoleOwner)
+	if err != nil {
+		return err
+	}
+	data, err := v.membersData()
+	if err != nil {
+		return err
+	}
+	v.Version = 2
+	v.Signature = TeamSignature{Signer: signer, Value: ed25519.Sign(key, data)}
+	return nil
+}
+
+// SignEntry signs the content and readers of entry. key must belong to an
+// owner or editor.
+func (v *TeamVault) SignEntry(entry *TeamEntry, key ed25519.PrivateKey) error {
+	signer, err := v.signer(key, RoleEditor)
+	if err != nil {
+		return err
+	}
+	data, err := entry.signedData()
+	if err != nil {
+		return err
+	}
+	entry.Signature = TeamSignature{Signer: signer, Value: ed25519.Sign(key, data)}
+	return nil
+}
+
+func (v *TeamVault) signer(key ed25519.PrivateKey, role string) (string, error) {
+	signer := EncodeSigningKey(key.Public().(ed25519.PublicKey))
+	member, ok := v.MemberBySigningKey(signer)
+	switch {
+	case !ok:
+		return "", fmt.Errorf("your signing key is not part of team %s, ask an owner to add your key from: fortpass team pubkey", v.Name)
+	case role == RoleOwner && member.Role != RoleOwner:
+		return "", fmt.Errorf("only owners can sign the members of team %s", v.Name)
+	case !member.CanWrite():
+		return "", fmt.Errorf("viewers cannot sign entries of team %s", v.Name)
+	}
+	return signer, nil
+}
+
+// Verify checks that the members and folder ACLs were signed by an owner and
+// every entry by an owner or editor of the signed members. With
+// trustedOwners, the signing keys of the owners known from an earlier
+// version of the vault, the members must be signed by one of them, so a
+// member cannot replace the owners with themselves.
+func (v *TeamVault) Verify(trustedOwners []string) error {
+	if len(v.Signature.Value) == 0 {
+		return fmt.Errorf("team vault %s is not signed, an owner has to sign it with: fortpass team sign", v.Name)
+	}
+	data, err := v.membersData()
+	if err != nil {
+		return err
+	}
+	signer, ok := v.MemberBySigningKey(v.Signature.Signer)
+	if !ok || signer.Role != RoleOwner || !verifySignature(v.Signature, data) {
+		return fmt.Errorf("the members of team vault %s are not signed by an owner, the file was changed", v.Name)
+	}
+	if len(trustedOwners) > 0 && !slices.Contains(trustedOwners, v.Signature.Signer) {
+		return fmt.Errorf("the members of team vault %s were signed by %s, who is not an owner you trust; join the team again if the owners changed", v.Name, signer.Name)
+	}
+
+	for _, entry := range v.Entries {
+		data, err := entry.signedData()
+		if err != nil {
+			return err
+		}
+		signer, ok := v.MemberBySigningKey(entry.Signature.Signer)
+		if !ok || !signer.CanWrite() || !verifySignature(entry.Signature, data) {
+			return fmt.Errorf("entry %s of team vault %s is not signed by an owner or editor, the file was changed", entry.UUID, v.Name)
+		}
+	}
+	return nil
+}
+
+func verifySignature(signature TeamSignature, data []byte) bool {
+	key, err := parseSigningKey(signature.Signer)
+	return err == nil && ed25519.Verify(key, data, signature.Value)
+}
+
+func (v *TeamVault) membersData() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		Format     string              `json:"format"`
+		Name       string              `json:"name"`
+		Members    []TeamMember        `json:"members"`
+		FolderACLs map[string][]string `json:"folder_acls"`
+	}{v.Format, v.Name, v.Members, v.FolderACLs})
+	return append([]byte(teamMembersLabel), data...), err
+}
+
+// signedData covers what decides who may read the entry and what it
+// contains. The wrapped keys are left out: they only hand out the entry key
+// to readers, and owners rewrap them when members change.
+func (e TeamEntry) signedData() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		UUID    string   `json:"uuid"`
+		Folder  string   `json:"folder"`
+		Readers []string `json:"readers"`
+		Payload []byte   `json:"payload"`
+	}{e.UUID, e.Folder, e.Readers, e.Payload})
+	return append([]byte(teamEntryLabel), data...), err
+}
+
+// Open decrypts an entry with identity.
+func (e TeamEntry) Open(identity *ecdh.PrivateKey) (TeamSecret, []byte, error) {
+	var secret TeamSecret
+	wrapped, ok := e.Keys[EncodePublicKey(identity.PublicKey())]
+	if !ok {
+		return secret, nil, errors.New("entry is not shared with you")
+	}
+	key, err := UnwrapKey(wrapped, identity)
+	if err != nil {
+		return secret, nil, err
+	}
+	plaintext, err := DecryptWithKey(key, e.Payload)
+	if err != nil {
+		return secret, nil, err
+	}
+	return secret, key, json.Unmarshal(plaintext, &secret)
+}
+
+// CanRead reports whether member may read entry according to the entry's
+// readers, or the ACL of its folder when it has none.
+func (v *TeamVault) CanRead(member TeamMember, entry TeamEntry) bool {
+	if member.Role == RoleOwner {
+		return true
+	}
+	readers := entry.Readers
+	if len(readers) == 0 {
+		readers = v.FolderACLs[entry.Folder]
+	}
+	if len(readers) == 0 {
+		return true
+	}
+	for _, r := range readers {
+		if r == member.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Seal encrypts secret into entry with a fresh entry key wrapped for every
+// member allowed to read the entry.
+func (v *TeamVault) Seal(entry *TeamEntry, secret TeamSecret) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	entry.Payload, err = EncryptWithKey(key, plaintext)
+	if err != nil {
+		return err
+	}
+
+	entry.Keys = make(map[string]WrappedKey)
+	return v.wrapForReaders(entry, key)
+}
+
+// Reshare brings the wrapped keys of an entry in line with who may read it.
+// New readers get the existing key; when a member lost access the entry is
+// encrypted again with a new key, so old wrapped keys are useless for later
+// versions of the vault.
+func (v *TeamVault) Reshare(entry *TeamEntry, identity *ecdh.PrivateKey) error {
+	secret, key, err := entry.Open(identity)
+	if err != nil {
+		return err
+	}
+
+	for publicKey := range entry.Keys {
+		member, ok := v.Member(publicKey)
+		if !ok || !v.CanRead(*member, *entry) {
+			return v.Seal(entry, secret)
+		}
+	}
+	return v.wrapForReaders(entry, key)
+}
+
+func (v *TeamVault) wrapForReaders(entry *TeamEntry, key []byte) error {
+	for _, m := range v.Members {
+		if _, ok := entry.Keys[m.PublicKey]; ok || !v.CanRead(m, *entry) {
+			continue
+		}
+		wrapped, err := WrapKey(key, m.PublicKey)
+		if err != nil {
+			return fmt.Errorf("wrapping key for %s: %w", m.Name, err)
+		}
+		entry.Keys[m.PublicKey] = wrapped
+	}
+	return nil
+}
+
+// NewUUID returns a random identifier in the format used for entries.
+func NewUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
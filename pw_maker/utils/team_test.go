@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+)
+
+type testTeamMember struct {
+	member   TeamMember
+	identity *ecdh.PrivateKey
+	signing  ed25519.PrivateKey
+}
+
+func newTestTeamMember(t *testing.T, name, role string) testTeamMember {
+	t.Helper()
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, signing, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, signingKey, err := ParseMemberKey(EncodeMemberKey(identity.PublicKey(), signing.Public().(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testTeamMember{
+		member:   TeamMember{Name: name, PublicKey: publicKey, SigningKey: signingKey, Role: role, AddedAt: "2026-01-01 00:00:00"},
+		identity: identity,
+		signing:  signing,
+	}
+}
+
+// newTestTeam returns a signed vault owned by alice with bob as viewer and
+// one entry shared by carol, an editor.
+func newTestTeam(t *testing.T) (*TeamVault, testTeamMember, testTeamMember, testTeamMember) {
+	t.Helper()
+	alice := newTestTeamMember(t, "alice", RoleOwner)
+	bob := newTestTeamMember(t, "bob", RoleViewer)
+	carol := newTestTeamMember(t, "carol", RoleEditor)
+
+	vault := NewTeamVault("infra", alice.member)
+	vault.Members = append(vault.Members, bob.member, carol.member)
+	if err := vault.SignMembers(alice.signing); err != nil {
+		t.Fatal(err)
+	}
+	entry := TeamEntry{UUID: NewUUID()}
+	if err := vault.Seal(&entry, TeamSecret{Source: "aws", Username: "root", Password: "s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.SignEntry(&entry, carol.signing); err != nil {
+		t.Fatal(err)
+	}
+	vault.Entries = append(vault.Entries, entry)
+
+	if err := vault.Verify([]string{alice.member.SigningKey}); err != nil {
+		t.Fatalf("signed vault: %v", err)
+	}
+	return vault, alice, bob, carol
+}
+
+func TestTeamVerifyRejectsChangedRole(t *testing.T) {
+	vault, _, bob, _ := newTestTeam(t)
+	member, _ := vault.Member("bob")
+	member.Role = RoleOwner
+	if err := vault.Verify(nil); err == nil {
+		t.Error("a viewer who made themselves owner was accepted")
+	}
+
+	// Signing the change with their own key does not help either
+	if err := vault.SignMembers(bob.signing); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.Verify([]string{vault.Members[0].SigningKey}); err == nil {
+		t.Error("members signed by an owner that is not trusted were accepted")
+	}
+}
+
+func TestTeamVerifyRejectsViewerSignatures(t *testing.T) {
+	vault, _, bob, _ := newTestTeam(t)
+	if err := vault.SignEntry(&vault.Entries[0], bob.signing); err == nil {
+		t.Error("a viewer signed an entry")
+	}
+	if err := vault.SignMembers(bob.signing); err == nil {
+		t.Error("a viewer signed the members")
+	}
+}
+
+func TestTeamVerifyRejectsChangedEntry(t *testing.T) {
+	vault, _, bob, _ := newTestTeam(t)
+
+	// A reader can encrypt a new payload with the entry key
+	entry := &vault.Entries[0]
+	secret, key, err := entry.Open(bob.identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Password = "hunter2"
+	plaintext, err := json.Marshal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Payload, err = EncryptWithKey(key, plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.Verify(nil); err == nil {
+		t.Error("an entry changed by a viewer was accepted")
+	}
+}
+
+func TestTeamVerifyRejectsChangedReaders(t *testing.T) {
+	vault, _, _, _ := newTestTeam(t)
+	vault.Entries[0].Readers = []string{"bob"}
+	if err := vault.Verify(nil); err == nil {
+		t.Error("changed readers of an entry were accepted")
+	}
+
+	vault, _, _, _ = newTestTeam(t)
+	vault.FolderACLs = map[string][]string{"": {"bob"}}
+	if err := vault.Verify(nil); err == nil {
+		t.Error("a changed folder ACL was accepted")
+	}
+}
+
+func TestTeamVerifyRejectsUnsignedVault(t *testing.T) {
+	vault, _, _, _ := newTestTeam(t)
+	vault.Signature = TeamSignature{}
+	if err := vault.Verify(nil); err == nil {
+		t.Error("an unsigned vault was accepted")
+	}
+}
+
+func TestTeamVerifyAfterEditorDemoted(t *testing.T) {
+	vault, alice, _, carol := newTestTeam(t)
+	member, _ := vault.Member("carol")
+	member.Role = RoleViewer
+	// Carol's entry is signed again by the owner once she cannot write
+	if err := vault.Verify(nil); err == nil {
+		t.Error("an entry signed by a viewer was accepted")
+	}
+	if err := vault.SignMembers(alice.signing); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.SignEntry(&vault.Entries[0], alice.signing); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.Verify([]string{alice.member.SigningKey}); err != nil {
+		t.Errorf("vault signed again by the owner: %v", err)
+	}
+	if err := vault.SignEntry(&vault.Entries[0], carol.signing); err == nil {
+		t.Error("a former editor signed an entry")
+	}
+}
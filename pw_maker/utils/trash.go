@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	TrashRetentionSetting = "trash.retention_days"
+	// DefaultTrashRetentionDays is how long deleted entries stay in the
+	// trash unless configured otherwise.
+	DefaultTrashRetentionDays = 30
+)
+
+// TrashedEntry is an entry in the trash.
+type TrashedEntry struct {
+	ID        int64
+	UUID      string
+	Source    string
+	Username  string
+	URL       string
+	DeletedAt time.Time
+}
+
+// TrashEntry moves an entry into the trash.
+func TrashEntry(db Execer, id int64) error {
+	_, err := db.Exec("UPDATE passwords SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL", id)
+	return err
+}
+
+// ListTrash returns the entries in the trash, most recently deleted first.
+func ListTrash() ([]TrashedEntry, error) {
+	rows, err := DB.Query("SELECT id, uuid, source, username, url, deleted_at FROM passwords WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []TrashedEntry
+	for rows.Next() {
+		var e TrashedEntry
+		if err := rows.Scan(&e.ID, &e.UUID, &e.Source, &e.Username, &e.URL, &e.DeletedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// RestoreTrashed takes an entry out of the trash. It is marked as changed for
+// sync and its pending deletion is dropped, so other machines get it back.
+func RestoreTrashed(db Execer, id int64) error {
+	_, err := db.Exec("DELETE FROM sync_tombstones WHERE uuid = (SELECT uuid FROM passwords WHERE id = ?)", id)
+	if err == nil {
+		_, err = db.Exec("UPDATE passwords SET deleted_at = NULL, synced_hash = NULL WHERE id = ?", id)
+	}
+	return err
+}
+
+// ReviveTrashed takes the trashed entry with the given uuid, or else the given
+// account, out of the trash so a new version can be stored in its place. The
+// trashed password is kept in the history. It returns the ID of the entry,
+// or 0 when there is no such entry in the trash.
+func ReviveTrashed(db Queryer, uuid, source, username, url string) (int64, error) {
+	var id int64
+	err := db.QueryRow(`SELECT id FROM passwords
+		WHERE deleted_at IS NOT NULL AND (uuid = ? OR (source = ? AND username = ? AND url = ?))
+		ORDER BY uuid = ? DESC LIMIT 1`, uuid, source, username, url, uuid).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := ArchivePassword(db, id); err != nil {
+		return 0, err
+	}
+	return id, RestoreTrashed(db, id)
+}
+
+// PurgeEntry permanently deletes an entry and its password history.
+func PurgeEntry(db Execer, id int64) error {
+	_, err := db.Exec("DELETE FROM password_history WHERE password_id = ?", id)
+	if err == nil {
+		_, err = db.Exec("DELETE FROM passwords WHERE id = ?", id)
+	}
+	return err
+}
+
+// PurgeTrashedAccount permanently deletes the trashed entry of an account,
+// if there is one, so that a live entry can be renamed to it.
+func PurgeTrashedAccount(db Queryer, source, username, url string) error {
+	var id int64
+	err := db.QueryRow("SELECT id FROM passwords WHERE deleted_at IS NOT NULL AND source = ? AND username = ? AND url = ?",
+		source, username, url).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return PurgeEntry(db, id)
+}
+
+// SelectExpiredTrash returns the entries deleted more than retentionDays
+// before now. A retention of 0 keeps entries until they are purged by hand.
+func SelectExpiredTrash(entries []TrashedEntry, retentionDays int, now time.Time) []TrashedEntry {
+	if retentionDays <= 0 {
+		return nil
+	}
+	cutoff := now.AddDate(0, 0, -retentionDays)
+	var expired []TrashedEntry
+	for _, e := range entries {
+		if e.DeletedAt.Before(cutoff) {
+			expired = append(expired, e)
+		}
+	}
+	return expired
+}
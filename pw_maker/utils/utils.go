@@ -3,15 +3,14 @@ package utils
 import (
 	"database/sql"
 	"fmt"
-	"math/rand"
 	"net/url"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/atotto/clipboard"
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/term"
 )
 
 var (
@@ -34,10 +33,20 @@ var (
 			Foreground(lipgloss.Color("#CDD6F4")).
 			Background(lipgloss.Color("#1E1E2E")).
 			Padding(0, 1)
+
+	// StyleMatch highlights the characters matched by a search.
+	StyleMatch = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#F9E2AF")).
+			Underline(true)
 )
 
 var DocStyle = lipgloss.NewStyle().Margin(1, 2)
 
+// IsTerminal reports whether f is a terminal rather than a pipe or file.
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(f.Fd())
+}
+
 func BeautifyURL(rawURL string) string {
 	if rawURL == "" {
 		return ""
@@ -52,96 +61,99 @@ func BeautifyURL(rawURL string) string {
 	return parsedURL.String()
 }
 
-func CopyPasswordToClipboard(source, username string) {
-	var password string
-	err := DB.QueryRow("SELECT password FROM passwords WHERE source = ? AND username = ?", source, username).Scan(&password)
+// CopyPasswordToClipboard copies the password of the entry with the given
+// ID to the clipboard and reports whether it was copied.
+func CopyPasswordToClipboard(id int64) bool {
+	var source, username, password string
+	err := DB.QueryRow("SELECT source, username, password FROM passwords WHERE id = ? AND deleted_at IS NULL", id).Scan(&source, &username, &password)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			fmt.Println(StyleError.Render(fmt.Sprintf("❌ No password found for %s/%s", source, username)))
+			fmt.Println(StyleError.Render("❌ The password was not found, was it deleted in the meantime?"))
 		} else {
 			fmt.Println(StyleError.Render("❌ Error fetching password: " + err.Error()))
 		}
-		return
+		return false
 	}
 	err = clipboard.WriteAll(password)
 	if err != nil {
 		fmt.Println(StyleError.Render("❌ Failed to copy password to clipboard: " + err.Error()))
-	} else {
-		fmt.Printf(StyleSuccess.Render("📋 Password for %s/%s copied to clipboard. Will clear in 45 seconds.\n"), source, username)
-
-		go func() {
-			time.Sleep(45 * time.Second)
-			err := clipboard.WriteAll("")
-			if err != nil {
-				fmt.Println(StyleError.Render("❌ Failed to clear clipboard: " + err.Error()))
-			}
-		}()
-	}
-}
-
-func GeneratePassword() {
-	charset := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	if IncludeSpecial {
-		charset += "!@#$%^&*()_+-=[]{}|;:,.<>?"
-	}
-
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	password := make([]byte, Length)
-	for i := range password {
-		password[i] = charset[rng.Intn(len(charset))]
+		return false
 	}
+	fmt.Printf(StyleSuccess.Render("📋 Password for %s/%s copied to clipboard. Will clear in 45 seconds.\n"), source, username)
 
-	fmt.Println(StyleHeading.Render("🔐 Generated Password"))
-	fmt.Println(StylePassword.Render(string(password)))
+	go func() {
+		time.Sleep(45 * time.Second)
+		err := clipboard.WriteAll("")
+		if err != nil {
+			fmt.Println(StyleError.Render("❌ Failed to clear clipboard: " + err.Error()))
+		}
+	}()
+	return true
+}
 
-	err := clipboard.WriteAll(string(password))
-	if err != nil {
-		fmt.Println(StyleError.Render("❌ Failed to copy password to clipboard: " + err.Error()))
-	} else {
-		fmt.Println(StyleSuccess.Render("📋 Password copied to clipboard."))
+// StorePassword stores an entry, or overwrites the entry with the same
+// source, username and URL. A replaced password is kept in its history.
+func StorePassword(c Credential) {
+	if c.Username == "" || c.Source == "" {
+		fmt.Println(StylePrompt.Render("👋 Exiting without storing password."))
+		return
 	}
 
-	// Call storeInPass with the generated password
-	storeInPass(string(password))
-}
-func storeInPass(password string) {
-	fmt.Println(StylePrompt.Render("Do you want to store this password? (y/n)"))
-	var response string
-	_, err := fmt.Scanln(&response)
+	action, changed, err := storeCredential(c)
 	if err != nil {
-		fmt.Println(StyleError.Render("❌ Error reading input: " + err.Error()))
+		fmt.Println(StyleError.Render("❌ Failed to store password in database: " + err.Error()))
 		return
 	}
-
-	if response != "y" && response != "Y" {
-		fmt.Println(StylePrompt.Render("👋 Exiting without storing password."))
-		return
+	switch {
+	case !changed:
+		fmt.Println(StyleSuccess.Render("✅ " + c.Source + "/" + c.Username + " updated, the password is unchanged."))
+	case action == "update":
+		fmt.Println(StyleSuccess.Render("✅ Password for " + c.Source + "/" + c.Username + " overwritten successfully."))
+	default:
+		fmt.Println(StyleSuccess.Render("✅ Password stored in database successfully."))
 	}
+}
 
-	p := tea.NewProgram(initialStorePasswordModel(password))
-	m, err := p.Run()
+// storeCredential returns whether the entry was stored or updated and
+// whether its password changed.
+func storeCredential(c Credential) (action string, changed bool, err error) {
+	tx, err := DB.Begin()
 	if err != nil {
-		fmt.Println(StyleError.Render("Error running program: " + err.Error()))
-		os.Exit(1)
+		return "", false, err
 	}
-
-	finalModel := m.(StorePasswordModel)
-	username := finalModel.textInputs[0].Value()
-	source := finalModel.textInputs[1].Value()
-	url := BeautifyURL(finalModel.textInputs[2].Value())
-
-	if username == "" || source == "" {
-		fmt.Println(StylePrompt.Render("👋 Exiting without storing password."))
-		return
+	defer tx.Rollback()
+
+	action, changed = "update", true
+	var id int64
+	var current string
+	err = tx.QueryRow("SELECT id, password FROM passwords WHERE source = ? AND username = ? AND url = ? AND deleted_at IS NULL", c.Source, c.Username, c.URL).Scan(&id, &current)
+	switch {
+	case err == sql.ErrNoRows:
+		action = "store"
+		now := FormatDBTime(time.Now())
+		id, err = InsertEntry(tx, c.Source, c.Username, c.Password, c.URL, now, now)
+	case err != nil:
+	case current == c.Password:
+		// Archiving an unchanged password would restart its rotation.
+		changed = false
+	default:
+		err = ArchivePassword(tx, id)
+		if err == nil {
+			_, err = tx.Exec("UPDATE passwords SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", c.Password, id)
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec("UPDATE passwords SET folder = ?, tags = ?, notes = ? WHERE id = ?", c.Folder, FormatTags(c.Tags), c.Notes, id)
+	}
+	if err == nil {
+		detail := ""
+		if !changed {
+			detail = "password unchanged"
+		}
+		err = Audit(tx, action, id, c.Source+"/"+c.Username, detail)
 	}
-
-	_, err = DB.Exec(`
-		INSERT OR REPLACE INTO passwords (source, username, password, url, created_at, updated_at)
-		VALUES (?, ?, ?, ?, COALESCE((SELECT created_at FROM passwords WHERE source = ? AND username = ? AND url = ?), CURRENT_TIMESTAMP), CURRENT_TIMESTAMP)
-	`, source, username, password, url, source, username, url)
 	if err != nil {
-		fmt.Println(StyleError.Render("❌ Failed to store password in database: " + err.Error()))
-	} else {
-		fmt.Println(StyleSuccess.Render("✅ Password stored/updated in database successfully."))
+		return "", false, err
 	}
+	return action, changed, tx.Commit()
 }
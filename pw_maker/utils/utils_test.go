@@ -0,0 +1,56 @@
+package utils
+
+import "testing"
+
+func TestStoreCredential(t *testing.T) {
+	newTestAuditLog(t, 0)
+	store := func(c Credential, wantAction string, wantChanged bool) {
+		t.Helper()
+		action, changed, err := storeCredential(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if action != wantAction || changed != wantChanged {
+			t.Fatalf("storing %s/%s %s: got %s, changed %v, want %s, changed %v", c.Source, c.Username, c.URL, action, changed, wantAction, wantChanged)
+		}
+	}
+	history := func() (n int) {
+		t.Helper()
+		if err := DB.QueryRow("SELECT COUNT(*) FROM password_history").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	store(Credential{Source: "github", Username: "alice", Password: "one", URL: "https://github.com"}, "store", true)
+	DB.Exec("UPDATE passwords SET updated_at = '2026-01-01 10:00:00'")
+
+	// The same password only updates the details, without restarting the
+	// rotation or filling the history.
+	store(Credential{Source: "github", Username: "alice", Password: "one", URL: "https://github.com", Tags: []string{"work"}}, "update", false)
+	// Another URL is another account.
+	store(Credential{Source: "github", Username: "alice", Password: "two", URL: "https://github.example.com"}, "store", true)
+
+	credentials, err := GetCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credentials) != 2 {
+		t.Fatalf("got %d entries, want 2", len(credentials))
+	}
+	first := credentials[0]
+	if first.URL != "https://github.com" {
+		first = credentials[1]
+	}
+	if first.Password != "one" || FormatTags(first.Tags) != "work" || FormatDBTime(first.UpdatedAt) != "2026-01-01 10:00:00" {
+		t.Errorf("unchanged password: got %+v", first)
+	}
+	if n := history(); n != 0 {
+		t.Errorf("got %d archived passwords, want 0", n)
+	}
+
+	store(Credential{Source: "github", Username: "alice", Password: "three", URL: "https://github.com"}, "update", true)
+	if n := history(); n != 1 {
+		t.Errorf("got %d archived passwords after a new password, want 1", n)
+	}
+}